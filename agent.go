@@ -4,7 +4,12 @@
 
 package ai
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+)
 
 const (
 	AGENT_STATE_IDLE = "agent_state_idle"
@@ -19,6 +24,12 @@ type AgentFsmStateListener interface {
 	OnExitFsmState(state string, toState string)
 }
 
+// AgentFsmStateResetListener is an optional interface of
+// AgentFsmStateListener, OnResetFsmState is called when the FSM resets.
+type AgentFsmStateResetListener interface {
+	OnResetFsmState(state string)
+}
+
 type AgentFsmState struct {
 	name     string
 	listener AgentFsmStateListener
@@ -53,6 +64,13 @@ func (s *AgentFsmState) OnExit(toState string) {
 	}
 }
 
+func (s *AgentFsmState) OnReset() {
+	listener, ok := s.listener.(AgentFsmStateResetListener)
+	if ok {
+		listener.OnResetFsmState(s.name)
+	}
+}
+
 //========================
 //     AgentFsmAction
 //========================
@@ -93,8 +111,9 @@ type AgentBNodeListener interface {
 
 type AgentBNode struct {
 	*BaseBehaviorNode
-	listener AgentBNodeListener
-	params   []interface{}
+	listener   AgentBNodeListener
+	params     []interface{}
+	blackboard *Blackboard
 }
 
 func NewAgentBNode(nodeId uint32, actionId uint32, maxStep uint32, listener AgentBNodeListener, param ...interface{}) *AgentBNode {
@@ -102,13 +121,42 @@ func NewAgentBNode(nodeId uint32, actionId uint32, maxStep uint32, listener Agen
 		BaseBehaviorNode: NewBaseBehaviorNode(nodeId, actionId, maxStep),
 		listener:         listener,
 		params:           param,
+		blackboard:       nil,
 	}
 }
 
-func (a *AgentBNode) Execute() {
-	if a.listener != nil {
-		stat := a.listener.OnBNodeAction(a, a.params...)
-		a.SetState(stat)
+func (a *AgentBNode) CloneNode() BehaviorNode {
+	return &AgentBNode{
+		BaseBehaviorNode: a.cloneBase(),
+		listener:         a.listener,
+		params:           a.params,
+		blackboard:       nil,
+	}
+}
+
+// GetBlackboard returns the blackboard of the context the node is executed
+// with, the listener reads it through the node it receives.
+func (a *AgentBNode) GetBlackboard() *Blackboard {
+	return a.blackboard
+}
+
+// Execute counts the executions of the current run as steps, the node fails
+// when it is still executing at maxStep. A maxStep 0 means no limit.
+func (a *AgentBNode) Execute(ctx *BTreeContext) {
+	if a.listener == nil {
+		return
+	}
+
+	if a.state != BNODE_STAT_EXECUTING {
+		a.step = 0
+	}
+
+	a.blackboard = ctx.GetBlackboard()
+	a.UpdateStep()
+	stat := a.listener.OnBNodeAction(a, a.params...)
+	a.SetState(stat)
+	if stat == BNODE_STAT_EXECUTING && a.maxStep > 0 && a.step >= a.maxStep {
+		a.SetState(BNODE_STAT_FAIL)
 	}
 }
 
@@ -121,9 +169,46 @@ type AgentFsmStateExitFunc func(toState string)
 type AgentFsmActionFunc func(evt string, param ...interface{}) bool
 type AgentBNodeActionFunc func(node BehaviorNode, param ...interface{}) BNodeState
 
+// AgentFsmBBActionFunc and AgentBNodeBBActionFunc get the blackboard of the
+// agent as their first param, see AddActionWithBlackboard and
+// AddBNodeActionHandleFuncWithBlackboard.
+type AgentFsmBBActionFunc func(bb *Blackboard, evt string, param ...interface{}) bool
+type AgentBNodeBBActionFunc func(bb *Blackboard, node BehaviorNode, param ...interface{}) BNodeState
+
+type Message struct {
+	From  uint32
+	To    uint32
+	Event string
+	Param []interface{}
+}
+
+type agentEvent struct {
+	evt    string
+	params []interface{}
+}
+
+type agentCompletion struct {
+	succEvt string
+	failEvt string
+}
+
+// Sensor writes what the agent perceives into the blackboard.
+type Sensor interface {
+	Sense(agent Agent, bb *Blackboard)
+}
+
+// AgentUpdateHook is called by BaseAgent.Update around the update of the FSM.
+type AgentUpdateHook interface {
+	PreUpdate(dt int64)
+	PostUpdate(dt int64)
+}
+
 type Agent interface {
 	GetID() uint32
 	Update(dt int64)
+	UpdateCtx(ctx context.Context, dt int64)
+	OnMessage(msg Message)
+	Trigger(evt string, param ...interface{}) error
 }
 
 type BaseAgent struct {
@@ -133,27 +218,67 @@ type BaseAgent struct {
 	mapState2EnterFunc    map[string]AgentFsmStateEnterFunc
 	mapState2UpdateFunc   map[string]AgentFsmStateUpdateFunc
 	mapState2ExitFunc     map[string]AgentFsmStateExitFunc
+	mapState2AbortTree    map[string]bool
+	mapState2Completion   map[string]agentCompletion
+	completionEvents      []string
 	mapName2FsmActionFunc map[string]AgentFsmActionFunc
 	mapId2BNodeActionFunc map[uint32]AgentBNodeActionFunc
+	updateCtx             context.Context
+	postedEvents          []agentEvent
+	lckEvent              sync.Mutex
+	tickInterval          int64
+	tickElapsed           int64
+	updateHook            AgentUpdateHook
+	sensors               []Sensor
 }
 
 func NewBaseAgent(agentId uint32) *BaseAgent {
-	return &BaseAgent{
+	a := &BaseAgent{
 		agentId:               agentId,
 		fsm:                   NewFSM(agentId),
 		mapState2BTree:        make(map[string]*BehaviorTree),
 		mapState2EnterFunc:    make(map[string]AgentFsmStateEnterFunc),
 		mapState2UpdateFunc:   make(map[string]AgentFsmStateUpdateFunc),
 		mapState2ExitFunc:     make(map[string]AgentFsmStateExitFunc),
+		mapState2AbortTree:    make(map[string]bool),
+		mapState2Completion:   make(map[string]agentCompletion),
+		completionEvents:      make([]string, 0),
 		mapName2FsmActionFunc: make(map[string]AgentFsmActionFunc),
 		mapId2BNodeActionFunc: make(map[uint32]AgentBNodeActionFunc),
+		updateCtx:             nil,
+		postedEvents:          make([]agentEvent, 0),
+		tickInterval:          0,
+		tickElapsed:           0,
+		updateHook:            nil,
+		sensors:               make([]Sensor, 0),
 	}
+
+	a.fsm.SetBlackboard(NewBlackboard())
+	return a
 }
 
 func (a *BaseAgent) GetID() uint32 {
 	return a.agentId
 }
 
+// GetBlackboard returns the blackboard shared by the states, behavior trees
+// and actions of the agent.
+func (a *BaseAgent) GetBlackboard() *Blackboard {
+	return a.fsm.GetBlackboard()
+}
+
+func (a *BaseAgent) SetBlackboard(bb *Blackboard) {
+	a.fsm.SetBlackboard(bb)
+}
+
+func (a *BaseAgent) CanUpdateConcurrently() bool {
+	bb := a.fsm.GetBlackboard()
+	return bb == nil || !bb.IsShared()
+}
+
+func (a *BaseAgent) OnMessage(msg Message) {
+}
+
 func (a *BaseAgent) Start(firstState string) error {
 	return a.fsm.Start(firstState)
 }
@@ -162,29 +287,167 @@ func (a *BaseAgent) Stop() {
 	a.fsm.Stop()
 }
 
+// Reset resets the FSM, the behavior trees of the states are reset too.
+func (a *BaseAgent) Reset() {
+	a.fsm.Reset()
+	a.tickElapsed = 0
+	a.completionEvents = a.completionEvents[:0]
+}
+
+// SetTickInterval makes Update accumulate dt and update the FSM by steps
+// of intervalMs, the remainder is carried to the next Update. An
+// intervalMs <= 0 updates the FSM with dt directly.
+func (a *BaseAgent) SetTickInterval(intervalMs int64) {
+	a.tickInterval = intervalMs
+	a.tickElapsed = 0
+}
+
+func (a *BaseAgent) GetTickInterval() int64 {
+	return a.tickInterval
+}
+
+// SetUpdateHook sets the hook of Update, nil uses the agent itself. An agent
+// embedding BaseAgent passes itself to have its PreUpdate and PostUpdate
+// called.
+func (a *BaseAgent) SetUpdateHook(hook AgentUpdateHook) {
+	a.updateHook = hook
+}
+
+// AddSensor adds a sensor run by PreUpdate, the sensors run in adding order.
+func (a *BaseAgent) AddSensor(s Sensor) {
+	if s == nil {
+		return
+	}
+
+	a.sensors = append(a.sensors, s)
+}
+
+// PreUpdate runs the sensors, a hook overriding it should call it to keep
+// the sensors running.
+func (a *BaseAgent) PreUpdate(dt int64) {
+	bb := a.GetBlackboard()
+	for _, s := range a.sensors {
+		s.Sense(a, bb)
+	}
+}
+
+func (a *BaseAgent) PostUpdate(dt int64) {
+}
+
+// Update calls PreUpdate of the hook, triggers the posted events, updates the
+// FSM and calls PostUpdate of the hook.
 func (a *BaseAgent) Update(dt int64) {
+	hook := a.getUpdateHook()
+	hook.PreUpdate(dt)
+	a.dispatchEvents()
+	a.updateFsm(dt)
+	hook.PostUpdate(dt)
+}
+
+// UpdateCtx is Update with a context, once ctx is cancelled the behavior
+// tree of the current state stops executing nodes and leaves the unfinished
+// ones executing, they continue at the next update. A selector whose higher
+// priority check is cut off keeps its executing child.
+func (a *BaseAgent) UpdateCtx(ctx context.Context, dt int64) {
+	a.updateCtx = ctx
+	a.Update(dt)
+	a.updateCtx = nil
+}
+
+func (a *BaseAgent) getUpdateHook() AgentUpdateHook {
+	if a.updateHook != nil {
+		return a.updateHook
+	}
+
+	return a
+}
+
+func (a *BaseAgent) updateFsm(dt int64) {
+	if a.tickInterval <= 0 {
+		a.stepFsm(dt)
+		return
+	}
+
+	a.tickElapsed += dt
+	for a.tickElapsed >= a.tickInterval {
+		a.tickElapsed -= a.tickInterval
+		a.stepFsm(a.tickInterval)
+	}
+}
+
+// stepFsm updates the FSM then triggers the completion events queued by the
+// behavior trees during the update.
+func (a *BaseAgent) stepFsm(dt int64) {
 	a.fsm.Update(dt)
+
+	events := a.completionEvents
+	a.completionEvents = make([]string, 0)
+	for _, evt := range events {
+		err := a.fsm.Trigger(evt)
+		if err != nil {
+			log.Printf("agent %d: trigger completion event %s failed, %v", a.agentId, evt, err)
+		}
+	}
+}
+
+// PostEvent queues the event, the queued events are triggered in order at
+// the beginning of the next Update. Events posted while dispatching wait for
+// the update after.
+func (a *BaseAgent) PostEvent(evt string, param ...interface{}) {
+	a.lckEvent.Lock()
+	defer a.lckEvent.Unlock()
+
+	a.postedEvents = append(a.postedEvents, agentEvent{evt: evt, params: param})
+}
+
+func (a *BaseAgent) dispatchEvents() {
+	a.lckEvent.Lock()
+	events := a.postedEvents
+	a.postedEvents = make([]agentEvent, 0)
+	a.lckEvent.Unlock()
+
+	for _, e := range events {
+		err := a.fsm.Trigger(e.evt, e.params...)
+		if err != nil {
+			log.Printf("agent %d: trigger posted event %s failed, %v", a.agentId, e.evt, err)
+		}
+	}
 }
 
 func (a *BaseAgent) Trigger(evt string, param ...interface{}) error {
 	return a.fsm.Trigger(evt, param...)
 }
 
+func (a *BaseAgent) TriggerEx(evt string, param ...interface{}) (TriggerResult, error) {
+	return a.fsm.TriggerEx(evt, param...)
+}
+
 func (a *BaseAgent) PopState() error {
 	return a.fsm.PopState()
 }
 
 func (a *BaseAgent) AddState(name string, behaviorTree *BehaviorTree, enterFunc AgentFsmStateEnterFunc, updateFunc AgentFsmStateUpdateFunc, exitFunc AgentFsmStateExitFunc) error {
+	return a.AddStateEx(name, behaviorTree, enterFunc, updateFunc, exitFunc, false)
+}
+
+// AddStateEx is AddState, with abortTreeOnExit the behavior tree is aborted
+// when the state is exited.
+func (a *BaseAgent) AddStateEx(name string, behaviorTree *BehaviorTree, enterFunc AgentFsmStateEnterFunc, updateFunc AgentFsmStateUpdateFunc, exitFunc AgentFsmStateExitFunc, abortTreeOnExit bool) error {
 	if len(name) == 0 {
 		return errors.New("state is nil")
 	}
 
 	stat := NewAgentFsmState(name, a)
-	a.fsm.AddState(name, stat)
+	err := a.fsm.AddState(name, stat)
+	if err != nil {
+		return err
+	}
+
 	a.mapState2BTree[name] = behaviorTree
 	a.mapState2EnterFunc[name] = enterFunc
 	a.mapState2UpdateFunc[name] = updateFunc
 	a.mapState2ExitFunc[name] = exitFunc
+	a.mapState2AbortTree[name] = abortTreeOnExit
 	return nil
 }
 
@@ -214,20 +477,92 @@ func (a *BaseAgent) RemoveState(name string) error {
 		delete(a.mapState2ExitFunc, name)
 	}
 
+	_, ok = a.mapState2AbortTree[name]
+	if ok {
+		delete(a.mapState2AbortTree, name)
+	}
+
+	_, ok = a.mapState2Completion[name]
+	if ok {
+		delete(a.mapState2Completion, name)
+	}
+
+	return nil
+}
+
+// AddStateWithCompletion adds a state running behaviorTree, once the tree
+// succeeds or fails the agent triggers onSuccEvt or onFailEvt at the end of
+// the same FSM update, an empty event triggers nothing. The OnComplete
+// callback already set on the tree is kept and called first. The tree is
+// reset when the state is entered.
+func (a *BaseAgent) AddStateWithCompletion(name string, behaviorTree *BehaviorTree, onSuccEvt string, onFailEvt string) error {
+	if behaviorTree == nil {
+		return errors.New("behavior tree is nil")
+	}
+
+	err := a.AddState(name, behaviorTree, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	a.mapState2Completion[name] = agentCompletion{succEvt: onSuccEvt, failEvt: onFailEvt}
+	prev := behaviorTree.getOnComplete()
+	behaviorTree.OnComplete(func(state BNodeState) {
+		if prev != nil {
+			prev(state)
+		}
+
+		a.onBTreeComplete(name, state)
+	})
+
 	return nil
 }
 
+func (a *BaseAgent) onBTreeComplete(name string, state BNodeState) {
+	c, ok := a.mapState2Completion[name]
+	if !ok {
+		return
+	}
+
+	evt := c.failEvt
+	if state == BNODE_STAT_SUCC {
+		evt = c.succEvt
+	}
+
+	if len(evt) == 0 {
+		return
+	}
+
+	a.completionEvents = append(a.completionEvents, evt)
+}
+
 func (a *BaseAgent) AddAction(name string, actionFunc AgentFsmActionFunc) error {
 	if len(name) == 0 {
 		return errors.New("action is nil")
 	}
 
 	act := NewAgentFsmAction(name, a)
-	a.fsm.AddAction(name, act)
+	err := a.fsm.AddAction(name, act)
+	if err != nil {
+		return err
+	}
+
 	a.mapName2FsmActionFunc[name] = actionFunc
 	return nil
 }
 
+// AddActionWithBlackboard is AddAction with actionFunc getting the
+// blackboard of the agent.
+func (a *BaseAgent) AddActionWithBlackboard(name string, actionFunc AgentFsmBBActionFunc) error {
+	if actionFunc == nil {
+		return a.AddAction(name, nil)
+	}
+
+	return a.AddAction(name, func(evt string, param ...interface{}) bool {
+		return actionFunc(a.GetBlackboard(), evt, param...)
+	})
+}
+
 func (a *BaseAgent) RemoveAction(name string) error {
 	if len(name) == 0 {
 		return errors.New("action is nil")
@@ -260,35 +595,70 @@ func (a *BaseAgent) AddBNodeActionHandleFunc(actionId uint32, handleFunc AgentBN
 	return nil
 }
 
+// AddBNodeActionHandleFuncWithBlackboard is AddBNodeActionHandleFunc with
+// handleFunc getting the blackboard of the agent.
+func (a *BaseAgent) AddBNodeActionHandleFuncWithBlackboard(actionId uint32, handleFunc AgentBNodeBBActionFunc) error {
+	if handleFunc == nil {
+		return a.AddBNodeActionHandleFunc(actionId, nil)
+	}
+
+	return a.AddBNodeActionHandleFunc(actionId, func(node BehaviorNode, param ...interface{}) BNodeState {
+		return handleFunc(a.GetBlackboard(), node, param...)
+	})
+}
+
 func (a *BaseAgent) OnEnterFsmState(state string, fromState string) {
-	f, ok := a.mapState2EnterFunc[state]
+	_, ok := a.mapState2Completion[state]
 	if ok {
+		btree := a.mapState2BTree[state]
+		btree.Reset()
+	}
+
+	f, ok := a.mapState2EnterFunc[state]
+	if ok && f != nil {
 		f(fromState)
 	}
 }
 
 func (a *BaseAgent) OnUpdateFsmState(state string, dt int64) {
 	f, ok := a.mapState2UpdateFunc[state]
-	if ok {
+	if ok && f != nil {
 		f(dt)
 	} else {
 		btree, ok := a.mapState2BTree[state]
-		if ok {
-			btree.Execute()
+		if ok && btree != nil {
+			bctx := NewBTreeContext(a.fsm, dt)
+			bctx.SetContext(a.updateCtx)
+			btree.Execute(bctx)
 		}
 	}
 }
 
 func (a *BaseAgent) OnExitFsmState(state string, toState string) {
 	f, ok := a.mapState2ExitFunc[state]
-	if ok {
+	if ok && f != nil {
 		f(toState)
 	}
+
+	if a.mapState2AbortTree[state] {
+		btree, ok := a.mapState2BTree[state]
+		if ok && btree != nil {
+			btree.Abort()
+		}
+	}
+}
+
+// OnResetFsmState resets the behavior tree of the state.
+func (a *BaseAgent) OnResetFsmState(state string) {
+	btree, ok := a.mapState2BTree[state]
+	if ok && btree != nil {
+		btree.Reset()
+	}
 }
 
 func (a *BaseAgent) OnFsmAction(action string, evt string, param ...interface{}) bool {
 	f, ok := a.mapName2FsmActionFunc[action]
-	if ok {
+	if ok && f != nil {
 		return f(evt, param...)
 	}
 
@@ -298,7 +668,7 @@ func (a *BaseAgent) OnFsmAction(action string, evt string, param ...interface{})
 func (a *BaseAgent) OnBNodeAction(node BehaviorNode, param ...interface{}) BNodeState {
 	actionId := node.GetActionID()
 	f, ok := a.mapId2BNodeActionFunc[actionId]
-	if ok {
+	if ok && f != nil {
 		return f(node, param...)
 	}
 
@@ -0,0 +1,241 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import (
+	"runtime"
+	"sync"
+)
+
+// ConcurrentAgent is implemented by agents which know whether they can be
+// updated concurrently with other agents, e.g. a BaseAgent sharing its
+// blackboard with another agent can't.
+type ConcurrentAgent interface {
+	CanUpdateConcurrently() bool
+}
+
+type agentManagerOp struct {
+	bAdd    bool
+	agentId uint32
+	agent   Agent
+}
+
+// AgentManager updates the registered agents in registration order.
+// Add and Remove called during UpdateAll are applied after the update,
+// a removed agent is not updated any more in the current UpdateAll.
+type AgentManager struct {
+	agents              []Agent
+	mapId2Agent         map[uint32]Agent
+	bUpdating           bool
+	pendingOps          []*agentManagerOp
+	mapId2PendingRemove map[uint32]bool
+	lckPending          sync.Mutex
+	msgs                []Message
+	lckMsg              sync.Mutex
+}
+
+func NewAgentManager() *AgentManager {
+	return &AgentManager{
+		agents:              make([]Agent, 0),
+		mapId2Agent:         make(map[uint32]Agent),
+		bUpdating:           false,
+		pendingOps:          make([]*agentManagerOp, 0),
+		mapId2PendingRemove: make(map[uint32]bool),
+		msgs:                make([]Message, 0),
+	}
+}
+
+func (m *AgentManager) Add(a Agent) {
+	if a == nil {
+		return
+	}
+
+	if m.bUpdating {
+		m.lckPending.Lock()
+		defer m.lckPending.Unlock()
+
+		m.pendingOps = append(m.pendingOps, &agentManagerOp{bAdd: true, agentId: a.GetID(), agent: a})
+		return
+	}
+
+	m.addAgent(a)
+}
+
+func (m *AgentManager) Remove(agentId uint32) {
+	if m.bUpdating {
+		m.lckPending.Lock()
+		defer m.lckPending.Unlock()
+
+		m.pendingOps = append(m.pendingOps, &agentManagerOp{bAdd: false, agentId: agentId, agent: nil})
+		m.mapId2PendingRemove[agentId] = true
+		return
+	}
+
+	m.removeAgent(agentId)
+}
+
+func (m *AgentManager) Get(agentId uint32) (Agent, bool) {
+	a, ok := m.mapId2Agent[agentId]
+	return a, ok
+}
+
+func (m *AgentManager) GetCount() int {
+	return len(m.agents)
+}
+
+// Send queues the message, it is delivered to the OnMessage of the target
+// agent at the beginning of the next UpdateAll or UpdateAllConcurrent.
+// Messages to an unknown agent are dropped.
+func (m *AgentManager) Send(msg Message) {
+	m.lckMsg.Lock()
+	defer m.lckMsg.Unlock()
+
+	m.msgs = append(m.msgs, msg)
+}
+
+// Broadcast triggers the event on all the agents in registration order, the
+// errors, e.g. of agents without the transition, are ignored.
+func (m *AgentManager) Broadcast(evt string, param ...interface{}) {
+	for _, a := range m.agents {
+		if m.mapId2PendingRemove[a.GetID()] {
+			continue
+		}
+
+		a.Trigger(evt, param...)
+	}
+}
+
+func (m *AgentManager) UpdateAll(dt int64) {
+	if m.bUpdating {
+		return
+	}
+
+	m.dispatchMessages()
+
+	m.bUpdating = true
+	for _, a := range m.agents {
+		if m.mapId2PendingRemove[a.GetID()] {
+			continue
+		}
+
+		a.Update(dt)
+	}
+
+	m.bUpdating = false
+	m.applyPendingOps()
+}
+
+// UpdateAllConcurrent updates the agents by a pool of worker goroutines
+// and returns after all of them are updated. Agents must not share mutable
+// state with each other, a ConcurrentAgent reporting it can't be updated
+// concurrently is updated serially after the others. Add and Remove may be
+// called from the agents, a Remove won't skip agents already dispatched.
+func (m *AgentManager) UpdateAllConcurrent(dt int64, workers int) {
+	if m.bUpdating {
+		return
+	}
+
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	m.dispatchMessages()
+
+	m.bUpdating = true
+	serialAgents := make([]Agent, 0)
+	ch := make(chan Agent, len(m.agents))
+	for _, a := range m.agents {
+		if m.mapId2PendingRemove[a.GetID()] {
+			continue
+		}
+
+		ca, ok := a.(ConcurrentAgent)
+		if ok && !ca.CanUpdateConcurrently() {
+			serialAgents = append(serialAgents, a)
+			continue
+		}
+
+		ch <- a
+	}
+
+	close(ch)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for a := range ch {
+				a.Update(dt)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	for _, a := range serialAgents {
+		if m.mapId2PendingRemove[a.GetID()] {
+			continue
+		}
+
+		a.Update(dt)
+	}
+
+	m.bUpdating = false
+	m.applyPendingOps()
+}
+
+func (m *AgentManager) dispatchMessages() {
+	m.lckMsg.Lock()
+	msgs := m.msgs
+	m.msgs = make([]Message, 0)
+	m.lckMsg.Unlock()
+
+	for _, msg := range msgs {
+		a, ok := m.mapId2Agent[msg.To]
+		if ok {
+			a.OnMessage(msg)
+		}
+	}
+}
+
+func (m *AgentManager) applyPendingOps() {
+	for _, op := range m.pendingOps {
+		if op.bAdd {
+			m.addAgent(op.agent)
+		} else {
+			m.removeAgent(op.agentId)
+		}
+	}
+
+	m.pendingOps = m.pendingOps[:0]
+	m.mapId2PendingRemove = make(map[uint32]bool)
+}
+
+func (m *AgentManager) addAgent(a Agent) {
+	agentId := a.GetID()
+	_, ok := m.mapId2Agent[agentId]
+	if ok {
+		m.removeAgent(agentId)
+	}
+
+	m.agents = append(m.agents, a)
+	m.mapId2Agent[agentId] = a
+}
+
+func (m *AgentManager) removeAgent(agentId uint32) {
+	_, ok := m.mapId2Agent[agentId]
+	if !ok {
+		return
+	}
+
+	delete(m.mapId2Agent, agentId)
+	for i, a := range m.agents {
+		if a.GetID() == agentId {
+			m.agents = append(m.agents[:i], m.agents[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,312 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import (
+	"context"
+	"testing"
+)
+
+// testCountAgent counts its updates and calls onUpdate by each of them.
+type testCountAgent struct {
+	agentId  uint32
+	updates  int
+	onUpdate func()
+	bSerial  bool
+}
+
+func (a *testCountAgent) GetID() uint32 {
+	return a.agentId
+}
+
+func (a *testCountAgent) Update(dt int64) {
+	a.updates++
+	if a.onUpdate != nil {
+		a.onUpdate()
+	}
+}
+
+func (a *testCountAgent) UpdateCtx(ctx context.Context, dt int64) {
+	a.Update(dt)
+}
+
+func (a *testCountAgent) CanUpdateConcurrently() bool {
+	return !a.bSerial
+}
+
+func (a *testCountAgent) OnMessage(msg Message) {
+}
+
+func (a *testCountAgent) Trigger(evt string, param ...interface{}) error {
+	return nil
+}
+
+func newTestCountAgents(m *AgentManager, n int) []*testCountAgent {
+	agents := make([]*testCountAgent, 0, n)
+	for i := 0; i < n; i++ {
+		a := &testCountAgent{agentId: uint32(i + 1)}
+		agents = append(agents, a)
+		m.Add(a)
+	}
+
+	return agents
+}
+
+func TestAgentManagerUpdateAll(t *testing.T) {
+	m := NewAgentManager()
+	agents := newTestCountAgents(m, 3)
+	m.UpdateAll(10)
+	m.UpdateAll(10)
+
+	for _, a := range agents {
+		if a.updates != 2 {
+			t.Fatalf("agent %d updated %d times, want 2", a.agentId, a.updates)
+		}
+	}
+
+	m.Remove(2)
+	_, ok := m.Get(2)
+	if ok || m.GetCount() != 2 {
+		t.Fatalf("agent 2 not removed")
+	}
+}
+
+func TestAgentManagerAddRemoveDuringUpdate(t *testing.T) {
+	m := NewAgentManager()
+	agents := newTestCountAgents(m, 2)
+	added := &testCountAgent{agentId: 3}
+	agents[0].onUpdate = func() {
+		m.Remove(2)
+		m.Add(added)
+		agents[0].onUpdate = nil
+	}
+
+	m.UpdateAll(10)
+	cases := []struct {
+		agent   *testCountAgent
+		updates int
+	}{
+		{agents[0], 1},
+		{agents[1], 0},
+		{added, 0},
+	}
+
+	for _, c := range cases {
+		if c.agent.updates != c.updates {
+			t.Fatalf("agent %d updated %d times, want %d", c.agent.agentId, c.agent.updates, c.updates)
+		}
+	}
+
+	_, ok := m.Get(2)
+	if ok {
+		t.Fatalf("agent 2 not removed after the update")
+	}
+
+	m.UpdateAll(10)
+	if added.updates != 1 || agents[1].updates != 0 {
+		t.Fatalf("added %d removed %d updates, want 1 0", added.updates, agents[1].updates)
+	}
+}
+
+func TestAgentManagerUpdateAllConcurrent(t *testing.T) {
+	cases := []struct {
+		name    string
+		agents  int
+		workers int
+		serial  int
+	}{
+		{"one worker", 10, 1, 0},
+		{"workers", 100, 4, 0},
+		{"more workers than agents", 3, 8, 0},
+		{"default workers", 20, 0, 0},
+		{"serial agents", 20, 4, 5},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := NewAgentManager()
+			agents := newTestCountAgents(m, c.agents)
+			for i := 0; i < c.serial; i++ {
+				agents[i].bSerial = true
+			}
+
+			for i := 0; i < 3; i++ {
+				m.UpdateAllConcurrent(10, c.workers)
+			}
+
+			for _, a := range agents {
+				if a.updates != 3 {
+					t.Fatalf("agent %d updated %d times, want 3", a.agentId, a.updates)
+				}
+			}
+		})
+	}
+}
+
+func TestBaseAgentSharedBlackboardNotConcurrent(t *testing.T) {
+	a := NewBaseAgent(1)
+	b := NewBaseAgent(2)
+	c := NewBaseAgent(3)
+	b.SetBlackboard(a.GetBlackboard())
+
+	cases := []struct {
+		agent *BaseAgent
+		want  bool
+	}{
+		{a, false},
+		{b, false},
+		{c, true},
+	}
+
+	for _, tc := range cases {
+		if tc.agent.CanUpdateConcurrently() != tc.want {
+			t.Fatalf("agent %d CanUpdateConcurrently = %v, want %v", tc.agent.GetID(), !tc.want, tc.want)
+		}
+	}
+}
+
+// testMsgAgent triggers the events of the messages it gets.
+type testMsgAgent struct {
+	*BaseAgent
+	msgs []Message
+}
+
+func newTestMsgAgent(t *testing.T, agentId uint32) *testMsgAgent {
+	a := &testMsgAgent{BaseAgent: NewBaseAgent(agentId), msgs: make([]Message, 0)}
+	a.AddState("idle", nil, nil, nil, nil)
+	a.AddState("fight", nil, nil, nil, nil)
+	a.AddTransition("idle", "attack", "fight", "")
+	err := a.Start("idle")
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+
+	return a
+}
+
+func (a *testMsgAgent) OnMessage(msg Message) {
+	a.msgs = append(a.msgs, msg)
+	a.Trigger(msg.Event, msg.Param...)
+}
+
+func TestAgentManagerSend(t *testing.T) {
+	m := NewAgentManager()
+	attacker := newTestMsgAgent(t, 1)
+	target := newTestMsgAgent(t, 2)
+	m.Add(attacker)
+	m.Add(target)
+
+	m.Send(Message{From: 1, To: 2, Event: "attack", Param: []interface{}{5}})
+	m.Send(Message{From: 1, To: 9, Event: "attack"})
+	if target.fsm.GetCurState() != "idle" {
+		t.Fatalf("message delivered before UpdateAll")
+	}
+
+	m.UpdateAll(10)
+	if target.fsm.GetCurState() != "fight" {
+		t.Fatalf("target state %s, want fight", target.fsm.GetCurState())
+	}
+
+	if attacker.fsm.GetCurState() != "idle" || len(attacker.msgs) != 0 {
+		t.Fatalf("attacker got a message")
+	}
+
+	if len(target.msgs) != 1 || target.msgs[0].From != 1 || target.msgs[0].Param[0] != 5 {
+		t.Fatalf("target messages %v", target.msgs)
+	}
+}
+
+func TestAgentManagerSendDuringUpdate(t *testing.T) {
+	m := NewAgentManager()
+	target := newTestMsgAgent(t, 2)
+	sender := &testCountAgent{agentId: 1}
+	sender.onUpdate = func() {
+		m.Send(Message{From: 1, To: 2, Event: "attack"})
+		sender.onUpdate = nil
+	}
+
+	m.Add(sender)
+	m.Add(target)
+	m.UpdateAll(10)
+	if len(target.msgs) != 0 {
+		t.Fatalf("message sent during UpdateAll delivered in the same update")
+	}
+
+	m.UpdateAll(10)
+	if len(target.msgs) != 1 || target.fsm.GetCurState() != "fight" {
+		t.Fatalf("message not delivered by the next UpdateAll")
+	}
+}
+
+func TestAgentManagerBroadcast(t *testing.T) {
+	cases := []struct {
+		name  string
+		start string
+		want  string
+	}{
+		{"playing pauses", "play", "paused"},
+		{"menu ignores", "menu", "menu"},
+		{"playing pauses too", "play", "paused"},
+	}
+
+	m := NewAgentManager()
+	agents := make([]*BaseAgent, 0)
+	params := make([]interface{}, 0)
+	for i, c := range cases {
+		a := NewBaseAgent(uint32(i + 1))
+		for _, name := range []string{"play", "menu", "paused"} {
+			a.AddState(name, nil, nil, nil, nil)
+		}
+
+		a.AddAction("pause", func(evt string, param ...interface{}) bool {
+			params = append(params, param...)
+			return true
+		})
+		a.AddTransition("play", "world_paused", "paused", "pause")
+		a.Start(c.start)
+		agents = append(agents, a)
+		m.Add(a)
+	}
+
+	m.Broadcast("world_paused", "reason")
+	for i, c := range cases {
+		if agents[i].fsm.GetCurState() != c.want {
+			t.Fatalf("%s: agent %d state %s, want %s", c.name, agents[i].GetID(), agents[i].fsm.GetCurState(), c.want)
+		}
+	}
+
+	if len(params) != 2 || params[0] != "reason" {
+		t.Fatalf("action params %v, want the broadcast param twice", params)
+	}
+}
+
+func TestAgentManagerBroadcastSkipsRemoved(t *testing.T) {
+	m := NewAgentManager()
+	agents := make([]*BaseAgent, 0)
+	for i := 1; i <= 2; i++ {
+		a := NewBaseAgent(uint32(i))
+		a.AddState("play", nil, nil, nil, nil)
+		a.AddState("paused", nil, nil, nil, nil)
+		a.AddTransition("play", "world_paused", "paused", "")
+		a.Start("play")
+		agents = append(agents, a)
+		m.Add(a)
+	}
+
+	remover := &testCountAgent{agentId: 3}
+	remover.onUpdate = func() {
+		m.Remove(2)
+		m.Broadcast("world_paused")
+	}
+	m.Add(remover)
+	m.UpdateAll(10)
+
+	want := []string{"paused", "play"}
+	for i, a := range agents {
+		if a.fsm.GetCurState() != want[i] {
+			t.Fatalf("agent %d state %s, want %s", a.GetID(), a.fsm.GetCurState(), want[i])
+		}
+	}
+}
@@ -0,0 +1,268 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import "errors"
+
+//========================
+//     AgentTemplate
+//========================
+type agentTemplateState struct {
+	name         string
+	behaviorTree *BehaviorTree
+	enterFunc    AgentFsmStateEnterFunc
+	updateFunc   AgentFsmStateUpdateFunc
+	exitFunc     AgentFsmStateExitFunc
+	bAbortTree   bool
+	bCompletion  bool
+	succEvt      string
+	failEvt      string
+}
+
+type agentTemplateAction struct {
+	name         string
+	actionFunc   AgentFsmActionFunc
+	bbActionFunc AgentFsmBBActionFunc
+}
+
+// AgentTemplate records the registrations of an agent once, Instantiate
+// creates agents from it. The funcs are shared by the instances, each one
+// gets its own FSM and clones of the behavior trees.
+type AgentTemplate struct {
+	states                  []*agentTemplateState
+	mapName2State           map[string]*agentTemplateState
+	actions                 []*agentTemplateAction
+	mapName2Action          map[string]*agentTemplateAction
+	transitions             []*FSMTransition
+	mapId2BNodeActionFunc   map[uint32]AgentBNodeActionFunc
+	mapId2BNodeBBActionFunc map[uint32]AgentBNodeBBActionFunc
+}
+
+func NewAgentTemplate() *AgentTemplate {
+	return &AgentTemplate{
+		states:                  make([]*agentTemplateState, 0),
+		mapName2State:           make(map[string]*agentTemplateState),
+		actions:                 make([]*agentTemplateAction, 0),
+		mapName2Action:          make(map[string]*agentTemplateAction),
+		transitions:             make([]*FSMTransition, 0),
+		mapId2BNodeActionFunc:   make(map[uint32]AgentBNodeActionFunc),
+		mapId2BNodeBBActionFunc: make(map[uint32]AgentBNodeBBActionFunc),
+	}
+}
+
+func (t *AgentTemplate) AddState(name string, behaviorTree *BehaviorTree, enterFunc AgentFsmStateEnterFunc, updateFunc AgentFsmStateUpdateFunc, exitFunc AgentFsmStateExitFunc) error {
+	return t.AddStateEx(name, behaviorTree, enterFunc, updateFunc, exitFunc, false)
+}
+
+func (t *AgentTemplate) AddStateEx(name string, behaviorTree *BehaviorTree, enterFunc AgentFsmStateEnterFunc, updateFunc AgentFsmStateUpdateFunc, exitFunc AgentFsmStateExitFunc, abortTreeOnExit bool) error {
+	if len(name) == 0 {
+		return errors.New("state is nil")
+	}
+
+	_, ok := t.mapName2State[name]
+	if ok {
+		return ErrStatExist
+	}
+
+	s := &agentTemplateState{
+		name:         name,
+		behaviorTree: behaviorTree,
+		enterFunc:    enterFunc,
+		updateFunc:   updateFunc,
+		exitFunc:     exitFunc,
+		bAbortTree:   abortTreeOnExit,
+		bCompletion:  false,
+		succEvt:      "",
+		failEvt:      "",
+	}
+
+	t.states = append(t.states, s)
+	t.mapName2State[name] = s
+	return nil
+}
+
+// AddStateWithCompletion records a state added to the instances by
+// BaseAgent.AddStateWithCompletion with a clone of behaviorTree.
+func (t *AgentTemplate) AddStateWithCompletion(name string, behaviorTree *BehaviorTree, onSuccEvt string, onFailEvt string) error {
+	if behaviorTree == nil {
+		return errors.New("behavior tree is nil")
+	}
+
+	err := t.AddState(name, behaviorTree, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	s := t.mapName2State[name]
+	s.bCompletion = true
+	s.succEvt = onSuccEvt
+	s.failEvt = onFailEvt
+	return nil
+}
+
+func (t *AgentTemplate) AddAction(name string, actionFunc AgentFsmActionFunc) error {
+	return t.addAction(name, actionFunc, nil)
+}
+
+// AddActionWithBlackboard records an action added to the instances by
+// BaseAgent.AddActionWithBlackboard.
+func (t *AgentTemplate) AddActionWithBlackboard(name string, actionFunc AgentFsmBBActionFunc) error {
+	return t.addAction(name, nil, actionFunc)
+}
+
+func (t *AgentTemplate) addAction(name string, actionFunc AgentFsmActionFunc, bbActionFunc AgentFsmBBActionFunc) error {
+	if len(name) == 0 {
+		return errors.New("action is nil")
+	}
+
+	_, ok := t.mapName2Action[name]
+	if ok {
+		return ErrActExist
+	}
+
+	act := &agentTemplateAction{
+		name:         name,
+		actionFunc:   actionFunc,
+		bbActionFunc: bbActionFunc,
+	}
+
+	t.actions = append(t.actions, act)
+	t.mapName2Action[name] = act
+	return nil
+}
+
+// AddTransition records a transition, the states and the action, if any,
+// must be added already.
+func (t *AgentTemplate) AddTransition(from string, evt string, to string, action string) error {
+	_, ok := t.mapName2State[from]
+	if !ok {
+		return ErrFromStatNotExist
+	}
+
+	if len(evt) == 0 {
+		return ErrEvtEmpty
+	}
+
+	_, ok = t.mapName2State[to]
+	if !ok {
+		return ErrToStatNotExist
+	}
+
+	if len(action) != 0 {
+		_, ok = t.mapName2Action[action]
+		if !ok {
+			return ErrActNotExist
+		}
+	}
+
+	t.transitions = append(t.transitions, NewFSMTransition(from, evt, to, action))
+	return nil
+}
+
+func (t *AgentTemplate) AddBNodeActionHandleFunc(actionId uint32, handleFunc AgentBNodeActionFunc) error {
+	if t.hasBNodeActionHandleFunc(actionId) {
+		return errors.New("handle func exist")
+	}
+
+	t.mapId2BNodeActionFunc[actionId] = handleFunc
+	return nil
+}
+
+// AddBNodeActionHandleFuncWithBlackboard records a handle func added to the
+// instances by BaseAgent.AddBNodeActionHandleFuncWithBlackboard.
+func (t *AgentTemplate) AddBNodeActionHandleFuncWithBlackboard(actionId uint32, handleFunc AgentBNodeBBActionFunc) error {
+	if t.hasBNodeActionHandleFunc(actionId) {
+		return errors.New("handle func exist")
+	}
+
+	t.mapId2BNodeBBActionFunc[actionId] = handleFunc
+	return nil
+}
+
+func (t *AgentTemplate) hasBNodeActionHandleFunc(actionId uint32) bool {
+	_, ok := t.mapId2BNodeActionFunc[actionId]
+	if ok {
+		return true
+	}
+
+	_, ok = t.mapId2BNodeBBActionFunc[actionId]
+	return ok
+}
+
+// Instantiate creates an agent with the registrations of the template. The
+// AgentBNode of the cloned trees, subtrees included, call the new agent. The
+// first failed registration is returned with a nil agent.
+func (t *AgentTemplate) Instantiate(agentId uint32) (*BaseAgent, error) {
+	a := NewBaseAgent(agentId)
+	for _, s := range t.states {
+		var behaviorTree *BehaviorTree
+		if s.behaviorTree != nil {
+			behaviorTree = s.behaviorTree.Clone(s.behaviorTree.GetID())
+			bindAgentBNodes(behaviorTree.GetRootNode(), a)
+		}
+
+		var err error
+		if s.bCompletion {
+			err = a.AddStateWithCompletion(s.name, behaviorTree, s.succEvt, s.failEvt)
+		} else {
+			err = a.AddStateEx(s.name, behaviorTree, s.enterFunc, s.updateFunc, s.exitFunc, s.bAbortTree)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, act := range t.actions {
+		var err error
+		if act.bbActionFunc != nil {
+			err = a.AddActionWithBlackboard(act.name, act.bbActionFunc)
+		} else {
+			err = a.AddAction(act.name, act.actionFunc)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, tran := range t.transitions {
+		err := a.AddTransition(tran.From, tran.Event, tran.To, tran.Action)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for actionId, handleFunc := range t.mapId2BNodeActionFunc {
+		err := a.AddBNodeActionHandleFunc(actionId, handleFunc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for actionId, handleFunc := range t.mapId2BNodeBBActionFunc {
+		err := a.AddBNodeActionHandleFuncWithBlackboard(actionId, handleFunc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+func bindAgentBNodes(root BehaviorNode, listener AgentBNodeListener) {
+	Walk(root, func(node BehaviorNode, depth int) bool {
+		switch n := node.(type) {
+		case *AgentBNode:
+			n.listener = listener
+		case *SubtreeNode:
+			if n.subtree != nil {
+				bindAgentBNodes(n.subtree.GetRootNode(), listener)
+			}
+		}
+
+		return true
+	})
+}
@@ -0,0 +1,848 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestAgentUpdateCtxCancelKeepsRunningChild(t *testing.T) {
+	cctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bCancel := false
+	higher := NewSequenceNode(2)
+	higher.AddChild(newTestFuncNode(3, func() BNodeState {
+		if bCancel {
+			cancel()
+		}
+
+		return BNODE_STAT_SUCC
+	}))
+	higher.AddChild(NewScriptedNode(4, BNODE_STAT_FAIL))
+
+	lower := newTestAbortNode(5, BNODE_STAT_EXECUTING)
+	tree := newTestTree(newTestAbortLowerSelect(higher, lower))
+
+	a := NewBaseAgent(1)
+	a.AddState("work", tree, nil, nil, nil)
+	a.Start("work")
+	a.Update(10)
+	a.Update(10)
+	if lower.GetExecCount() != 1 {
+		t.Fatalf("lower executions = %d, want 1", lower.GetExecCount())
+	}
+
+	bCancel = true
+	a.UpdateCtx(cctx, 10)
+	if lower.aborts != 0 || lower.GetState() != BNODE_STAT_EXECUTING {
+		t.Fatalf("lower aborts %d, state %v", lower.aborts, lower.GetState())
+	}
+
+	bCancel = false
+	a.Update(10)
+	if lower.aborts != 0 || lower.GetExecCount() != 2 {
+		t.Fatalf("lower aborts %d, executions %d", lower.aborts, lower.GetExecCount())
+	}
+}
+
+func newTestCompletionTree(results ...BNodeState) *BehaviorTree {
+	return newTestTree(NewScriptedNode(2, results...))
+}
+
+func addTestCompletionTransitions(t testing.TB, add func(from string, evt string, to string, action string) error) {
+	for _, tran := range [][3]string{{"work", "done", "rest"}, {"work", "failed", "error"}} {
+		err := add(tran[0], tran[1], tran[2], "")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAgentAddStateWithCompletion(t *testing.T) {
+	cases := []struct {
+		name    string
+		results []BNodeState
+		want    string
+	}{
+		{"success", []BNodeState{BNODE_STAT_EXECUTING, BNODE_STAT_SUCC}, "rest"},
+		{"failure", []BNodeState{BNODE_STAT_FAIL}, "error"},
+		{"running", []BNodeState{BNODE_STAT_EXECUTING}, "work"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			a := NewBaseAgent(1)
+			err := a.AddStateWithCompletion("work", newTestCompletionTree(c.results...), "done", "failed")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			a.AddState("rest", nil, nil, nil, nil)
+			a.AddState("error", nil, nil, nil, nil)
+			addTestCompletionTransitions(t, a.AddTransition)
+			a.Start("work")
+			a.Update(10)
+			a.Update(10)
+			if a.fsm.GetCurState() != c.want {
+				t.Fatalf("state = %s, want %s", a.fsm.GetCurState(), c.want)
+			}
+		})
+	}
+}
+
+func TestAgentAddStateWithCompletionKeepsCallback(t *testing.T) {
+	tree := newTestCompletionTree(BNODE_STAT_SUCC)
+	var got []BNodeState
+	tree.OnComplete(func(state BNodeState) {
+		got = append(got, state)
+	})
+
+	a := NewBaseAgent(1)
+	a.AddStateWithCompletion("work", tree, "done", "failed")
+	a.AddState("rest", nil, nil, nil, nil)
+	a.AddState("error", nil, nil, nil, nil)
+	addTestCompletionTransitions(t, a.AddTransition)
+	a.Start("work")
+	a.Update(10)
+	if len(got) != 1 || got[0] != BNODE_STAT_SUCC {
+		t.Fatalf("previous callback calls = %v", got)
+	}
+
+	if a.fsm.GetCurState() != "rest" {
+		t.Fatalf("state = %s, want rest", a.fsm.GetCurState())
+	}
+}
+
+func TestAgentTemplateWithCompletion(t *testing.T) {
+	tmpl := NewAgentTemplate()
+	err := tmpl.AddStateWithCompletion("work", newTestCompletionTree(BNODE_STAT_SUCC), "done", "failed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl.AddState("rest", nil, nil, nil, nil)
+	tmpl.AddState("error", nil, nil, nil, nil)
+	addTestCompletionTransitions(t, tmpl.AddTransition)
+
+	for id := uint32(1); id <= 2; id++ {
+		a, err := tmpl.Instantiate(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		a.Start("work")
+		a.Update(10)
+		if a.fsm.GetCurState() != "rest" {
+			t.Fatalf("agent %d state = %s, want rest", id, a.fsm.GetCurState())
+		}
+	}
+}
+
+func TestAgentBlackboardShared(t *testing.T) {
+	a := NewBaseAgent(1)
+	var read interface{}
+	a.AddBNodeActionHandleFuncWithBlackboard(10, func(bb *Blackboard, node BehaviorNode, param ...interface{}) BNodeState {
+		read, _ = bb.Get("target")
+		return BNODE_STAT_SUCC
+	})
+
+	var actionBB *Blackboard
+	a.AddActionWithBlackboard("see", func(bb *Blackboard, evt string, param ...interface{}) bool {
+		actionBB = bb
+		return true
+	})
+
+	tree := newTestTree(NewAgentBNode(2, 10, 1, a))
+	a.AddState("idle", nil, nil, nil, nil)
+	a.AddState("chase", tree, func(fromState string) {
+		a.GetBlackboard().Set("target", uint32(7))
+	}, nil, nil)
+	a.AddTransition("idle", "see", "chase", "see")
+	a.Start("idle")
+
+	a.PostEvent("see")
+	a.Update(10)
+	if read != uint32(7) {
+		t.Fatalf("action read target %v, want 7", read)
+	}
+
+	if actionBB != a.GetBlackboard() {
+		t.Fatalf("fsm action didn't get the agent blackboard")
+	}
+}
+
+func TestAgentUpdateCtxCancelMidTick(t *testing.T) {
+	cases := []struct {
+		name      string
+		bCancelAt bool
+		bPre      bool
+		execs     []int
+	}{
+		{"cancelled mid tick", true, false, []int{1, 1, 0, 0}},
+		{"cancelled before", false, true, []int{0, 0, 0, 0}},
+		{"not cancelled", false, false, []int{1, 1, 1, 1}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if c.bPre {
+				cancel()
+			}
+
+			// the second child cancels the context when executed
+			execs := make([]int, 4)
+			tree := newTestTree()
+			for i := range execs {
+				idx := i
+				tree.GetRootNode().AddChild(newTestFuncNode(uint32(idx+2), func() BNodeState {
+					execs[idx]++
+					if idx == 1 && c.bCancelAt {
+						cancel()
+					}
+
+					return BNODE_STAT_SUCC
+				}))
+			}
+
+			a := NewBaseAgent(1)
+			a.AddState("work", tree, nil, nil, nil)
+			a.Start("work")
+			a.UpdateCtx(cctx, 10)
+
+			for i := range execs {
+				if execs[i] != c.execs[i] {
+					t.Fatalf("child %d executed %d times, want %d", i, execs[i], c.execs[i])
+				}
+			}
+
+			if !c.bCancelAt {
+				return
+			}
+
+			if tree.GetState() != BNODE_STAT_EXECUTING {
+				t.Fatalf("tree state %d, want executing", tree.GetState())
+			}
+
+			a.Update(10)
+			if execs[1] != 1 || execs[2] != 1 || tree.GetState() != BNODE_STAT_SUCC {
+				t.Fatalf("tree didn't resume after the cancel, executions %v", execs)
+			}
+		})
+	}
+}
+
+// testBNodeListener returns the next of its scripted states by each action.
+type testBNodeListener struct {
+	results []BNodeState
+	calls   int
+}
+
+func (l *testBNodeListener) OnBNodeAction(node BehaviorNode, param ...interface{}) BNodeState {
+	stat := l.results[l.calls]
+	if l.calls < len(l.results)-1 {
+		l.calls++
+	}
+
+	return stat
+}
+
+func TestAgentBNodeMaxStep(t *testing.T) {
+	E, S, F := BNODE_STAT_EXECUTING, BNODE_STAT_SUCC, BNODE_STAT_FAIL
+	cases := []struct {
+		name    string
+		maxStep uint32
+		results []BNodeState
+		want    []BNodeState
+	}{
+		{"never completes", 3, []BNodeState{E}, []BNodeState{E, E, F}},
+		{"completes in time", 3, []BNodeState{E, E, S}, []BNodeState{E, E, S}},
+		{"no limit", 0, []BNodeState{E}, []BNodeState{E, E, E, E, E}},
+		{"one step", 1, []BNodeState{E}, []BNodeState{F}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			n := NewAgentBNode(1, 10, c.maxStep, &testBNodeListener{results: c.results})
+			ctx := NewBTreeContext(nil, 0)
+			for i, want := range c.want {
+				n.Execute(ctx)
+				if n.GetState() != want {
+					t.Fatalf("step %d: state %d, want %d", i+1, n.GetState(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestAgentBNodeStepRestarts(t *testing.T) {
+	n := NewAgentBNode(1, 10, 2, &testBNodeListener{results: []BNodeState{BNODE_STAT_EXECUTING}})
+	ctx := NewBTreeContext(nil, 0)
+	n.Execute(ctx)
+	n.Execute(ctx)
+	n.Reset()
+
+	n.Execute(ctx)
+	if n.GetState() != BNODE_STAT_EXECUTING || n.GetStep() != 1 {
+		t.Fatalf("state %d step %d after Reset, want executing 1", n.GetState(), n.GetStep())
+	}
+}
+
+func TestAgentPostEvent(t *testing.T) {
+	a := NewBaseAgent(1)
+	entered := make([]string, 0)
+	for _, name := range []string{"a", "b", "c"} {
+		state := name
+		a.AddState(state, nil, func(fromState string) {
+			entered = append(entered, state)
+		}, nil, nil)
+	}
+
+	a.AddTransition("a", "go", "b", "")
+	a.AddTransition("b", "next", "c", "")
+	a.Start("a")
+	entered = entered[:0]
+
+	a.PostEvent("go")
+	a.PostEvent("next")
+	if a.fsm.GetCurState() != "a" {
+		t.Fatalf("posted event triggered before Update")
+	}
+
+	a.Update(10)
+	want := []string{"b", "c"}
+	if !equalTestStrings(entered, want) {
+		t.Fatalf("entered %v, want %v", entered, want)
+	}
+
+	a.Update(10)
+	if len(entered) != len(want) {
+		t.Fatalf("events triggered again, entered %v", entered)
+	}
+}
+
+func TestAgentPostEventFromEnter(t *testing.T) {
+	a := NewBaseAgent(1)
+	a.AddState("a", nil, nil, nil, nil)
+	a.AddState("b", nil, func(fromState string) { a.PostEvent("next") }, nil, nil)
+	a.AddState("c", nil, nil, nil, nil)
+	a.AddTransition("a", "go", "b", "")
+	a.AddTransition("b", "next", "c", "")
+	a.Start("a")
+
+	a.Trigger("go")
+	if a.fsm.GetCurState() != "b" {
+		t.Fatalf("state %s, want b", a.fsm.GetCurState())
+	}
+
+	a.Update(10)
+	if a.fsm.GetCurState() != "c" {
+		t.Fatalf("state %s after Update, want c", a.fsm.GetCurState())
+	}
+}
+
+func TestAgentTickInterval(t *testing.T) {
+	cases := []struct {
+		name     string
+		interval int64
+		dts      []int64
+		want     []int64
+	}{
+		{"pass through", 0, []int64{30, 170}, []int64{30, 170}},
+		{"irregular dt", 100, []int64{30, 50, 40, 250}, []int64{100, 100, 100}},
+		{"remainder carried", 100, []int64{99, 1}, []int64{100}},
+		{"under interval", 100, []int64{40, 40}, []int64{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ticks := make([]int64, 0)
+			a := NewBaseAgent(1)
+			a.AddState("a", nil, nil, func(dt int64) { ticks = append(ticks, dt) }, nil)
+			a.SetTickInterval(c.interval)
+			a.Start("a")
+			if a.GetTickInterval() != c.interval {
+				t.Fatalf("tick interval %d, want %d", a.GetTickInterval(), c.interval)
+			}
+
+			for _, dt := range c.dts {
+				a.Update(dt)
+			}
+
+			if fmt.Sprint(ticks) != fmt.Sprint(c.want) {
+				t.Fatalf("ticks %v, want %v", ticks, c.want)
+			}
+		})
+	}
+}
+
+func TestAgentTemplateInstancesIndependent(t *testing.T) {
+	tree := NewBehaviorTree(1)
+	tree.GetRootNode().AddChild(NewAgentBNode(2, 7, 0, nil))
+	tmpl := NewAgentTemplate()
+	tmpl.AddState("idle", nil, nil, nil, nil)
+	tmpl.AddState("patrol", tree, nil, nil, nil)
+	tmpl.AddState("attack", nil, nil, nil, nil)
+	tmpl.AddTransition("idle", "go", "patrol", "")
+	tmpl.AddTransition("patrol", "enemy", "attack", "")
+	tmpl.AddBNodeActionHandleFuncWithBlackboard(7, func(bb *Blackboard, node BehaviorNode, param ...interface{}) BNodeState {
+		v, _ := bb.Get("patrols")
+		n, _ := v.(int)
+		bb.Set("patrols", n+1)
+		return BNODE_STAT_SUCC
+	})
+
+	agents := make([]*BaseAgent, 0)
+	for id := uint32(1); id <= 2; id++ {
+		a, err := tmpl.Instantiate(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		a.Start("idle")
+		agents = append(agents, a)
+	}
+
+	cases := []struct {
+		name  string
+		agent int
+		evt   string
+		want  []string
+	}{
+		{"first goes", 0, "go", []string{"patrol", "idle"}},
+		{"second ignores enemy", 1, "enemy", []string{"patrol", "idle"}},
+		{"first attacks", 0, "enemy", []string{"attack", "idle"}},
+		{"second goes", 1, "go", []string{"attack", "patrol"}},
+	}
+
+	for _, c := range cases {
+		agents[c.agent].Trigger(c.evt)
+		for i, a := range agents {
+			if a.fsm.GetCurState() != c.want[i] {
+				t.Fatalf("%s: agent %d state %s, want %s", c.name, a.GetID(), a.fsm.GetCurState(), c.want[i])
+			}
+		}
+	}
+
+	agents[1].Update(10)
+	for i, want := range []interface{}{nil, 1} {
+		v, _ := agents[i].GetBlackboard().Get("patrols")
+		if v != want {
+			t.Fatalf("agent %d patrols %v, want %v", agents[i].GetID(), v, want)
+		}
+	}
+
+	if tree.IsCompleted() {
+		t.Fatalf("template tree executed by an instance")
+	}
+}
+
+// testHookAgent records its PreUpdate and PostUpdate calls in log.
+type testHookAgent struct {
+	*BaseAgent
+	log []string
+}
+
+func (a *testHookAgent) PreUpdate(dt int64) {
+	a.log = append(a.log, fmt.Sprintf("pre %d", dt))
+	a.BaseAgent.PreUpdate(dt)
+}
+
+func (a *testHookAgent) PostUpdate(dt int64) {
+	a.log = append(a.log, fmt.Sprintf("post %d", dt))
+}
+
+func TestAgentUpdateHookOrder(t *testing.T) {
+	cases := []struct {
+		name     string
+		interval int64
+		evt      string
+		want     []string
+	}{
+		{"pre fsm post", 0, "", []string{"pre 50", "fsm idle 50", "post 50"}},
+		{"events before fsm", 0, "go", []string{"pre 50", "action go", "fsm walk 50", "post 50"}},
+		{"skipped fsm tick", 100, "", []string{"pre 50", "post 50"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			a := &testHookAgent{BaseAgent: NewBaseAgent(1)}
+			a.SetUpdateHook(a)
+			a.SetTickInterval(c.interval)
+			for _, name := range []string{"idle", "walk"} {
+				state := name
+				a.AddState(state, nil, nil, func(dt int64) {
+					a.log = append(a.log, fmt.Sprintf("fsm %s %d", state, dt))
+				}, nil)
+			}
+
+			a.AddAction("move", func(evt string, param ...interface{}) bool {
+				a.log = append(a.log, "action "+evt)
+				return true
+			})
+			a.AddTransition("idle", "go", "walk", "move")
+			a.Start("idle")
+			if len(c.evt) > 0 {
+				a.PostEvent(c.evt)
+			}
+
+			a.Update(50)
+			if !equalTestStrings(a.log, c.want) {
+				t.Fatalf("calls %v, want %v", a.log, c.want)
+			}
+		})
+	}
+}
+
+func TestAgentDefaultUpdateHook(t *testing.T) {
+	a := NewBaseAgent(1)
+	updates := 0
+	a.AddState("idle", nil, nil, func(dt int64) { updates++ }, nil)
+	a.Start("idle")
+	a.Update(10)
+	a.SetUpdateHook(nil)
+	a.Update(10)
+	if updates != 2 {
+		t.Fatalf("fsm updated %d times, want 2", updates)
+	}
+}
+
+// testSensor writes its name and the count of its senses into key.
+type testSensor struct {
+	key   string
+	name  string
+	count int
+}
+
+func (s *testSensor) Sense(agent Agent, bb *Blackboard) {
+	s.count++
+	bb.Set(s.key, fmt.Sprintf("%s %d agent %d", s.name, s.count, agent.GetID()))
+}
+
+func TestAgentSensorFeedsTree(t *testing.T) {
+	cases := []struct {
+		name    string
+		sensors []*testSensor
+		want    []string
+	}{
+		{"one sensor", []*testSensor{{key: "enemy", name: "eye"}}, []string{"eye 1 agent 7", "eye 2 agent 7", "eye 3 agent 7"}},
+		{"adding order", []*testSensor{{key: "enemy", name: "eye"}, {key: "enemy", name: "ear"}}, []string{"ear 1 agent 7", "ear 2 agent 7", "ear 3 agent 7"}},
+		{"no sensor", nil, []string{"<nil>", "<nil>", "<nil>"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			a := NewBaseAgent(7)
+			a.AddSensor(nil)
+			for _, s := range c.sensors {
+				a.AddSensor(s)
+			}
+
+			seen := make([]string, 0)
+			tree := NewBehaviorTree(1)
+			tree.GetRootNode().AddChild(NewAgentBNode(2, 10, 0, a))
+			a.AddBNodeActionHandleFunc(10, func(node BehaviorNode, param ...interface{}) BNodeState {
+				v, _ := a.GetBlackboard().Get("enemy")
+				seen = append(seen, fmt.Sprint(v))
+				return BNODE_STAT_EXECUTING
+			})
+			a.AddState("hunt", tree, nil, nil, nil)
+			a.Start("hunt")
+
+			for range c.want {
+				a.Update(10)
+			}
+
+			if !equalTestStrings(seen, c.want) {
+				t.Fatalf("tree saw %v, want %v", seen, c.want)
+			}
+		})
+	}
+}
+
+// testResetListener records the states reset through AgentFsmState.
+type testResetListener struct {
+	resets []string
+}
+
+func (l *testResetListener) OnEnterFsmState(state string, fromState string) {
+}
+
+func (l *testResetListener) OnUpdateFsmState(state string, dt int64) {
+}
+
+func (l *testResetListener) OnExitFsmState(state string, toState string) {
+}
+
+func (l *testResetListener) OnResetFsmState(state string) {
+	l.resets = append(l.resets, state)
+}
+
+// testPlainStateListener is an AgentFsmStateListener without reset support.
+type testPlainStateListener struct{}
+
+func (l *testPlainStateListener) OnEnterFsmState(state string, fromState string) {
+}
+
+func (l *testPlainStateListener) OnUpdateFsmState(state string, dt int64) {
+}
+
+func (l *testPlainStateListener) OnExitFsmState(state string, toState string) {
+}
+
+func TestAgentFsmStateForwardsReset(t *testing.T) {
+	l := &testResetListener{}
+	f := NewFSM(1)
+	for _, name := range []string{"idle", "walk"} {
+		f.AddState(name, NewAgentFsmState(name, l))
+	}
+
+	f.AddState("silent", NewAgentFsmState("silent", &testPlainStateListener{}))
+	f.Start("idle")
+	f.Reset()
+	if !equalTestStrings(l.resets, []string{"idle", "walk"}) {
+		t.Fatalf("reset states %v, want [idle walk]", l.resets)
+	}
+}
+
+func TestAgentResetResetsTrees(t *testing.T) {
+	a := NewBaseAgent(1)
+	trees := make([]*BehaviorTree, 0)
+	for _, name := range []string{"idle", "walk"} {
+		tree := newTestTree(NewScriptedNode(2, BNODE_STAT_EXECUTING))
+		trees = append(trees, tree)
+		a.AddState(name, tree, nil, nil, nil)
+	}
+
+	a.AddTransition("idle", "go", "walk", "")
+	a.Start("idle")
+	a.Update(10)
+	a.Trigger("go")
+	a.Update(10)
+
+	a.Reset()
+	for i, tree := range trees {
+		if tree.GetState() != BNODE_STAT_NOT_EXECUTE {
+			t.Fatalf("tree %d state %s after Reset", i, tree.GetState())
+		}
+	}
+}
+
+func TestAgentAbortTreeOnExit(t *testing.T) {
+	E, S := BNODE_STAT_EXECUTING, BNODE_STAT_SUCC
+	cases := []struct {
+		name      string
+		bAbort    bool
+		aborts    int
+		want      BNodeState
+		firstRuns int
+	}{
+		{"abort on exit", true, 1, BNODE_STAT_NOT_EXECUTE, 2},
+		{"keep partial state", false, 0, E, 1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			first := NewScriptedNode(2, S)
+			running := newTestAbortNode(3, E)
+			tree := newTestTree(first, running)
+			a := NewBaseAgent(1)
+			a.AddStateEx("work", tree, nil, nil, nil, c.bAbort)
+			a.AddState("rest", nil, nil, nil, nil)
+			a.AddTransition("work", "tired", "rest", "")
+			a.AddTransition("rest", "wake", "work", "")
+			a.Start("work")
+			a.Update(10)
+			a.Update(10)
+
+			a.Trigger("tired")
+			if running.aborts != c.aborts || tree.GetState() != c.want {
+				t.Fatalf("aborts %d tree %s, want %d %s", running.aborts, tree.GetState(), c.aborts, c.want)
+			}
+
+			if c.bAbort && (first.GetState() != BNODE_STAT_NOT_EXECUTE || running.GetState() != BNODE_STAT_NOT_EXECUTE) {
+				t.Fatalf("half run nodes not reset: %s %s", first.GetState(), running.GetState())
+			}
+
+			a.Trigger("wake")
+			a.Update(10)
+			if first.GetExecCount() != c.firstRuns {
+				t.Fatalf("first node ran %d times, want %d", first.GetExecCount(), c.firstRuns)
+			}
+		})
+	}
+}
+
+func TestAgentAddStateWithCompletionReentry(t *testing.T) {
+	runs := 0
+	tree := newTestTree(newTestFuncNode(2, func() BNodeState {
+		runs++
+		return BNODE_STAT_SUCC
+	}))
+
+	a := NewBaseAgent(1)
+	a.AddStateWithCompletion("work", tree, "done", "failed")
+	a.AddState("rest", nil, nil, nil, nil)
+	a.AddState("error", nil, nil, nil, nil)
+	addTestCompletionTransitions(t, a.AddTransition)
+	a.AddTransition("rest", "back", "work", "")
+	a.Start("work")
+	for i := 0; i < 2; i++ {
+		a.Update(10)
+		if a.fsm.GetCurState() != "rest" {
+			t.Fatalf("round %d: state = %s, want rest", i, a.fsm.GetCurState())
+		}
+
+		err := a.Trigger("back")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if runs != 2 {
+		t.Fatalf("tree runs = %d, want 2", runs)
+	}
+}
+
+func TestAgentAddStateWithCompletionEmptyEvent(t *testing.T) {
+	a := NewBaseAgent(1)
+	err := a.AddStateWithCompletion("work", newTestCompletionTree(BNODE_STAT_SUCC), "", "failed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.AddState("rest", nil, nil, nil, nil)
+	a.AddState("error", nil, nil, nil, nil)
+	addTestCompletionTransitions(t, a.AddTransition)
+	a.Start("work")
+	a.Update(10)
+	a.Update(10)
+	if a.fsm.GetCurState() != "work" {
+		t.Fatalf("state = %s, want work", a.fsm.GetCurState())
+	}
+
+	err = a.AddStateWithCompletion("idle", nil, "done", "failed")
+	if err == nil {
+		t.Fatalf("nil tree added")
+	}
+}
+
+func TestAgentAddStateWithCompletionKeepsDelayedEvent(t *testing.T) {
+	a := NewBaseAgent(1)
+	a.AddStateWithCompletion("work", newTestCompletionTree(BNODE_STAT_SUCC), "done", "failed")
+	a.AddState("rest", nil, nil, nil, nil)
+	a.AddState("error", nil, nil, nil, nil)
+	addTestCompletionTransitions(t, a.AddTransition)
+	a.Start("work")
+	err := a.fsm.TriggerDelayed("done", 5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.Update(10)
+	if a.fsm.GetCurState() != "rest" {
+		t.Fatalf("state = %s, want rest", a.fsm.GetCurState())
+	}
+
+	if !a.fsm.CancelDelayed("done") {
+		t.Fatalf("delayed event cancelled by the tree completion")
+	}
+}
+
+func TestAgentCloneCompletionTree(t *testing.T) {
+	tree := newTestCompletionTree(BNODE_STAT_SUCC)
+	a := NewBaseAgent(1)
+	a.AddStateWithCompletion("work", tree, "done", "failed")
+	a.AddState("rest", nil, nil, nil, nil)
+	a.AddState("error", nil, nil, nil, nil)
+	addTestCompletionTransitions(t, a.AddTransition)
+	a.Start("work")
+
+	c := tree.Clone(2)
+	c.Execute(NewBTreeContext(nil, 10))
+	if c.GetRootNode().GetState() != BNODE_STAT_SUCC {
+		t.Fatalf("clone state = %s, want succ", c.GetRootNode().GetState())
+	}
+
+	if len(a.completionEvents) != 0 {
+		t.Fatalf("clone queued completion events %v on the source agent", a.completionEvents)
+	}
+
+	a.Update(10)
+	if a.fsm.GetCurState() != "rest" {
+		t.Fatalf("state = %s, want rest", a.fsm.GetCurState())
+	}
+}
+
+func TestAgentTemplateAddTransitionUnknown(t *testing.T) {
+	cases := []struct {
+		name   string
+		from   string
+		to     string
+		action string
+		want   error
+	}{
+		{"unknown from", "swim", "walk", "", ErrFromStatNotExist},
+		{"unknown to", "idle", "fly", "", ErrToStatNotExist},
+		{"unknown action", "idle", "walk", "jump", ErrActNotExist},
+		{"known", "idle", "walk", "move", nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tmpl := NewAgentTemplate()
+			tmpl.AddState("idle", nil, nil, nil, nil)
+			tmpl.AddState("walk", nil, nil, nil, nil)
+			tmpl.AddAction("move", func(evt string, param ...interface{}) bool {
+				return true
+			})
+
+			err := tmpl.AddTransition(c.from, "go", c.to, c.action)
+			if err != c.want {
+				t.Fatalf("err = %v, want %v", err, c.want)
+			}
+
+			a, err := tmpl.Instantiate(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, ok := a.fsm.GetTransition("idle", "go")
+			if ok != (c.want == nil) {
+				t.Fatalf("transition added = %v", ok)
+			}
+		})
+	}
+}
+
+func TestAgentTemplateActionWithBlackboard(t *testing.T) {
+	tmpl := NewAgentTemplate()
+	tmpl.AddState("idle", nil, nil, nil, nil)
+	tmpl.AddState("walk", nil, nil, nil, nil)
+	tmpl.AddActionWithBlackboard("move", func(bb *Blackboard, evt string, param ...interface{}) bool {
+		bb.Set("moved", evt)
+		return true
+	})
+	tmpl.AddTransition("idle", "go", "walk", "move")
+
+	for id := uint32(1); id <= 2; id++ {
+		a, err := tmpl.Instantiate(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		a.Start("idle")
+		a.Trigger("go")
+		v, _ := a.GetBlackboard().Get("moved")
+		if a.fsm.GetCurState() != "walk" || v != "go" {
+			t.Fatalf("agent %d state = %s, moved = %v", id, a.fsm.GetCurState(), v)
+		}
+	}
+}
@@ -4,6 +4,24 @@
 
 package ai
 
+import (
+	"errors"
+	"log"
+	"sync"
+)
+
+var (
+	ErrBNodeNil         = errors.New("behavior node is nil")
+	ErrBNodeIDDuplicate = errors.New("behavior node id duplicate")
+	ErrBTreeNoRoot      = errors.New("behavior tree has no root")
+	ErrBTreeMultiRoot   = errors.New("behavior tree has multi root")
+	ErrBTreeUnbalanced  = errors.New("behavior tree builder unbalanced")
+	ErrBTreeCycle       = errors.New("behavior tree has cycle")
+	ErrBNodeNoChild     = errors.New("behavior node has no child")
+	ErrBNodeNotExist    = errors.New("behavior node not exist")
+	ErrBTreeRootID      = errors.New("behavior tree root id invalid")
+)
+
 type BNodeState uint8
 
 const (
@@ -13,6 +31,21 @@ const (
 	BNODE_STAT_FAIL
 )
 
+func (s BNodeState) String() string {
+	switch s {
+	case BNODE_STAT_NOT_EXECUTE:
+		return "NOT_EXECUTE"
+	case BNODE_STAT_EXECUTING:
+		return "EXECUTING"
+	case BNODE_STAT_SUCC:
+		return "SUCC"
+	case BNODE_STAT_FAIL:
+		return "FAIL"
+	}
+
+	return "UNKNOWN"
+}
+
 type BNodeType uint8
 
 const (
@@ -20,6 +53,31 @@ const (
 	BNODE_TYPE_SEQUENCE
 	BNODE_TYPE_SELECT
 	BNODE_TYPE_PARALLEL
+	BNODE_TYPE_DECORATOR
+)
+
+func (t BNodeType) String() string {
+	switch t {
+	case BNODE_TYPE_ACTION:
+		return "ACTION"
+	case BNODE_TYPE_SEQUENCE:
+		return "SEQUENCE"
+	case BNODE_TYPE_SELECT:
+		return "SELECT"
+	case BNODE_TYPE_PARALLEL:
+		return "PARALLEL"
+	case BNODE_TYPE_DECORATOR:
+		return "DECORATOR"
+	}
+
+	return "UNKNOWN"
+}
+
+type BNodeAbortMode uint8
+
+const (
+	BNODE_ABORT_NONE BNodeAbortMode = iota
+	BNODE_ABORT_LOWER_PRIORITY
 )
 
 const (
@@ -29,6 +87,10 @@ const (
 //========================
 //     BehaviorNode
 //========================
+// BehaviorNode is implemented by the nodes of a tree. A custom node embeds
+// *BaseBehaviorNode for the default Reset, OnAbort, GetParent and Children
+// and implements Execute(ctx), a node written for the former Execute() is
+// wrapped by NewLegacyBNodeAdapter.
 type BehaviorNode interface {
 	GetID() uint32
 	GetActionID() uint32
@@ -38,12 +100,23 @@ type BehaviorNode interface {
 	GetMaxStep() uint32
 	GetState() BNodeState
 	IsCompleted() bool
-	Execute()
+	Execute(ctx *BTreeContext)
+	Reset()
+	OnAbort()
+	GetParent() (BehaviorNode, bool)
 
 	AddChild(child BehaviorNode)
 	RemoveChild(child BehaviorNode)
 	RemoveChildByID(nodeId uint32)
 	GetChildByID(nodeId uint32) (BehaviorNode, bool)
+	Children() []BehaviorNode
+}
+
+// BNodeCloner is implemented by the nodes supporting BehaviorTree.Clone,
+// CloneNode returns a deep copy of the node and its children with a fresh
+// runtime state.
+type BNodeCloner interface {
+	CloneNode() BehaviorNode
 }
 
 //========================
@@ -56,6 +129,8 @@ type BaseBehaviorNode struct {
 	state    BNodeState
 	step     uint32
 	maxStep  uint32
+	owner    BehaviorNode
+	parent   *BaseBehaviorNode
 }
 
 func NewBaseBehaviorNode(nodeId uint32, actionId uint32, maxStep uint32) *BaseBehaviorNode {
@@ -66,6 +141,8 @@ func NewBaseBehaviorNode(nodeId uint32, actionId uint32, maxStep uint32) *BaseBe
 		state:    BNODE_STAT_NOT_EXECUTE,
 		step:     0,
 		maxStep:  maxStep,
+		owner:    nil,
+		parent:   nil,
 	}
 }
 
@@ -81,6 +158,8 @@ func (n *BaseBehaviorNode) GetType() BNodeType {
 	return n.nodeType
 }
 
+// UpdateStep counts a step of the current run, the steps are zeroed by Reset,
+// so a composite reset before running a child again starts it from step 0.
 func (n *BaseBehaviorNode) UpdateStep() {
 	n.step++
 }
@@ -113,7 +192,60 @@ func (n *BaseBehaviorNode) IsCompleted() bool {
 	return false
 }
 
-func (n *BaseBehaviorNode) Execute()                       {}
+func (n *BaseBehaviorNode) Reset() {
+	n.state = BNODE_STAT_NOT_EXECUTE
+	n.step = 0
+}
+
+func (n *BaseBehaviorNode) cloneBase() *BaseBehaviorNode {
+	c := *n
+	c.state = BNODE_STAT_NOT_EXECUTE
+	c.step = 0
+	c.owner = nil
+	c.parent = nil
+	return &c
+}
+
+// GetParent returns the node the node was added to, false for a root or a
+// removed node.
+func (n *BaseBehaviorNode) GetParent() (BehaviorNode, bool) {
+	if n.parent == nil {
+		return nil, false
+	}
+
+	return n.parent.getOwner(), true
+}
+
+// getOwner returns the outermost node embedding n, the container nodes set
+// owner when created.
+func (n *BaseBehaviorNode) getOwner() BehaviorNode {
+	if n.owner != nil {
+		return n.owner
+	}
+
+	return n
+}
+
+func (n *BaseBehaviorNode) setParent(parent *BaseBehaviorNode) {
+	n.parent = parent
+}
+
+type bnodeParentSetter interface {
+	setParent(parent *BaseBehaviorNode)
+}
+
+func setBNodeParent(child BehaviorNode, parent *BaseBehaviorNode) {
+	setter, ok := child.(bnodeParentSetter)
+	if ok {
+		setter.setParent(parent)
+	}
+}
+
+// OnAbort is called when the node is interrupted while executing, the node
+// is reset right after.
+func (n *BaseBehaviorNode) OnAbort() {}
+
+func (n *BaseBehaviorNode) Execute(ctx *BTreeContext)      {}
 func (n *BaseBehaviorNode) AddChild(child BehaviorNode)    {}
 func (n *BaseBehaviorNode) RemoveChild(child BehaviorNode) {}
 func (n *BaseBehaviorNode) RemoveChildByID(nodeId uint32)  {}
@@ -121,9 +253,16 @@ func (n *BaseBehaviorNode) GetChildByID(nodeId uint32) (BehaviorNode, bool) {
 	return nil, false
 }
 
+func (n *BaseBehaviorNode) Children() []BehaviorNode {
+	return nil
+}
+
 //========================
 //     ControlNode
 //========================
+// ControlNode is the base of composite nodes. A node embedding ControlNode or
+// DecoratorNode sets owner to itself when created, GetParent of its children
+// returns it then.
 type ControlNode struct {
 	*BaseBehaviorNode
 	subNodes []BehaviorNode
@@ -136,6 +275,7 @@ func NewControlNode(nodeId uint32, nodeType BNodeType) *ControlNode {
 	}
 
 	n.nodeType = nodeType
+	n.owner = n
 	return n
 }
 
@@ -145,6 +285,33 @@ func (n *ControlNode) AddChild(child BehaviorNode) {
 	}
 
 	n.subNodes = append(n.subNodes, child)
+	setBNodeParent(child, n.BaseBehaviorNode)
+}
+
+// InsertChild inserts child before the child at index, an index < 0 inserts
+// it first and an index >= ChildCount appends it.
+func (n *ControlNode) InsertChild(index int, child BehaviorNode) {
+	if child == nil {
+		return
+	}
+
+	if index < 0 {
+		index = 0
+	}
+
+	if index >= len(n.subNodes) {
+		n.AddChild(child)
+		return
+	}
+
+	n.subNodes = append(n.subNodes, nil)
+	copy(n.subNodes[index+1:], n.subNodes[index:])
+	n.subNodes[index] = child
+	setBNodeParent(child, n.BaseBehaviorNode)
+}
+
+func (n *ControlNode) ChildCount() int {
+	return len(n.subNodes)
 }
 
 func (n *ControlNode) RemoveChild(child BehaviorNode) {
@@ -155,6 +322,7 @@ func (n *ControlNode) RemoveChild(child BehaviorNode) {
 	for i, exist := range n.subNodes {
 		if exist == child {
 			n.subNodes = append(n.subNodes[:i], n.subNodes[i+1:]...)
+			setBNodeParent(child, nil)
 			break
 		}
 	}
@@ -164,6 +332,7 @@ func (n *ControlNode) RemoveChildByID(nodeId uint32) {
 	for i, exist := range n.subNodes {
 		if exist.GetID() == nodeId {
 			n.subNodes = append(n.subNodes[:i], n.subNodes[i+1:]...)
+			setBNodeParent(exist, nil)
 			break
 		}
 	}
@@ -179,66 +348,252 @@ func (n *ControlNode) GetChildByID(nodeId uint32) (BehaviorNode, bool) {
 	return nil, false
 }
 
+func (n *ControlNode) Reset() {
+	n.BaseBehaviorNode.Reset()
+	for _, child := range n.subNodes {
+		child.Reset()
+	}
+}
+
+// Children returns the internal slice, callers must not modify it.
+func (n *ControlNode) Children() []BehaviorNode {
+	return n.subNodes
+}
+
+func (n *ControlNode) cloneControl() *ControlNode {
+	c := &ControlNode{
+		BaseBehaviorNode: n.cloneBase(),
+		subNodes:         make([]BehaviorNode, 0, len(n.subNodes)),
+	}
+
+	c.owner = c
+	return c
+}
+
+func (n *ControlNode) cloneChildren(dst BehaviorNode) {
+	for _, child := range n.subNodes {
+		dst.AddChild(cloneBNode(child))
+	}
+}
+
+//========================
+//     DecoratorNode
+//========================
+// DecoratorNode holds a single child, AddChild replaces it. The base
+// decorator mirrors the state of its child.
+type DecoratorNode struct {
+	*BaseBehaviorNode
+	child BehaviorNode
+}
+
+func NewDecoratorNode(nodeId uint32) *DecoratorNode {
+	n := &DecoratorNode{
+		BaseBehaviorNode: NewBaseBehaviorNode(nodeId, 0, 0),
+		child:            nil,
+	}
+
+	n.nodeType = BNODE_TYPE_DECORATOR
+	n.owner = n
+	return n
+}
+
+func (n *DecoratorNode) GetChild() BehaviorNode {
+	return n.child
+}
+
+func (n *DecoratorNode) AddChild(child BehaviorNode) {
+	if child == nil {
+		return
+	}
+
+	if n.child != nil {
+		setBNodeParent(n.child, nil)
+	}
+
+	n.child = child
+	setBNodeParent(child, n.BaseBehaviorNode)
+}
+
+func (n *DecoratorNode) RemoveChild(child BehaviorNode) {
+	if child == nil {
+		return
+	}
+
+	if n.child == child {
+		setBNodeParent(child, nil)
+		n.child = nil
+	}
+}
+
+func (n *DecoratorNode) RemoveChildByID(nodeId uint32) {
+	if n.child != nil && n.child.GetID() == nodeId {
+		setBNodeParent(n.child, nil)
+		n.child = nil
+	}
+}
+
+func (n *DecoratorNode) GetChildByID(nodeId uint32) (BehaviorNode, bool) {
+	if n.child != nil && n.child.GetID() == nodeId {
+		return n.child, true
+	}
+
+	return nil, false
+}
+
+func (n *DecoratorNode) Execute(ctx *BTreeContext) {
+	if n.IsCompleted() {
+		return
+	}
+
+	if n.child == nil {
+		n.state = BNODE_STAT_FAIL
+		return
+	}
+
+	executeBNode(ctx, n.child)
+	n.state = n.child.GetState()
+}
+
+func (n *DecoratorNode) Reset() {
+	n.BaseBehaviorNode.Reset()
+	if n.child != nil {
+		n.child.Reset()
+	}
+}
+
+func (n *DecoratorNode) Children() []BehaviorNode {
+	if n.child == nil {
+		return nil
+	}
+
+	return []BehaviorNode{n.child}
+}
+
+func (n *DecoratorNode) cloneDecorator() *DecoratorNode {
+	c := &DecoratorNode{
+		BaseBehaviorNode: n.cloneBase(),
+		child:            nil,
+	}
+
+	c.owner = c
+	if n.child != nil {
+		c.AddChild(cloneBNode(n.child))
+	}
+
+	return c
+}
+
 //========================
 //     SequenceNode
 //========================
+// SequenceNode succeeds when all its children succeed, an empty one succeeds.
 type SequenceNode struct {
 	*ControlNode
 }
 
 func NewSequenceNode(nodeId uint32) *SequenceNode {
-	return &SequenceNode{
+	n := &SequenceNode{
 		ControlNode: NewControlNode(nodeId, BNODE_TYPE_SEQUENCE),
 	}
+
+	n.owner = n
+	return n
+}
+
+func (n *SequenceNode) CloneNode() BehaviorNode {
+	c := &SequenceNode{
+		ControlNode: n.cloneControl(),
+	}
+
+	c.owner = c
+	n.cloneChildren(c)
+	return c
 }
 
-func (n *SequenceNode) Execute() {
+func (n *SequenceNode) Execute(ctx *BTreeContext) {
 	if n.IsCompleted() {
 		return
 	}
 
 	n.state = BNODE_STAT_EXECUTING
 
-	childLen := len(n.subNodes)
-	for i, child := range n.subNodes {
-		if child.IsCompleted() {
+	for _, child := range n.subNodes {
+		stat := child.GetState()
+		if stat == BNODE_STAT_SUCC {
 			continue
 		}
 
-		child.Execute()
-		if !child.IsCompleted() {
-			break
+		if stat != BNODE_STAT_FAIL {
+			executeBNode(ctx, child)
+			if !child.IsCompleted() {
+				return
+			}
 		}
 
 		if child.GetState() == BNODE_STAT_FAIL {
 			n.state = BNODE_STAT_FAIL
-		} else if i == childLen-1 {
-			n.state = BNODE_STAT_SUCC
+			return
 		}
-
-		break
 	}
+
+	n.state = BNODE_STAT_SUCC
 }
 
 //========================
 //     SelectNode
 //========================
+// SelectNode succeeds when a child succeeds, an empty one fails.
 type SelectNode struct {
 	*ControlNode
+	abortMode BNodeAbortMode
 }
 
 func NewSelectNode(nodeId uint32) *SelectNode {
-	return &SelectNode{
+	n := &SelectNode{
 		ControlNode: NewControlNode(nodeId, BNODE_TYPE_SELECT),
+		abortMode:   BNODE_ABORT_NONE,
+	}
+
+	n.owner = n
+	return n
+}
+
+// SetAbortMode sets how the node handles the failed children before the
+// executing one. With BNODE_ABORT_LOWER_PRIORITY they are executed again
+// every tick, the executing child is aborted once one of them doesn't fail.
+func (n *SelectNode) SetAbortMode(mode BNodeAbortMode) {
+	n.abortMode = mode
+}
+
+func (n *SelectNode) GetAbortMode() BNodeAbortMode {
+	return n.abortMode
+}
+
+func (n *SelectNode) CloneNode() BehaviorNode {
+	c := &SelectNode{
+		ControlNode: n.cloneControl(),
+		abortMode:   n.abortMode,
 	}
+
+	c.owner = c
+	n.cloneChildren(c)
+	return c
 }
 
-func (n *SelectNode) Execute() {
+func (n *SelectNode) Execute(ctx *BTreeContext) {
 	if n.IsCompleted() {
 		return
 	}
 
+	if len(n.subNodes) == 0 {
+		n.state = BNODE_STAT_FAIL
+		return
+	}
+
 	n.state = BNODE_STAT_EXECUTING
+	if n.abortMode == BNODE_ABORT_LOWER_PRIORITY && n.checkHigherPriority(ctx) {
+		return
+	}
 
 	childLen := len(n.subNodes)
 	for i, child := range n.subNodes {
@@ -246,7 +601,7 @@ func (n *SelectNode) Execute() {
 			continue
 		}
 
-		child.Execute()
+		executeBNode(ctx, child)
 		if !child.IsCompleted() {
 			break
 		}
@@ -261,20 +616,286 @@ func (n *SelectNode) Execute() {
 	}
 }
 
+// checkHigherPriority executes the children before the executing one, a
+// child cut off by a cancel or the tick budget is aborted and the check stops
+// there, the executing child is kept.
+func (n *SelectNode) checkHigherPriority(ctx *BTreeContext) bool {
+	running := -1
+	for i, child := range n.subNodes {
+		if child.GetState() == BNODE_STAT_EXECUTING {
+			running = i
+			break
+		}
+	}
+
+	for i := 0; i < running; i++ {
+		child := n.subNodes[i]
+		child.Reset()
+		if !executeBNodeFully(ctx, child) {
+			abortBNode(child)
+			return true
+		}
+
+		if child.GetState() == BNODE_STAT_FAIL {
+			continue
+		}
+
+		abortBNode(n.subNodes[running])
+		if child.GetState() == BNODE_STAT_SUCC {
+			n.state = BNODE_STAT_SUCC
+		}
+
+		return true
+	}
+
+	return false
+}
+
+//========================
+//     MemSequenceNode
+//========================
+// MemSequenceNode remembers the running child and resumes there next tick,
+// children before it are not executed again until Reset.
+type MemSequenceNode struct {
+	*ControlNode
+	runningIndex int
+}
+
+func NewMemSequenceNode(nodeId uint32) *MemSequenceNode {
+	n := &MemSequenceNode{
+		ControlNode:  NewControlNode(nodeId, BNODE_TYPE_SEQUENCE),
+		runningIndex: 0,
+	}
+
+	n.owner = n
+	return n
+}
+
+func (n *MemSequenceNode) CloneNode() BehaviorNode {
+	c := &MemSequenceNode{
+		ControlNode:  n.cloneControl(),
+		runningIndex: 0,
+	}
+
+	c.owner = c
+	n.cloneChildren(c)
+	return c
+}
+
+func (n *MemSequenceNode) Execute(ctx *BTreeContext) {
+	if n.IsCompleted() {
+		return
+	}
+
+	n.state = BNODE_STAT_EXECUTING
+
+	for n.runningIndex < len(n.subNodes) {
+		child := n.subNodes[n.runningIndex]
+		executeBNode(ctx, child)
+		if !child.IsCompleted() {
+			return
+		}
+
+		if child.GetState() == BNODE_STAT_FAIL {
+			n.state = BNODE_STAT_FAIL
+			return
+		}
+
+		n.runningIndex++
+	}
+
+	n.state = BNODE_STAT_SUCC
+}
+
+func (n *MemSequenceNode) Reset() {
+	n.ControlNode.Reset()
+	n.runningIndex = 0
+}
+
+//========================
+//     MemSelectNode
+//========================
+// MemSelectNode remembers the running child and resumes there next tick,
+// children before it are not executed again until Reset.
+type MemSelectNode struct {
+	*ControlNode
+	runningIndex int
+}
+
+func NewMemSelectNode(nodeId uint32) *MemSelectNode {
+	n := &MemSelectNode{
+		ControlNode:  NewControlNode(nodeId, BNODE_TYPE_SELECT),
+		runningIndex: 0,
+	}
+
+	n.owner = n
+	return n
+}
+
+func (n *MemSelectNode) CloneNode() BehaviorNode {
+	c := &MemSelectNode{
+		ControlNode:  n.cloneControl(),
+		runningIndex: 0,
+	}
+
+	c.owner = c
+	n.cloneChildren(c)
+	return c
+}
+
+func (n *MemSelectNode) Execute(ctx *BTreeContext) {
+	if n.IsCompleted() {
+		return
+	}
+
+	n.state = BNODE_STAT_EXECUTING
+
+	for n.runningIndex < len(n.subNodes) {
+		child := n.subNodes[n.runningIndex]
+		executeBNode(ctx, child)
+		if !child.IsCompleted() {
+			return
+		}
+
+		if child.GetState() == BNODE_STAT_SUCC {
+			n.state = BNODE_STAT_SUCC
+			return
+		}
+
+		n.runningIndex++
+	}
+
+	n.state = BNODE_STAT_FAIL
+}
+
+func (n *MemSelectNode) Reset() {
+	n.ControlNode.Reset()
+	n.runningIndex = 0
+}
+
+//========================
+//   ScopedSequenceNode
+//========================
+type BNodeCleanupFunc func(node BehaviorNode)
+
+// ScopedSequenceNode is a memory sequence which runs the cleanups of the
+// already succeeded children in reverse order when it fails or is aborted
+// by Reset while executing.
+type ScopedSequenceNode struct {
+	*MemSequenceNode
+	mapChild2Cleanup map[BehaviorNode]BNodeCleanupFunc
+}
+
+func NewScopedSequenceNode(nodeId uint32) *ScopedSequenceNode {
+	n := &ScopedSequenceNode{
+		MemSequenceNode:  NewMemSequenceNode(nodeId),
+		mapChild2Cleanup: make(map[BehaviorNode]BNodeCleanupFunc),
+	}
+
+	n.owner = n
+	return n
+}
+
+func (n *ScopedSequenceNode) AddChildWithCleanup(child BehaviorNode, cleanup BNodeCleanupFunc) {
+	if child == nil {
+		return
+	}
+
+	n.AddChild(child)
+	if cleanup != nil {
+		n.mapChild2Cleanup[child] = cleanup
+	}
+}
+
+func (n *ScopedSequenceNode) CloneNode() BehaviorNode {
+	c := &ScopedSequenceNode{
+		MemSequenceNode: &MemSequenceNode{
+			ControlNode:  n.cloneControl(),
+			runningIndex: 0,
+		},
+		mapChild2Cleanup: make(map[BehaviorNode]BNodeCleanupFunc),
+	}
+
+	c.owner = c
+
+	for _, child := range n.subNodes {
+		c.AddChildWithCleanup(cloneBNode(child), n.mapChild2Cleanup[child])
+	}
+
+	return c
+}
+
+func (n *ScopedSequenceNode) RemoveChild(child BehaviorNode) {
+	n.MemSequenceNode.RemoveChild(child)
+	delete(n.mapChild2Cleanup, child)
+}
+
+func (n *ScopedSequenceNode) RemoveChildByID(nodeId uint32) {
+	child, ok := n.GetChildByID(nodeId)
+	if ok {
+		n.RemoveChild(child)
+	}
+}
+
+func (n *ScopedSequenceNode) Execute(ctx *BTreeContext) {
+	if n.IsCompleted() {
+		return
+	}
+
+	n.MemSequenceNode.Execute(ctx)
+	if n.state == BNODE_STAT_FAIL {
+		n.cleanup()
+	}
+}
+
+func (n *ScopedSequenceNode) Reset() {
+	if n.state == BNODE_STAT_EXECUTING {
+		n.cleanup()
+	}
+
+	n.MemSequenceNode.Reset()
+}
+
+func (n *ScopedSequenceNode) cleanup() {
+	for i := n.runningIndex - 1; i >= 0; i-- {
+		child := n.subNodes[i]
+		f, ok := n.mapChild2Cleanup[child]
+		if ok {
+			f(child)
+		}
+	}
+}
+
 //========================
 //     ParallelNode
 //========================
+// ParallelNode executes all its children each tick and fails on the first
+// failed child, aborting the children still executing. An empty one
+// succeeds.
 type ParallelNode struct {
 	*ControlNode
 }
 
 func NewParallelNode(nodeId uint32) *ParallelNode {
-	return &ParallelNode{
+	n := &ParallelNode{
 		ControlNode: NewControlNode(nodeId, BNODE_TYPE_PARALLEL),
 	}
+
+	n.owner = n
+	return n
+}
+
+func (n *ParallelNode) CloneNode() BehaviorNode {
+	c := &ParallelNode{
+		ControlNode: n.cloneControl(),
+	}
+
+	c.owner = c
+	n.cloneChildren(c)
+	return c
 }
 
-func (n *ParallelNode) Execute() {
+func (n *ParallelNode) Execute(ctx *BTreeContext) {
 	if n.IsCompleted() {
 		return
 	}
@@ -287,7 +908,7 @@ func (n *ParallelNode) Execute() {
 			continue
 		}
 
-		child.Execute()
+		executeBNode(ctx, child)
 		if !child.IsCompleted() {
 			bFinish = false
 			continue
@@ -299,26 +920,168 @@ func (n *ParallelNode) Execute() {
 		}
 	}
 
-	if bFinish && (n.state == BNODE_STAT_EXECUTING) {
+	if n.state == BNODE_STAT_FAIL {
+		n.abortExecutingChildren()
+		return
+	}
+
+	if bFinish {
+		n.state = BNODE_STAT_SUCC
+	}
+}
+
+func (n *ParallelNode) abortExecutingChildren() {
+	for _, child := range n.subNodes {
+		if child.GetState() == BNODE_STAT_EXECUTING {
+			abortBNode(child)
+		}
+	}
+}
+
+//========================
+//  StateScopedConditionNode
+//========================
+type ConditionFunc func(param ...interface{}) bool
+
+// StateScopedConditionNode evaluates its condition once per entry of the
+// current FSM state and reuses the result until the state is entered again.
+// Without an FSM in the context the condition is evaluated every execution.
+type StateScopedConditionNode struct {
+	*BaseBehaviorNode
+	cond            ConditionFunc
+	params          []interface{}
+	bCached         bool
+	result          bool
+	cacheState      string
+	cacheEntryCount uint32
+	cacheGeneration uint32
+}
+
+func NewStateScopedConditionNode(nodeId uint32, cond ConditionFunc, param ...interface{}) *StateScopedConditionNode {
+	return &StateScopedConditionNode{
+		BaseBehaviorNode: NewBaseBehaviorNode(nodeId, 0, 0),
+		cond:             cond,
+		params:           param,
+		bCached:          false,
+		result:           false,
+		cacheState:       "",
+		cacheEntryCount:  0,
+		cacheGeneration:  0,
+	}
+}
+
+func (n *StateScopedConditionNode) CloneNode() BehaviorNode {
+	return &StateScopedConditionNode{
+		BaseBehaviorNode: n.cloneBase(),
+		cond:             n.cond,
+		params:           n.params,
+		bCached:          false,
+		result:           false,
+		cacheState:       "",
+		cacheEntryCount:  0,
+		cacheGeneration:  0,
+	}
+}
+
+func (n *StateScopedConditionNode) Execute(ctx *BTreeContext) {
+	if n.cond == nil {
+		n.state = BNODE_STAT_FAIL
+		return
+	}
+
+	fsmState := ctx.GetCurState()
+	entryCount := ctx.GetStateEntryCount()
+	generation := ctx.GetFSMGeneration()
+	bCacheValid := n.bCached && (ctx.GetFSM() != nil) && (n.cacheState == fsmState) && (n.cacheEntryCount == entryCount) && (n.cacheGeneration == generation)
+	if !bCacheValid {
+		n.result = n.cond(n.params...)
+		n.bCached = true
+		n.cacheState = fsmState
+		n.cacheEntryCount = entryCount
+		n.cacheGeneration = generation
+	}
+
+	if n.result {
 		n.state = BNODE_STAT_SUCC
+	} else {
+		n.state = BNODE_STAT_FAIL
 	}
 }
 
 //========================
 //      BehaviorTree
 //========================
+type NodeStats struct {
+	ExecuteCount   uint32
+	SuccCount      uint32
+	FailCount      uint32
+	ExecutingTicks uint32
+}
+
+type TraceEntry struct {
+	NodeID uint32
+	State  BNodeState
+}
+
+type BNodeStateListener func(nodeId uint32, from BNodeState, to BNodeState)
+
+type BTreeCompleteFunc func(state BNodeState)
+
+// BehaviorTree is lock free by default. In thread safe mode Execute, Reset,
+// the queries and the tree level AddChild / RemoveChild are serialized, so
+// the structure must be mutated through the tree, not the nodes. Nodes must
+// not call these methods of their own tree while it is executing.
 type BehaviorTree struct {
-	treeId   uint32
-	rootNode BehaviorNode
+	treeId         uint32
+	rootNode       BehaviorNode
+	bStats         bool
+	mapId2NodeStat map[uint32]*NodeStats
+	stateListener  BNodeStateListener
+	bTrace         bool
+	traces         []TraceEntry
+	maxDepth       int
+	depth          int
+	bDepthDirty    bool
+	tickBudget     int
+	mapTag2NodeIds map[string]map[uint32]bool
+	onComplete     BTreeCompleteFunc
+	bCompleted     bool
+	clock          int64
+	bThreadSafe    bool
+	lck            sync.Mutex
 }
 
 func NewBehaviorTree(treeId uint32) *BehaviorTree {
 	return &BehaviorTree{
-		treeId:   treeId,
-		rootNode: NewSequenceNode(BTREE_ROOT_NODE_ID),
+		treeId:         treeId,
+		rootNode:       NewSequenceNode(BTREE_ROOT_NODE_ID),
+		bStats:         false,
+		mapId2NodeStat: make(map[uint32]*NodeStats),
+		stateListener:  nil,
+		bTrace:         false,
+		traces:         nil,
+		maxDepth:       0,
+		depth:          0,
+		bDepthDirty:    true,
+		tickBudget:     0,
+		mapTag2NodeIds: make(map[string]map[uint32]bool),
+		onComplete:     nil,
+		bCompleted:     false,
+		clock:          0,
+		bThreadSafe:    false,
 	}
 }
 
+// SetThreadSafe turns the locking on or off, it must be called before the
+// tree is shared between goroutines.
+func (t *BehaviorTree) SetThreadSafe(bThreadSafe bool) {
+	t.bThreadSafe = bThreadSafe
+}
+
+func (t *BehaviorTree) IsThreadSafe() bool {
+	return t.bThreadSafe
+}
+
 func (t *BehaviorTree) GetID() uint32 {
 	return t.treeId
 }
@@ -327,14 +1090,486 @@ func (t *BehaviorTree) GetRootNode() BehaviorNode {
 	return t.rootNode
 }
 
-func (t *BehaviorTree) Execute() {
-	t.rootNode.Execute()
+// SetRoot replaces the root, the id of node must be BTREE_ROOT_NODE_ID. The
+// tags of the nodes not in the new tree are removed.
+func (t *BehaviorTree) SetRoot(node BehaviorNode) error {
+	if node == nil {
+		return ErrBNodeNil
+	}
+
+	if node.GetID() != BTREE_ROOT_NODE_ID {
+		return ErrBTreeRootID
+	}
+
+	t.lock()
+	defer t.unlock()
+
+	t.rootNode = node
+	t.bDepthDirty = true
+	t.removeStaleTags()
+	return nil
+}
+
+func (t *BehaviorTree) Execute(ctx *BTreeContext) {
+	onComplete, stat, bFire := t.execute(ctx)
+	if bFire {
+		onComplete(stat)
+	}
+}
+
+// OnComplete sets the callback called once the root succeeds or fails during
+// an Execute, it is called out of the tree lock. It's armed again once the
+// root isn't completed, e.g. after Reset.
+func (t *BehaviorTree) OnComplete(onComplete BTreeCompleteFunc) {
+	t.lock()
+	defer t.unlock()
+
+	t.onComplete = onComplete
+}
+
+func (t *BehaviorTree) getOnComplete() BTreeCompleteFunc {
+	t.lock()
+	defer t.unlock()
+
+	return t.onComplete
+}
+
+func (t *BehaviorTree) execute(ctx *BTreeContext) (BTreeCompleteFunc, BNodeState, bool) {
+	t.lock()
+	defer t.unlock()
+
+	if !t.rootNode.IsCompleted() {
+		t.bCompleted = false
+	}
+
+	if t.maxDepth > 0 {
+		if t.bDepthDirty {
+			t.depth = getBNodeDepth(t.rootNode, make(map[BehaviorNode]bool))
+			t.bDepthDirty = false
+		}
+
+		if t.depth > t.maxDepth {
+			log.Printf("behavior tree %d: depth %d exceeds max depth %d", t.treeId, t.depth, t.maxDepth)
+			setter, ok := t.rootNode.(interface{ SetState(stat BNodeState) })
+			if ok {
+				setter.SetState(BNODE_STAT_FAIL)
+			}
+
+			return t.checkComplete()
+		}
+	}
+
+	if ctx == nil {
+		ctx = NewBTreeContext(nil, 0)
+	}
+
+	parent := ctx.tree
+	if parent == nil {
+		t.clock += ctx.GetDt()
+		ctx.treeTime = t.clock
+		ctx.tickBudget = t.tickBudget
+		ctx.leafCount = 0
+	}
+
+	ctx.tree = t
+	t.traces = t.traces[:0]
+	executeBNode(ctx, t.rootNode)
+	ctx.tree = parent
+	return t.checkComplete()
+}
+
+func (t *BehaviorTree) checkComplete() (BTreeCompleteFunc, BNodeState, bool) {
+	if t.bCompleted || !t.rootNode.IsCompleted() {
+		return nil, BNODE_STAT_NOT_EXECUTE, false
+	}
+
+	t.bCompleted = true
+	return t.onComplete, t.rootNode.GetState(), t.onComplete != nil
+}
+
+// Clone returns a deep copy of the tree with a fresh runtime state, the
+// node ids, parameters and node listeners are kept. The stats, trace and
+// thread safe settings are copied, the state listener and the OnComplete
+// callback are not. Nodes not implementing BNodeCloner are shared with the
+// original tree.
+func (t *BehaviorTree) Clone(newTreeId uint32) *BehaviorTree {
+	t.lock()
+	defer t.unlock()
+
+	c := NewBehaviorTree(newTreeId)
+	c.rootNode = cloneBNode(t.rootNode)
+	c.bStats = t.bStats
+	c.bTrace = t.bTrace
+	c.bThreadSafe = t.bThreadSafe
+	for tag, mapId2Tagged := range t.mapTag2NodeIds {
+		c.mapTag2NodeIds[tag] = make(map[uint32]bool, len(mapId2Tagged))
+		for nodeId := range mapId2Tagged {
+			c.mapTag2NodeIds[tag][nodeId] = true
+		}
+	}
+
+	return c
+}
+
+func cloneBNode(node BehaviorNode) BehaviorNode {
+	cloner, ok := node.(BNodeCloner)
+	if !ok {
+		return node
+	}
+
+	return cloner.CloneNode()
+}
+
+// SetTickBudget limits the leaf executions of an Execute to budget, the
+// nodes after are left executing and continue at the next Execute. A
+// budget <= 0 means no limit. The leaves of the subtrees count against the
+// budget of the outermost tree, the budget of a subtree is ignored.
+func (t *BehaviorTree) SetTickBudget(budget int) {
+	t.lock()
+	defer t.unlock()
+
+	t.tickBudget = budget
+}
+
+// Depth returns the node count of the longest path from the root to a leaf.
+func (t *BehaviorTree) Depth() int {
+	t.lock()
+	defer t.unlock()
+
+	return getBNodeDepth(t.rootNode, make(map[BehaviorNode]bool))
+}
+
+// SetMaxDepth makes Execute fail the root without executing it when the
+// tree is deeper than maxDepth, a maxDepth <= 0 means no limit. The depth is
+// computed by the first Execute after SetMaxDepth, SetRoot or the tree level
+// AddChild / RemoveChild, call SetMaxDepth again after changing the nodes
+// directly.
+func (t *BehaviorTree) SetMaxDepth(maxDepth int) {
+	t.lock()
+	defer t.unlock()
+
+	t.maxDepth = maxDepth
+	t.bDepthDirty = true
+}
+
+func getBNodeDepth(node BehaviorNode, mapInPath map[BehaviorNode]bool) int {
+	if node == nil || mapInPath[node] {
+		return 0
+	}
+
+	mapInPath[node] = true
+	maxChildDepth := 0
+	for _, child := range node.Children() {
+		depth := getBNodeDepth(child, mapInPath)
+		if depth > maxChildDepth {
+			maxChildDepth = depth
+		}
+	}
+
+	delete(mapInPath, node)
+	return maxChildDepth + 1
+}
+
+// EnableStats turns the node statistics on or off, turning on clears the
+// recorded statistics.
+func (t *BehaviorTree) EnableStats(bEnable bool) {
+	t.lock()
+	defer t.unlock()
+
+	if bEnable && !t.bStats {
+		t.mapId2NodeStat = make(map[uint32]*NodeStats)
+	}
+
+	t.bStats = bEnable
+}
+
+func (t *BehaviorTree) GetNodeStats(nodeId uint32) (NodeStats, bool) {
+	t.lock()
+	defer t.unlock()
+
+	stats, ok := t.mapId2NodeStat[nodeId]
+	if !ok {
+		return NodeStats{}, false
+	}
+
+	return *stats, true
+}
+
+// SetNodeStateListener sets the listener called when the state of a node
+// changes during Execute, nil removes it.
+func (t *BehaviorTree) SetNodeStateListener(listener BNodeStateListener) {
+	t.lock()
+	defer t.unlock()
+
+	t.stateListener = listener
+}
+
+// EnableTrace turns the tracing of the executed nodes on or off.
+func (t *BehaviorTree) EnableTrace(bEnable bool) {
+	t.lock()
+	defer t.unlock()
+
+	t.bTrace = bEnable
+	t.traces = nil
+}
+
+// Trace returns the nodes executed by the last Execute in execution order,
+// with the state each node had after its execution.
+func (t *BehaviorTree) Trace() []TraceEntry {
+	t.lock()
+	defer t.unlock()
+
+	traces := make([]TraceEntry, len(t.traces))
+	copy(traces, t.traces)
+	return traces
+}
+
+func (t *BehaviorTree) onBNodeExecuting(node BehaviorNode) int {
+	if !t.bTrace {
+		return -1
+	}
+
+	t.traces = append(t.traces, TraceEntry{NodeID: node.GetID(), State: node.GetState()})
+	return len(t.traces) - 1
+}
+
+func (t *BehaviorTree) onBNodeExecuted(node BehaviorNode, from BNodeState, traceIdx int) {
+	to := node.GetState()
+	if traceIdx >= 0 {
+		t.traces[traceIdx].State = to
+	}
+
+	if t.stateListener != nil && from != to {
+		t.stateListener(node.GetID(), from, to)
+	}
+
+	if !t.bStats {
+		return
+	}
+
+	stats, ok := t.mapId2NodeStat[node.GetID()]
+	if !ok {
+		stats = &NodeStats{}
+		t.mapId2NodeStat[node.GetID()] = stats
+	}
+
+	stats.ExecuteCount++
+	switch to {
+	case BNODE_STAT_SUCC:
+		stats.SuccCount++
+	case BNODE_STAT_FAIL:
+		stats.FailCount++
+	case BNODE_STAT_EXECUTING:
+		stats.ExecutingTicks++
+	}
+}
+
+// executeBNode is used by the tree and the composites to execute a node,
+// so the tree can observe every node execution. Nothing is executed once
+// the context is cancelled or the tick budget is used up, the composites
+// see the skipped children as still executing and return.
+func executeBNode(ctx *BTreeContext, node BehaviorNode) {
+	if ctx.IsCancelled() {
+		ctx.onBNodeSkipped()
+		return
+	}
+
+	if ctx.isBudgetExhausted() {
+		ctx.onBNodeSkipped()
+		return
+	}
+
+	if isBNodeLeaf(node) {
+		ctx.onBNodeLeaf()
+	}
+
+	t := ctx.GetTree()
+
+	from := node.GetState()
+	traceIdx := -1
+	if t != nil {
+		traceIdx = t.onBNodeExecuting(node)
+	}
+
+	node.Execute(ctx)
+	if t != nil {
+		t.onBNodeExecuted(node, from, traceIdx)
+	}
+}
+
+// isBNodeLeaf returns true for the action nodes counted by the tick budget,
+// a subtree node only wraps the nodes of its subtree.
+func isBNodeLeaf(node BehaviorNode) bool {
+	if node.GetType() != BNODE_TYPE_ACTION {
+		return false
+	}
+
+	_, ok := node.(*SubtreeNode)
+	return !ok
+}
+
+// executeBNodeFully executes node, false if a node of its subtree is left
+// unexecuted by a cancel or the tick budget, then the state of node is only
+// partial.
+func executeBNodeFully(ctx *BTreeContext, node BehaviorNode) bool {
+	skips := ctx.getSkips()
+	executeBNode(ctx, node)
+	return ctx.getSkips() == skips
+}
+
+// abortBNode calls OnAbort of the executing node and its executing
+// descendants, the deepest first, then resets the node.
+func abortBNode(node BehaviorNode) {
+	notifyBNodeAbort(node)
+	node.Reset()
+}
+
+func notifyBNodeAbort(node BehaviorNode) {
+	if node.GetState() != BNODE_STAT_EXECUTING {
+		return
+	}
+
+	for _, child := range node.Children() {
+		notifyBNodeAbort(child)
+	}
+
+	node.OnAbort()
 }
 
 func (t *BehaviorTree) GetState() BNodeState {
+	t.lock()
+	defer t.unlock()
+
 	return t.rootNode.GetState()
 }
 
 func (t *BehaviorTree) IsCompleted() bool {
+	t.lock()
+	defer t.unlock()
+
 	return t.rootNode.IsCompleted()
 }
+
+func (t *BehaviorTree) Reset() {
+	t.lock()
+	defer t.unlock()
+
+	t.rootNode.Reset()
+}
+
+// Abort calls OnAbort of the executing nodes, the deepest first, then resets
+// the tree.
+func (t *BehaviorTree) Abort() {
+	t.lock()
+	defer t.unlock()
+
+	abortBNode(t.rootNode)
+}
+
+// AddChild adds child to the node parentId of the tree.
+func (t *BehaviorTree) AddChild(parentId uint32, child BehaviorNode) error {
+	t.lock()
+	defer t.unlock()
+
+	if child == nil {
+		return ErrBNodeNil
+	}
+
+	parent, ok := findBNodeByID(t.rootNode, parentId)
+	if !ok {
+		return ErrBNodeNotExist
+	}
+
+	parent.AddChild(child)
+	t.bDepthDirty = true
+	return nil
+}
+
+// RemoveChild removes the child childId from the node parentId of the tree.
+func (t *BehaviorTree) RemoveChild(parentId uint32, childId uint32) error {
+	t.lock()
+	defer t.unlock()
+
+	parent, ok := findBNodeByID(t.rootNode, parentId)
+	if !ok {
+		return ErrBNodeNotExist
+	}
+
+	_, ok = parent.GetChildByID(childId)
+	if !ok {
+		return ErrBNodeNotExist
+	}
+
+	parent.RemoveChildByID(childId)
+	t.bDepthDirty = true
+	return nil
+}
+
+// FindNodeByID searches the whole tree depth-first, the root included.
+func (t *BehaviorTree) FindNodeByID(nodeId uint32) (BehaviorNode, bool) {
+	t.lock()
+	defer t.unlock()
+
+	return findBNodeByID(t.rootNode, nodeId)
+}
+
+// ActivePath returns the executing nodes from the root to the executing
+// leaf, following the first executing child of each node and the roots of
+// subtrees. It is empty if the root isn't executing.
+func (t *BehaviorTree) ActivePath() []BehaviorNode {
+	t.lock()
+	defer t.unlock()
+
+	path := make([]BehaviorNode, 0)
+	node := t.rootNode
+	for node != nil && node.GetState() == BNODE_STAT_EXECUTING {
+		path = append(path, node)
+
+		children := node.Children()
+		subtreeNode, ok := node.(*SubtreeNode)
+		if ok && subtreeNode.subtree != nil {
+			children = []BehaviorNode{subtreeNode.subtree.GetRootNode()}
+		}
+
+		node = nil
+		for _, child := range children {
+			if child != nil && child.GetState() == BNODE_STAT_EXECUTING {
+				node = child
+				break
+			}
+		}
+	}
+
+	return path
+}
+
+func findBNodeByID(node BehaviorNode, nodeId uint32) (BehaviorNode, bool) {
+	if node == nil {
+		return nil, false
+	}
+
+	if node.GetID() == nodeId {
+		return node, true
+	}
+
+	for _, child := range node.Children() {
+		found, ok := findBNodeByID(child, nodeId)
+		if ok {
+			return found, true
+		}
+	}
+
+	return nil, false
+}
+
+func (t *BehaviorTree) lock() {
+	if t.bThreadSafe {
+		t.lck.Lock()
+	}
+}
+
+func (t *BehaviorTree) unlock() {
+	if t.bThreadSafe {
+		t.lck.Unlock()
+	}
+}
@@ -0,0 +1,1443 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// testFuncNode takes the state returned by f on each Execute.
+type testFuncNode struct {
+	*BaseBehaviorNode
+	f func() BNodeState
+}
+
+func newTestFuncNode(nodeId uint32, f func() BNodeState) *testFuncNode {
+	return &testFuncNode{
+		BaseBehaviorNode: NewBaseBehaviorNode(nodeId, 0, 0),
+		f:                f,
+	}
+}
+
+func (n *testFuncNode) Execute(ctx *BTreeContext) {
+	if n.IsCompleted() {
+		return
+	}
+
+	n.state = n.f()
+}
+
+// testAbortNode is a ScriptedNode counting its aborts.
+type testAbortNode struct {
+	*ScriptedNode
+	aborts int
+}
+
+func newTestAbortNode(nodeId uint32, results ...BNodeState) *testAbortNode {
+	return &testAbortNode{
+		ScriptedNode: NewScriptedNode(nodeId, results...),
+		aborts:       0,
+	}
+}
+
+func (n *testAbortNode) OnAbort() {
+	n.aborts++
+}
+
+// newTestTree returns a tree whose root sequence has the children.
+func newTestTree(children ...BehaviorNode) *BehaviorTree {
+	t := NewBehaviorTree(1)
+	for _, child := range children {
+		t.GetRootNode().AddChild(child)
+	}
+
+	return t
+}
+
+// executeTestTreeUntil executes the tree until bDone, ten times at most.
+func executeTestTreeUntil(tree *BehaviorTree, ctx *BTreeContext, bDone func() bool) {
+	for i := 0; i < 10 && !bDone(); i++ {
+		tree.Execute(ctx)
+	}
+}
+
+func TestSelectorPartialHigherPriority(t *testing.T) {
+	type interrupt int
+	const (
+		byBudget interrupt = iota
+		byCancel
+	)
+
+	cases := []struct {
+		name      string
+		interrupt interrupt
+		newSelect func(higher BehaviorNode, lower BehaviorNode) BehaviorNode
+	}{
+		{"select budget", byBudget, newTestAbortLowerSelect},
+		{"select cancel", byCancel, newTestAbortLowerSelect},
+		{"priority select budget", byBudget, newTestPrioritySelect},
+		{"priority select cancel", byCancel, newTestPrioritySelect},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			bArmed := false
+			cctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			first := newTestFuncNode(3, func() BNodeState {
+				if bArmed && c.interrupt == byCancel {
+					cancel()
+				}
+
+				return BNODE_STAT_SUCC
+			})
+
+			second := newTestAbortNode(4, BNODE_STAT_FAIL)
+			higher := NewSequenceNode(2)
+			higher.AddChild(first)
+			higher.AddChild(second)
+
+			lower := newTestAbortNode(5, BNODE_STAT_EXECUTING)
+			tree := newTestTree(c.newSelect(higher, lower))
+			ctx := NewBTreeContext(nil, 0)
+
+			executeTestTreeUntil(tree, ctx, func() bool { return lower.GetExecCount() > 0 })
+			if lower.GetState() != BNODE_STAT_EXECUTING || lower.GetExecCount() != 1 {
+				t.Fatalf("lower = %v after %d executions", lower.GetState(), lower.GetExecCount())
+			}
+
+			bArmed = true
+			if c.interrupt == byBudget {
+				tree.SetTickBudget(1)
+			} else {
+				ctx.SetContext(cctx)
+			}
+
+			tree.Execute(ctx)
+			if lower.aborts != 0 || lower.GetState() != BNODE_STAT_EXECUTING {
+				t.Fatalf("lower aborted on a partial evaluation, aborts %d, state %v", lower.aborts, lower.GetState())
+			}
+
+			if higher.GetState() == BNODE_STAT_EXECUTING {
+				t.Fatal("partial higher branch left executing")
+			}
+
+			bArmed = false
+			tree.SetTickBudget(0)
+			ctx.SetContext(nil)
+			tree.Execute(ctx)
+			if lower.aborts != 0 || lower.GetExecCount() != 2 {
+				t.Fatalf("lower aborts %d, executions %d", lower.aborts, lower.GetExecCount())
+			}
+		})
+	}
+}
+
+func newTestAbortLowerSelect(higher BehaviorNode, lower BehaviorNode) BehaviorNode {
+	n := NewSelectNode(10)
+	n.SetAbortMode(BNODE_ABORT_LOWER_PRIORITY)
+	n.AddChild(higher)
+	n.AddChild(lower)
+	return n
+}
+
+func newTestPrioritySelect(higher BehaviorNode, lower BehaviorNode) BehaviorNode {
+	n := NewPrioritySelectNode(10)
+	n.AddChildWithPriority(higher, func(param ...interface{}) int { return 1 })
+	n.AddChild(lower)
+	return n
+}
+
+func TestSelectorFullHigherPriorityAborts(t *testing.T) {
+	cases := []struct {
+		name      string
+		newSelect func(higher BehaviorNode, lower BehaviorNode) BehaviorNode
+	}{
+		{"select", newTestAbortLowerSelect},
+		{"priority select", newTestPrioritySelect},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			higher := NewScriptedNode(2, BNODE_STAT_FAIL, BNODE_STAT_SUCC)
+			lower := newTestAbortNode(3, BNODE_STAT_EXECUTING)
+			tree := newTestTree(c.newSelect(higher, lower))
+			ctx := NewBTreeContext(nil, 0)
+
+			executeTestTreeUntil(tree, ctx, func() bool { return lower.GetExecCount() > 0 })
+			tree.Execute(ctx)
+			if lower.aborts != 1 || tree.GetState() != BNODE_STAT_SUCC {
+				t.Fatalf("lower aborts %d, tree %v", lower.aborts, tree.GetState())
+			}
+		})
+	}
+}
+
+func TestStateScopedConditionNodeCache(t *testing.T) {
+	cases := []struct {
+		name  string
+		apply func(f *FSM)
+		calls int
+	}{
+		{"same entry", func(f *FSM) {}, 1},
+		{"entered again", func(f *FSM) { f.Trigger("go"); f.Trigger("back") }, 2},
+		{"fsm reset", func(f *FSM) { f.Reset(); f.Start("a") }, 2},
+		{"runtime restored", func(f *FSM) { f.RestoreRuntime(f.SaveRuntime()) }, 2},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := newTestFSM(t, nil, "a", "b")
+			f.AddTransition("a", "go", "b", "")
+			f.AddTransition("b", "back", "a", "")
+			f.Start("a")
+
+			calls := 0
+			n := NewStateScopedConditionNode(1, func(param ...interface{}) bool {
+				calls++
+				return true
+			})
+
+			ctx := NewBTreeContext(f, 0)
+			n.Execute(ctx)
+			c.apply(f)
+			n.Reset()
+			n.Execute(ctx)
+			if calls != c.calls {
+				t.Fatalf("condition called %d times, want %d", calls, c.calls)
+			}
+		})
+	}
+}
+
+func TestStateScopedConditionNodeResult(t *testing.T) {
+	cases := []struct {
+		name    string
+		bFSM    bool
+		bResult bool
+		want    BNodeState
+		calls   int
+	}{
+		{"cached success", true, true, BNODE_STAT_SUCC, 1},
+		{"cached failure", true, false, BNODE_STAT_FAIL, 1},
+		{"no fsm", false, true, BNODE_STAT_SUCC, 3},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var f *FSM
+			if c.bFSM {
+				f = newTestFSM(t, nil, "a")
+				f.Start("a")
+			}
+
+			calls := 0
+			n := NewStateScopedConditionNode(1, func(param ...interface{}) bool {
+				calls++
+				return c.bResult
+			})
+
+			ctx := NewBTreeContext(f, 0)
+			for i := 0; i < 3; i++ {
+				n.Reset()
+				n.Execute(ctx)
+				if n.GetState() != c.want {
+					t.Fatalf("tick %d: state %d, want %d", i, n.GetState(), c.want)
+				}
+			}
+
+			if calls != c.calls {
+				t.Fatalf("condition called %d times, want %d", calls, c.calls)
+			}
+		})
+	}
+}
+
+func TestMemCompositeResume(t *testing.T) {
+	cases := []struct {
+		name   string
+		node   BehaviorNode
+		first  BNodeState
+		last   BNodeState
+		want   BNodeState
+		counts []int
+	}{
+		{"sequence", NewMemSequenceNode(1), BNODE_STAT_SUCC, BNODE_STAT_SUCC, BNODE_STAT_SUCC, []int{1, 3, 1}},
+		{"select", NewMemSelectNode(1), BNODE_STAT_FAIL, BNODE_STAT_SUCC, BNODE_STAT_SUCC, []int{1, 3, 0}},
+		{"sequence fails", NewMemSequenceNode(1), BNODE_STAT_SUCC, BNODE_STAT_FAIL, BNODE_STAT_FAIL, []int{1, 3, 1}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			children := []*ScriptedNode{
+				NewScriptedNode(2, c.first),
+				NewScriptedNode(3, BNODE_STAT_EXECUTING, BNODE_STAT_EXECUTING, BNODE_STAT_SUCC),
+				NewScriptedNode(4, c.last),
+			}
+
+			for _, child := range children {
+				c.node.AddChild(child)
+			}
+
+			ctx := NewBTreeContext(nil, 0)
+			wants := []BNodeState{BNODE_STAT_EXECUTING, BNODE_STAT_EXECUTING, c.want}
+			for i, want := range wants {
+				c.node.Execute(ctx)
+				if c.node.GetState() != want {
+					t.Fatalf("tick %d: state %d, want %d", i, c.node.GetState(), want)
+				}
+			}
+
+			for i, child := range children {
+				if child.GetExecCount() != c.counts[i] {
+					t.Fatalf("child %d executed %d times, want %d", i, child.GetExecCount(), c.counts[i])
+				}
+			}
+
+			c.node.Reset()
+			c.node.Execute(ctx)
+			if children[0].GetExecCount() != c.counts[0]+1 {
+				t.Fatalf("first child not executed again after Reset")
+			}
+		})
+	}
+}
+
+func TestScopedSequenceNodeCleanup(t *testing.T) {
+	cases := []struct {
+		name  string
+		last  BNodeState
+		bStop bool
+		want  []uint32
+	}{
+		{"later child fails", BNODE_STAT_FAIL, false, []uint32{3, 2}},
+		{"aborted", BNODE_STAT_EXECUTING, true, []uint32{3, 2}},
+		{"succeeds", BNODE_STAT_SUCC, false, []uint32{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cleaned := make([]uint32, 0)
+			cleanup := func(node BehaviorNode) {
+				cleaned = append(cleaned, node.GetID())
+			}
+
+			n := NewScopedSequenceNode(1)
+			n.AddChildWithCleanup(NewScriptedNode(2, BNODE_STAT_SUCC), cleanup)
+			n.AddChildWithCleanup(NewScriptedNode(3, BNODE_STAT_SUCC), cleanup)
+			n.AddChildWithCleanup(NewScriptedNode(4, c.last), cleanup)
+
+			n.Execute(NewBTreeContext(nil, 0))
+			if c.bStop {
+				n.Reset()
+			}
+
+			if len(cleaned) != len(c.want) {
+				t.Fatalf("cleaned %v, want %v", cleaned, c.want)
+			}
+
+			for i := range cleaned {
+				if cleaned[i] != c.want[i] {
+					t.Fatalf("cleaned %v, want %v", cleaned, c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSequenceNodeMultiTick(t *testing.T) {
+	E, S, F := BNODE_STAT_EXECUTING, BNODE_STAT_SUCC, BNODE_STAT_FAIL
+	cases := []struct {
+		name     string
+		children [][]BNodeState
+		want     []BNodeState
+	}{
+		{"all succeed", [][]BNodeState{{S}, {S}}, []BNodeState{S}},
+		{"first fails", [][]BNodeState{{F}, {S}}, []BNodeState{F}},
+		{"last succeeds later", [][]BNodeState{{S}, {E, S}}, []BNodeState{E, S}},
+		{"last fails later", [][]BNodeState{{S}, {E, E, F}}, []BNodeState{E, E, F}},
+		{"middle running", [][]BNodeState{{S}, {E, S}, {E, S}}, []BNodeState{E, E, S}},
+		{"empty", [][]BNodeState{}, []BNodeState{S}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			n := NewSequenceNode(1)
+			for i, results := range c.children {
+				n.AddChild(NewScriptedNode(uint32(i+2), results...))
+			}
+
+			ctx := NewBTreeContext(nil, 0)
+			for i, want := range c.want {
+				n.Execute(ctx)
+				if n.GetState() != want {
+					t.Fatalf("tick %d: state %d, want %d", i, n.GetState(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestBehaviorNodeChildren(t *testing.T) {
+	leaf := NewBaseBehaviorNode(10, 0, 0)
+	other := NewBaseBehaviorNode(11, 0, 0)
+
+	newControl := func(n BehaviorNode) BehaviorNode {
+		n.AddChild(leaf)
+		n.AddChild(other)
+		return n
+	}
+
+	newDecorator := func(n BehaviorNode) BehaviorNode {
+		n.AddChild(other)
+		n.AddChild(leaf)
+		return n
+	}
+
+	cases := []struct {
+		name string
+		node BehaviorNode
+		want []BehaviorNode
+	}{
+		{"leaf", NewBaseBehaviorNode(1, 0, 0), nil},
+		{"sequence", newControl(NewSequenceNode(1)), []BehaviorNode{leaf, other}},
+		{"select", newControl(NewSelectNode(1)), []BehaviorNode{leaf, other}},
+		{"parallel", newControl(NewParallelNode(1)), []BehaviorNode{leaf, other}},
+		{"empty sequence", NewSequenceNode(1), nil},
+		{"decorator", newDecorator(NewDecoratorNode(1)), []BehaviorNode{leaf}},
+		{"timeout decorator", newDecorator(NewTimeoutNode(1, 3)), []BehaviorNode{leaf}},
+		{"empty decorator", NewDecoratorNode(1), nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			children := c.node.Children()
+			if len(children) != len(c.want) {
+				t.Fatalf("%d children, want %d", len(children), len(c.want))
+			}
+
+			for i := range children {
+				if children[i] != c.want[i] {
+					t.Fatalf("child %d is node %d, want %d", i, children[i].GetID(), c.want[i].GetID())
+				}
+			}
+		})
+	}
+}
+
+func TestBehaviorTreeFindNodeByID(t *testing.T) {
+	tree := newTestWalkTree()
+	cases := []struct {
+		name   string
+		nodeId uint32
+		bFound bool
+	}{
+		{"root", 1, true},
+		{"nested", 3, true},
+		{"under decorator", 5, true},
+		{"missing", 99, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			node, ok := tree.FindNodeByID(c.nodeId)
+			if ok != c.bFound {
+				t.Fatalf("found %v, want %v", ok, c.bFound)
+			}
+
+			if ok && node.GetID() != c.nodeId {
+				t.Fatalf("found node %d, want %d", node.GetID(), c.nodeId)
+			}
+		})
+	}
+}
+
+func TestBehaviorTreeNodeStats(t *testing.T) {
+	tree := newTestTree(
+		NewScriptedNode(2, BNODE_STAT_EXECUTING, BNODE_STAT_EXECUTING, BNODE_STAT_SUCC),
+		NewScriptedNode(3, BNODE_STAT_FAIL),
+	)
+
+	tree.EnableStats(true)
+	ctx := NewBTreeContext(nil, 0)
+	for i := 0; i < 3; i++ {
+		tree.Execute(ctx)
+	}
+
+	cases := []struct {
+		nodeId uint32
+		want   NodeStats
+	}{
+		{1, NodeStats{ExecuteCount: 3, SuccCount: 0, FailCount: 1, ExecutingTicks: 2}},
+		{2, NodeStats{ExecuteCount: 3, SuccCount: 1, FailCount: 0, ExecutingTicks: 2}},
+		{3, NodeStats{ExecuteCount: 1, SuccCount: 0, FailCount: 1, ExecutingTicks: 0}},
+	}
+
+	for _, c := range cases {
+		stats, ok := tree.GetNodeStats(c.nodeId)
+		if !ok || stats != c.want {
+			t.Fatalf("node %d stats %+v, want %+v", c.nodeId, stats, c.want)
+		}
+	}
+
+	tree.EnableStats(false)
+	tree.EnableStats(true)
+	_, ok := tree.GetNodeStats(2)
+	if ok {
+		t.Fatalf("stats not cleared when enabled again")
+	}
+}
+
+func TestBehaviorTreeNodeStatsDisabled(t *testing.T) {
+	tree := newTestTree(NewScriptedNode(2, BNODE_STAT_SUCC))
+	tree.Execute(NewBTreeContext(nil, 0))
+
+	_, ok := tree.GetNodeStats(2)
+	if ok {
+		t.Fatalf("stats recorded while disabled")
+	}
+}
+
+func TestBehaviorTreeThreadSafeHammer(t *testing.T) {
+	tree := newTestTree(NewSelectNode(2))
+	tree.SetThreadSafe(true)
+	tree.AddChild(2, NewScriptedNode(3, BNODE_STAT_EXECUTING))
+
+	ops := []func(i int){
+		func(i int) { tree.Execute(NewBTreeContext(nil, 1)) },
+		func(i int) { tree.AddChild(2, NewScriptedNode(uint32(10+i), BNODE_STAT_FAIL)) },
+		func(i int) { tree.RemoveChild(2, uint32(10+i)) },
+		func(i int) { tree.Reset(); tree.GetState(); tree.ActivePath() },
+		func(i int) { tree.FindNodeByID(3); tree.Depth() },
+	}
+
+	wg := sync.WaitGroup{}
+	for _, op := range ops {
+		wg.Add(1)
+		go func(op func(i int)) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				op(i)
+			}
+		}(op)
+	}
+
+	wg.Wait()
+
+	_, ok := tree.FindNodeByID(3)
+	if !ok {
+		t.Fatalf("node 3 lost")
+	}
+}
+
+func TestBehaviorTreeNodeStateListener(t *testing.T) {
+	tree := newTestTree(NewScriptedNode(2, BNODE_STAT_EXECUTING, BNODE_STAT_SUCC))
+	changes := make([]string, 0)
+	tree.SetNodeStateListener(func(nodeId uint32, from BNodeState, to BNodeState) {
+		changes = append(changes, fmt.Sprintf("%d:%d->%d", nodeId, from, to))
+	})
+
+	ctx := NewBTreeContext(nil, 0)
+	for i := 0; i < 3; i++ {
+		tree.Execute(ctx)
+	}
+
+	want := []string{
+		fmt.Sprintf("2:%d->%d", BNODE_STAT_NOT_EXECUTE, BNODE_STAT_EXECUTING),
+		fmt.Sprintf("1:%d->%d", BNODE_STAT_NOT_EXECUTE, BNODE_STAT_EXECUTING),
+		fmt.Sprintf("2:%d->%d", BNODE_STAT_EXECUTING, BNODE_STAT_SUCC),
+		fmt.Sprintf("1:%d->%d", BNODE_STAT_EXECUTING, BNODE_STAT_SUCC),
+	}
+
+	if !equalTestStrings(changes, want) {
+		t.Fatalf("changes %v, want %v", changes, want)
+	}
+
+	tree.SetNodeStateListener(nil)
+	tree.Reset()
+	tree.Execute(ctx)
+	if len(changes) != len(want) {
+		t.Fatalf("removed listener still called")
+	}
+}
+
+func TestBehaviorTreeTrace(t *testing.T) {
+	E, S, F := BNODE_STAT_EXECUTING, BNODE_STAT_SUCC, BNODE_STAT_FAIL
+	cases := []struct {
+		name   string
+		bTrace bool
+		ticks  [][]TraceEntry
+	}{
+		{"enabled", true, [][]TraceEntry{
+			{{NodeID: 1, State: E}, {NodeID: 2, State: E}, {NodeID: 3, State: F}},
+			{{NodeID: 1, State: S}, {NodeID: 2, State: S}, {NodeID: 4, State: S}},
+		}},
+		{"disabled", false, [][]TraceEntry{{}, {}}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sel := NewSelectNode(2)
+			sel.AddChild(NewScriptedNode(3, F))
+			sel.AddChild(NewScriptedNode(4, S))
+			tree := newTestTree(sel)
+			tree.EnableTrace(c.bTrace)
+
+			ctx := NewBTreeContext(nil, 0)
+			for i, want := range c.ticks {
+				tree.Execute(ctx)
+				trace := tree.Trace()
+				if fmt.Sprint(trace) != fmt.Sprint(want) {
+					t.Fatalf("tick %d: trace %v, want %v", i, trace, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSelectNodeAbortMode(t *testing.T) {
+	cases := []struct {
+		name   string
+		mode   BNodeAbortMode
+		aborts int
+		state  BNodeState
+		tree   BNodeState
+	}{
+		{"none", BNODE_ABORT_NONE, 0, BNODE_STAT_EXECUTING, BNODE_STAT_EXECUTING},
+		{"lower priority", BNODE_ABORT_LOWER_PRIORITY, 1, BNODE_STAT_NOT_EXECUTE, BNODE_STAT_SUCC},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			bReady := false
+			higher := newTestFuncNode(2, func() BNodeState {
+				if bReady {
+					return BNODE_STAT_SUCC
+				}
+
+				return BNODE_STAT_FAIL
+			})
+
+			lower := newTestAbortNode(3, BNODE_STAT_EXECUTING)
+			sel := NewSelectNode(4)
+			sel.SetAbortMode(c.mode)
+			sel.AddChild(higher)
+			sel.AddChild(lower)
+			if sel.GetAbortMode() != c.mode {
+				t.Fatalf("abort mode %d, want %d", sel.GetAbortMode(), c.mode)
+			}
+
+			tree := newTestTree(sel)
+			ctx := NewBTreeContext(nil, 0)
+			executeTestTreeUntil(tree, ctx, func() bool { return lower.GetExecCount() > 0 })
+
+			bReady = true
+			tree.Execute(ctx)
+			if lower.aborts != c.aborts || lower.GetState() != c.state {
+				t.Fatalf("lower aborts %d state %d, want %d %d", lower.aborts, lower.GetState(), c.aborts, c.state)
+			}
+
+			if tree.GetState() != c.tree {
+				t.Fatalf("tree state %d, want %d", tree.GetState(), c.tree)
+			}
+		})
+	}
+}
+
+func TestBehaviorTreeClone(t *testing.T) {
+	a := NewBaseAgent(1)
+	calls := 0
+	a.AddBNodeActionHandleFunc(30, func(node BehaviorNode, param ...interface{}) BNodeState {
+		calls++
+		return BNODE_STAT_SUCC
+	})
+
+	sel := NewSelectNode(2)
+	sel.AddChild(NewAgentBNode(3, 30, 2, a))
+	decorator := NewTimeoutNode(4, 5)
+	decorator.AddChild(NewScriptedNode(5, BNODE_STAT_SUCC))
+	sel.AddChild(decorator)
+	tree := newTestTree(sel)
+
+	clone := tree.Clone(8)
+	if clone.GetID() != 8 || !equalTestBNode(clone.GetRootNode(), tree.GetRootNode()) {
+		t.Fatalf("clone structure differs from the original")
+	}
+
+	clone.Execute(NewBTreeContext(nil, 0))
+	if clone.GetState() != BNODE_STAT_SUCC || calls != 1 {
+		t.Fatalf("clone state %d listener calls %d, want succ 1", clone.GetState(), calls)
+	}
+
+	Walk(tree.GetRootNode(), func(node BehaviorNode, depth int) bool {
+		if node.GetState() != BNODE_STAT_NOT_EXECUTE {
+			t.Fatalf("original node %d state %d after executing the clone", node.GetID(), node.GetState())
+		}
+
+		cloned, _ := clone.FindNodeByID(node.GetID())
+		if cloned == node {
+			t.Fatalf("node %d shared with the clone", node.GetID())
+		}
+
+		return true
+	})
+
+	cloned, _ := clone.FindNodeByID(5)
+	parent, ok := cloned.GetParent()
+	if !ok || parent == BehaviorNode(decorator) || parent.GetID() != 4 {
+		t.Fatalf("parent of the cloned node 5 isn't the cloned decorator")
+	}
+}
+
+func TestBehaviorTreeDepth(t *testing.T) {
+	cases := []struct {
+		name string
+		tree *BehaviorTree
+		want int
+	}{
+		{"root only", NewBehaviorTree(1), 1},
+		{"one level", newTestTree(NewBaseBehaviorNode(2, 0, 0)), 2},
+		{"walk tree", newTestWalkTree(), 4},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if c.tree.Depth() != c.want {
+				t.Fatalf("depth %d, want %d", c.tree.Depth(), c.want)
+			}
+		})
+	}
+}
+
+func TestBehaviorTreeMaxDepth(t *testing.T) {
+	cases := []struct {
+		name     string
+		maxDepth int
+		want     BNodeState
+		execs    int
+	}{
+		{"no limit", 0, BNODE_STAT_SUCC, 1},
+		{"within", 3, BNODE_STAT_SUCC, 1},
+		{"exceeded", 2, BNODE_STAT_FAIL, 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			leaf := NewScriptedNode(3, BNODE_STAT_SUCC)
+			seq := NewSequenceNode(2)
+			seq.AddChild(leaf)
+			tree := newTestTree(seq)
+			tree.SetMaxDepth(c.maxDepth)
+
+			tree.Execute(NewBTreeContext(nil, 0))
+			if tree.GetState() != c.want || leaf.GetExecCount() != c.execs {
+				t.Fatalf("tree state %d leaf runs %d, want %d %d", tree.GetState(), leaf.GetExecCount(), c.want, c.execs)
+			}
+		})
+	}
+}
+
+func TestBehaviorTreeMaxDepthStructureChange(t *testing.T) {
+	tree := newTestTree(NewSequenceNode(2))
+	tree.SetMaxDepth(2)
+	tree.Execute(NewBTreeContext(nil, 0))
+	if tree.GetState() != BNODE_STAT_SUCC {
+		t.Fatalf("tree state %d, want succ", tree.GetState())
+	}
+
+	leaf := NewScriptedNode(3, BNODE_STAT_SUCC)
+	err := tree.AddChild(2, leaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree.Reset()
+	tree.Execute(NewBTreeContext(nil, 0))
+	if tree.GetState() != BNODE_STAT_FAIL || leaf.GetExecCount() != 0 {
+		t.Fatalf("tree state %d leaf runs %d, want fail 0", tree.GetState(), leaf.GetExecCount())
+	}
+}
+
+func TestBehaviorTreeTickBudget(t *testing.T) {
+	cases := []struct {
+		name   string
+		budget int
+		ticks  [][]int
+	}{
+		{"budget 1", 1, [][]int{{1, 0, 0}, {1, 1, 0}, {1, 1, 1}}},
+		{"budget 2", 2, [][]int{{1, 1, 0}, {1, 1, 1}}},
+		{"no budget", 0, [][]int{{1, 1, 1}}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			leaves := []*ScriptedNode{
+				NewScriptedNode(2, BNODE_STAT_SUCC),
+				NewScriptedNode(3, BNODE_STAT_SUCC),
+				NewScriptedNode(4, BNODE_STAT_SUCC),
+			}
+
+			tree := newTestTree(leaves[0], leaves[1], leaves[2])
+			tree.SetTickBudget(c.budget)
+			ctx := NewBTreeContext(nil, 0)
+			for i, want := range c.ticks {
+				tree.Execute(ctx)
+				for j, leaf := range leaves {
+					if leaf.GetExecCount() != want[j] {
+						t.Fatalf("tick %d: leaf %d executed %d times, want %d", i, j, leaf.GetExecCount(), want[j])
+					}
+				}
+
+				bLast := i == len(c.ticks)-1
+				if tree.IsCompleted() != bLast {
+					t.Fatalf("tick %d: tree completed %v, want %v", i, tree.IsCompleted(), bLast)
+				}
+			}
+		})
+	}
+}
+
+func TestBehaviorTreeTickBudgetSubtree(t *testing.T) {
+	leaves := []*ScriptedNode{
+		NewScriptedNode(2, BNODE_STAT_SUCC),
+		NewScriptedNode(3, BNODE_STAT_SUCC),
+		NewScriptedNode(4, BNODE_STAT_SUCC),
+	}
+
+	subtree := newTestTree(leaves[0], leaves[1], leaves[2])
+	tree := newTestTree(NewSubtreeNode(2, subtree))
+	tree.SetTickBudget(1)
+	ctx := NewBTreeContext(nil, 0)
+	for i := 1; i <= 3; i++ {
+		tree.Execute(ctx)
+		runs := 0
+		for _, leaf := range leaves {
+			runs += leaf.GetExecCount()
+		}
+
+		if runs != i {
+			t.Fatalf("tick %d: leaves ran %d times, want %d", i, runs, i)
+		}
+	}
+
+	if !tree.IsCompleted() {
+		t.Fatalf("tree not completed")
+	}
+}
+
+func TestBehaviorTreeTickBudgetSkipsComposites(t *testing.T) {
+	leaf := NewScriptedNode(3, BNODE_STAT_SUCC)
+	tree := newTestTree(NewSequenceNode(2), leaf)
+	tree.SetTickBudget(1)
+	tree.Execute(NewBTreeContext(nil, 0))
+	if leaf.GetExecCount() != 1 || !tree.IsCompleted() {
+		t.Fatalf("leaf executed %d times, tree completed %v", leaf.GetExecCount(), tree.IsCompleted())
+	}
+}
+
+func TestEmptyComposite(t *testing.T) {
+	cases := []struct {
+		name string
+		node BehaviorNode
+		want BNodeState
+	}{
+		{"sequence", NewSequenceNode(1), BNODE_STAT_SUCC},
+		{"mem sequence", NewMemSequenceNode(1), BNODE_STAT_SUCC},
+		{"random sequence", NewRandomSequenceNode(1), BNODE_STAT_SUCC},
+		{"parallel", NewParallelNode(1), BNODE_STAT_SUCC},
+		{"select", NewSelectNode(1), BNODE_STAT_FAIL},
+		{"mem select", NewMemSelectNode(1), BNODE_STAT_FAIL},
+		{"priority select", NewPrioritySelectNode(1), BNODE_STAT_FAIL},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			c.node.Execute(NewBTreeContext(nil, 0))
+			if c.node.GetState() != c.want {
+				t.Fatalf("state %d, want %d", c.node.GetState(), c.want)
+			}
+		})
+	}
+
+	tree := NewBehaviorTree(1)
+	tree.Execute(NewBTreeContext(nil, 0))
+	if !tree.IsCompleted() || tree.GetState() != BNODE_STAT_SUCC {
+		t.Fatalf("empty tree state %d, want succ", tree.GetState())
+	}
+}
+
+func TestBNodeString(t *testing.T) {
+	cases := []struct {
+		value fmt.Stringer
+		want  string
+	}{
+		{BNODE_STAT_NOT_EXECUTE, "NOT_EXECUTE"},
+		{BNODE_STAT_EXECUTING, "EXECUTING"},
+		{BNODE_STAT_SUCC, "SUCC"},
+		{BNODE_STAT_FAIL, "FAIL"},
+		{BNodeState(99), "UNKNOWN"},
+		{BNODE_TYPE_ACTION, "ACTION"},
+		{BNODE_TYPE_SEQUENCE, "SEQUENCE"},
+		{BNODE_TYPE_SELECT, "SELECT"},
+		{BNODE_TYPE_PARALLEL, "PARALLEL"},
+		{BNODE_TYPE_DECORATOR, "DECORATOR"},
+		{BNodeType(99), "UNKNOWN"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.want, func(t *testing.T) {
+			if got := c.value.String(); got != c.want {
+				t.Fatalf("String() = %s, want %s", got, c.want)
+			}
+
+			if got := fmt.Sprint(c.value); got != c.want {
+				t.Fatalf("Sprint = %s, want %s", got, c.want)
+			}
+		})
+	}
+}
+
+func TestParallelNodeAbortsSiblingsOnFail(t *testing.T) {
+	E, S, F := BNODE_STAT_EXECUTING, BNODE_STAT_SUCC, BNODE_STAT_FAIL
+	cases := []struct {
+		name   string
+		failer []BNodeState
+		want   []BNodeState
+		aborts int
+	}{
+		{"fails first tick", []BNodeState{F}, []BNodeState{F}, 1},
+		{"fails later", []BNodeState{E, E, F}, []BNodeState{E, E, F}, 1},
+		{"succeeds", []BNodeState{E, S}, []BNodeState{E, E}, 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			done := NewScriptedNode(2, BNODE_STAT_SUCC)
+			running := newTestAbortNode(3, BNODE_STAT_EXECUTING)
+			failer := newTestAbortNode(4, c.failer...)
+			n := NewParallelNode(1)
+			n.AddChild(done)
+			n.AddChild(running)
+			n.AddChild(failer)
+
+			ctx := NewBTreeContext(nil, 0)
+			for i, want := range c.want {
+				n.Execute(ctx)
+				if n.GetState() != want {
+					t.Fatalf("tick %d: state %d, want %d", i, n.GetState(), want)
+				}
+			}
+
+			if running.aborts != c.aborts || failer.aborts != 0 {
+				t.Fatalf("aborts running %d failer %d, want %d 0", running.aborts, failer.aborts, c.aborts)
+			}
+
+			if c.aborts > 0 && running.GetState() != BNODE_STAT_NOT_EXECUTE {
+				t.Fatalf("aborted sibling not reset, state %d", running.GetState())
+			}
+
+			if done.GetState() != BNODE_STAT_SUCC {
+				t.Fatalf("completed sibling state %d, want succ", done.GetState())
+			}
+		})
+	}
+}
+
+func TestBehaviorTreeSetRoot(t *testing.T) {
+	cases := []struct {
+		name    string
+		newRoot func() BehaviorNode
+		wantErr error
+		want    BNodeState
+	}{
+		{"select", func() BehaviorNode {
+			root := NewSelectNode(BTREE_ROOT_NODE_ID)
+			root.AddChild(NewScriptedNode(2, BNODE_STAT_FAIL))
+			root.AddChild(NewScriptedNode(3, BNODE_STAT_SUCC))
+			return root
+		}, nil, BNODE_STAT_SUCC},
+		{"decorator", func() BehaviorNode {
+			root := NewForceStateNode(BTREE_ROOT_NODE_ID, BNODE_STAT_FAIL)
+			root.AddChild(NewScriptedNode(2, BNODE_STAT_SUCC))
+			return root
+		}, nil, BNODE_STAT_FAIL},
+		{"wrong id", func() BehaviorNode { return NewSelectNode(2) }, ErrBTreeRootID, BNODE_STAT_SUCC},
+		{"nil", func() BehaviorNode { return nil }, ErrBNodeNil, BNODE_STAT_SUCC},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tree := NewBehaviorTree(1)
+			old := tree.GetRootNode()
+			root := c.newRoot()
+			err := tree.SetRoot(root)
+			if err != c.wantErr {
+				t.Fatalf("err %v, want %v", err, c.wantErr)
+			}
+
+			if c.wantErr != nil {
+				root = old
+			}
+
+			if tree.GetRootNode() != root {
+				t.Fatalf("GetRootNode returned %v", tree.GetRootNode())
+			}
+
+			ctx := NewBTreeContext(nil, 0)
+			for i := 0; i < 3 && !tree.IsCompleted(); i++ {
+				tree.Execute(ctx)
+			}
+
+			if tree.GetState() != c.want || root.GetState() != c.want {
+				t.Fatalf("state tree %d root %d, want %d", tree.GetState(), root.GetState(), c.want)
+			}
+		})
+	}
+}
+
+func newTestRuntimeTree(l AgentBNodeListener) (*BehaviorTree, *ScriptedNode, *ScriptedNode) {
+	first := NewScriptedNode(2, BNODE_STAT_SUCC)
+	last := NewScriptedNode(4, BNODE_STAT_SUCC)
+	tree := newTestTree(first, NewAgentBNode(3, 10, 3, l), last)
+	return tree, first, last
+}
+
+func TestBehaviorTreeRuntimeRoundTrip(t *testing.T) {
+	E, S := BNODE_STAT_EXECUTING, BNODE_STAT_SUCC
+	src, _, _ := newTestRuntimeTree(&testBNodeListener{results: []BNodeState{E}})
+	ctx := NewBTreeContext(nil, 0)
+	src.Execute(ctx)
+	src.Execute(ctx)
+	m := src.SaveRuntime()
+
+	saved := []struct {
+		nodeId uint32
+		want   NodeRuntime
+	}{
+		{BTREE_ROOT_NODE_ID, NodeRuntime{State: E}},
+		{2, NodeRuntime{State: S}},
+		{3, NodeRuntime{State: E, Step: 2}},
+		{4, NodeRuntime{State: BNODE_STAT_NOT_EXECUTE}},
+	}
+
+	for _, c := range saved {
+		if !reflect.DeepEqual(m[c.nodeId], c.want) {
+			t.Fatalf("node %d saved %+v, want %+v", c.nodeId, m[c.nodeId], c.want)
+		}
+	}
+
+	cases := []struct {
+		name    string
+		results []BNodeState
+		want    BNodeState
+		lastRun int
+	}{
+		{"resumes the running action", []BNodeState{S}, S, 1},
+		{"step limit kept", []BNodeState{E}, BNODE_STAT_FAIL, 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dst, first, last := newTestRuntimeTree(&testBNodeListener{results: c.results})
+			dst.RestoreRuntime(m)
+			dst.Execute(ctx)
+			if dst.GetState() != c.want {
+				t.Fatalf("state %d, want %d", dst.GetState(), c.want)
+			}
+
+			if first.GetExecCount() != 0 || last.GetExecCount() != c.lastRun {
+				t.Fatalf("first ran %d last ran %d, want 0 %d", first.GetExecCount(), last.GetExecCount(), c.lastRun)
+			}
+		})
+	}
+}
+
+// TestBehaviorTreeRuntimeRoundTripMemory restores a memory node whose first
+// child in order completed and second one is executing, the first child
+// must not run again and the order must be kept.
+func TestBehaviorTreeRuntimeRoundTripMemory(t *testing.T) {
+	E, S, F := BNODE_STAT_EXECUTING, BNODE_STAT_SUCC, BNODE_STAT_FAIL
+	cases := []struct {
+		name     string
+		newNode  func(seed int64) BehaviorNode
+		first    BNodeState
+		lastRuns int
+	}{
+		{"mem sequence", func(int64) BehaviorNode { return NewMemSequenceNode(2) }, S, 1},
+		{"mem select", func(int64) BehaviorNode { return NewMemSelectNode(2) }, F, 0},
+		{"random sequence", func(seed int64) BehaviorNode {
+			n := NewRandomSequenceNode(2)
+			n.SetRandSource(rand.New(rand.NewSource(seed)))
+			return n
+		}, S, 1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			newTree := func(seed int64, results ...BNodeState) (*BehaviorTree, []*ScriptedNode) {
+				node := c.newNode(seed)
+				leaves := make([]*ScriptedNode, 0, 3)
+				for i := uint32(0); i < 3; i++ {
+					leaf := NewScriptedNode(3+i, results...)
+					leaves = append(leaves, leaf)
+					node.AddChild(leaf)
+				}
+
+				return newTestTree(node), leaves
+			}
+
+			ctx := NewBTreeContext(nil, 0)
+			src, srcLeaves := newTree(1, E, c.first, E)
+			src.Execute(ctx)
+			src.Execute(ctx)
+			m := src.SaveRuntime()
+
+			for seed := int64(2); seed <= 5; seed++ {
+				dst, dstLeaves := newTree(seed, S)
+				dst.RestoreRuntime(m)
+				dst.Execute(ctx)
+				if dst.GetState() != S {
+					t.Fatalf("seed %d: state %d, want %d", seed, dst.GetState(), S)
+				}
+
+				for i, leaf := range dstLeaves {
+					wantRuns := []int{c.lastRuns, 1, 0}[srcLeaves[i].GetExecCount()]
+					if leaf.GetExecCount() != wantRuns {
+						t.Fatalf("seed %d: leaf %d ran %d times, want %d", seed, leaf.GetID(), leaf.GetExecCount(), wantRuns)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestBehaviorTreeRestoreRuntimeMissingNodes(t *testing.T) {
+	tree, first, _ := newTestRuntimeTree(&testBNodeListener{results: []BNodeState{BNODE_STAT_EXECUTING}})
+	ctx := NewBTreeContext(nil, 0)
+	tree.Execute(ctx)
+	tree.Execute(ctx)
+
+	tree.RestoreRuntime(map[uint32]NodeRuntime{2: {State: BNODE_STAT_FAIL}})
+	for _, nodeId := range []uint32{BTREE_ROOT_NODE_ID, 3, 4} {
+		node, _ := tree.FindNodeByID(nodeId)
+		if node.GetState() != BNODE_STAT_NOT_EXECUTE || node.GetStep() != 0 {
+			t.Fatalf("node %d state %d step %d, want reset", nodeId, node.GetState(), node.GetStep())
+		}
+	}
+
+	if first.GetState() != BNODE_STAT_FAIL {
+		t.Fatalf("node 2 state %d, want restored fail", first.GetState())
+	}
+}
+
+func TestBNodeStepReset(t *testing.T) {
+	E, S := BNODE_STAT_EXECUTING, BNODE_STAT_SUCC
+	cases := []struct {
+		name  string
+		reset func(leaf *AgentBNode, tree *BehaviorTree) BehaviorNode
+	}{
+		{"node reset", func(leaf *AgentBNode, tree *BehaviorTree) BehaviorNode {
+			leaf.Reset()
+			return leaf
+		}},
+		{"tree reset", func(leaf *AgentBNode, tree *BehaviorTree) BehaviorNode {
+			tree.Reset()
+			return leaf
+		}},
+		{"tree abort", func(leaf *AgentBNode, tree *BehaviorTree) BehaviorNode {
+			tree.Abort()
+			return leaf
+		}},
+		{"clone", func(leaf *AgentBNode, tree *BehaviorTree) BehaviorNode {
+			return leaf.CloneNode()
+		}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			leaf := NewAgentBNode(2, 10, 0, &testBNodeListener{results: []BNodeState{E, E, S}})
+			tree := newTestTree(leaf)
+			ctx := NewBTreeContext(nil, 0)
+			for !tree.IsCompleted() {
+				tree.Execute(ctx)
+			}
+
+			if leaf.GetStep() != 3 {
+				t.Fatalf("step %d after the run, want 3", leaf.GetStep())
+			}
+
+			node := c.reset(leaf, tree)
+			if node.GetStep() != 0 {
+				t.Fatalf("step %d, want 0", node.GetStep())
+			}
+		})
+	}
+}
+
+func TestBNodeStepRerunByTree(t *testing.T) {
+	E, S := BNODE_STAT_EXECUTING, BNODE_STAT_SUCC
+	l := &testBNodeListener{results: []BNodeState{E, S}}
+	leaf := NewAgentBNode(2, 10, 2, l)
+	tree := newTestTree(leaf)
+
+	// a stale step would trip maxStep in the runs after the first
+	ctx := NewBTreeContext(nil, 0)
+	for run := 0; run < 3; run++ {
+		l.calls = 0
+		tree.Reset()
+		tree.Execute(ctx)
+		tree.Execute(ctx)
+		if tree.GetState() != S || leaf.GetStep() != 2 {
+			t.Fatalf("run %d: state %s step %d, want succ 2", run, tree.GetState(), leaf.GetStep())
+		}
+	}
+}
+
+func TestBehaviorTreeActivePath(t *testing.T) {
+	E, S, F := BNODE_STAT_EXECUTING, BNODE_STAT_SUCC, BNODE_STAT_FAIL
+	inner := NewSequenceNode(5)
+	inner.AddChild(NewScriptedNode(6, E, E, S))
+	sel := NewSelectNode(3)
+	sel.AddChild(NewScriptedNode(4, F))
+	sel.AddChild(inner)
+	subtree := newTestTree(NewScriptedNode(2, E, S))
+	tree := newTestTree(NewScriptedNode(2, S), sel, NewSubtreeNode(7, subtree))
+
+	ticks := []struct {
+		name string
+		want string
+	}{
+		{"select between children", "[1 3]"},
+		{"leaf running", "[1 3 5 6]"},
+		{"leaf still running", "[1 3 5 6]"},
+		{"into the subtree", "[1 7 1 2]"},
+		{"completed", "[]"},
+	}
+
+	ctx := NewBTreeContext(nil, 0)
+	for _, tick := range ticks {
+		tree.Execute(ctx)
+		got := testNodeIDs(tree.ActivePath())
+		if got != tick.want {
+			t.Fatalf("%s: path %s, want %s", tick.name, got, tick.want)
+		}
+	}
+
+	if !tree.IsCompleted() {
+		t.Fatalf("tree state %s, want completed", tree.GetState())
+	}
+
+	if len(NewBehaviorTree(1).ActivePath()) != 0 {
+		t.Fatalf("path of a tree never executed")
+	}
+}
+
+func TestBNodeParent(t *testing.T) {
+	tree := newTestWalkTree()
+	cases := []struct {
+		nodeId   uint32
+		parentId uint32
+	}{
+		{BTREE_ROOT_NODE_ID, 0},
+		{2, BTREE_ROOT_NODE_ID},
+		{3, 2},
+		{4, 2},
+		{5, 4},
+		{6, BTREE_ROOT_NODE_ID},
+	}
+
+	for _, c := range cases {
+		t.Run(fmt.Sprint(c.nodeId), func(t *testing.T) {
+			node, _ := tree.FindNodeByID(c.nodeId)
+			parent, ok := node.GetParent()
+			if c.parentId == 0 {
+				if ok || parent != nil {
+					t.Fatalf("root has parent %v", parent)
+				}
+
+				return
+			}
+
+			want, _ := tree.FindNodeByID(c.parentId)
+			if !ok || parent != want {
+				t.Fatalf("parent %v, want node %d", parent, c.parentId)
+			}
+		})
+	}
+}
+
+func TestBNodeParentCleared(t *testing.T) {
+	cases := []struct {
+		name   string
+		remove func(parent BehaviorNode, child BehaviorNode)
+		bSeq   bool
+	}{
+		{"remove child", func(parent BehaviorNode, child BehaviorNode) { parent.RemoveChild(child) }, true},
+		{"remove child by id", func(parent BehaviorNode, child BehaviorNode) { parent.RemoveChildByID(child.GetID()) }, true},
+		{"decorator remove", func(parent BehaviorNode, child BehaviorNode) { parent.RemoveChild(child) }, false},
+		{"decorator replace", func(parent BehaviorNode, child BehaviorNode) { parent.AddChild(NewScriptedNode(3, BNODE_STAT_SUCC)) }, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var parent BehaviorNode = NewTimeoutNode(1, 3)
+			if c.bSeq {
+				parent = NewSequenceNode(1)
+			}
+
+			child := NewScriptedNode(2, BNODE_STAT_SUCC)
+			parent.AddChild(child)
+			got, ok := child.GetParent()
+			if !ok || got != parent {
+				t.Fatalf("parent %v after AddChild, want %v", got, parent)
+			}
+
+			c.remove(parent, child)
+			if _, ok := child.GetParent(); ok {
+				t.Fatalf("parent kept after remove")
+			}
+		})
+	}
+}
+
+func TestBNodeParentOfClone(t *testing.T) {
+	tree := newTestTree(NewScriptedNode(2, BNODE_STAT_SUCC))
+	c := tree.Clone(2)
+	child, _ := c.FindNodeByID(2)
+	parent, ok := child.GetParent()
+	if !ok || parent != c.GetRootNode() {
+		t.Fatalf("cloned child parent %v, want the cloned root", parent)
+	}
+
+	if _, ok := c.GetRootNode().GetParent(); ok {
+		t.Fatalf("cloned root has a parent")
+	}
+}
+
+func TestBehaviorTreeOnComplete(t *testing.T) {
+	E, S, F := BNODE_STAT_EXECUTING, BNODE_STAT_SUCC, BNODE_STAT_FAIL
+	cases := []struct {
+		name    string
+		results []BNodeState
+		ticks   int
+		want    []BNodeState
+	}{
+		{"succeeds", []BNodeState{E, E, S}, 5, []BNodeState{S}},
+		{"fails", []BNodeState{F}, 3, []BNodeState{F}},
+		{"still running", []BNodeState{E}, 3, []BNodeState{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tree := newTestTree(NewScriptedNode(2, c.results...))
+			got := make([]BNodeState, 0)
+			tree.OnComplete(func(state BNodeState) {
+				got = append(got, state)
+			})
+
+			ctx := NewBTreeContext(nil, 0)
+			for i := 0; i < c.ticks; i++ {
+				tree.Execute(ctx)
+			}
+
+			if fmt.Sprint(got) != fmt.Sprint(c.want) {
+				t.Fatalf("callbacks %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestBehaviorTreeOnCompleteRearm(t *testing.T) {
+	leaf := NewScriptedNode(2, BNODE_STAT_SUCC)
+	tree := newTestTree(leaf)
+	calls := 0
+	tree.OnComplete(func(state BNodeState) {
+		calls++
+
+		// the tree isn't locked during the callback
+		tree.GetState()
+	})
+
+	ctx := NewBTreeContext(nil, 0)
+	steps := []struct {
+		bReset bool
+		calls  int
+	}{
+		{false, 1},
+		{false, 1},
+		{true, 2},
+		{true, 3},
+		{false, 3},
+	}
+
+	for i, step := range steps {
+		if step.bReset {
+			tree.Reset()
+		}
+
+		tree.Execute(ctx)
+		if calls != step.calls {
+			t.Fatalf("step %d: %d calls, want %d", i, calls, step.calls)
+		}
+	}
+
+	tree.OnComplete(nil)
+	tree.Reset()
+	tree.Execute(ctx)
+	if calls != 3 || !tree.IsCompleted() {
+		t.Fatalf("%d calls after the callback was cleared", calls)
+	}
+}
+
+func TestControlNodeInsertChild(t *testing.T) {
+	cases := []struct {
+		name  string
+		index int
+		want  string
+	}{
+		{"front", 0, "[4 2 3]"},
+		{"middle", 1, "[2 4 3]"},
+		{"end", 2, "[2 3 4]"},
+		{"past end", 10, "[2 3 4]"},
+		{"negative", -1, "[4 2 3]"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			seq := NewSequenceNode(1)
+			seq.AddChild(NewScriptedNode(2))
+			seq.AddChild(NewScriptedNode(3))
+			child := NewScriptedNode(4)
+			seq.InsertChild(c.index, child)
+			got := testNodeIDs(seq.subNodes)
+			if got != c.want {
+				t.Fatalf("children %s, want %s", got, c.want)
+			}
+
+			if seq.ChildCount() != 3 {
+				t.Fatalf("child count = %d, want 3", seq.ChildCount())
+			}
+
+			parent, ok := child.GetParent()
+			if !ok || parent != BehaviorNode(seq) {
+				t.Fatalf("parent = %v, %v", parent, ok)
+			}
+		})
+	}
+}
+
+func TestControlNodeInsertChildNil(t *testing.T) {
+	seq := NewSequenceNode(1)
+	seq.InsertChild(0, nil)
+	if seq.ChildCount() != 0 {
+		t.Fatalf("child count = %d, want 0", seq.ChildCount())
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	BB_OP_EQ = "=="
+	BB_OP_NE = "!="
+	BB_OP_LT = "<"
+	BB_OP_GT = ">"
+	BB_OP_LE = "<="
+	BB_OP_GE = ">="
+)
+
+// Blackboard is safe for concurrent use.
+type Blackboard struct {
+	mapKey2Value map[string]interface{}
+	owners       int32
+	lck          sync.RWMutex
+}
+
+func NewBlackboard() *Blackboard {
+	return &Blackboard{
+		mapKey2Value: make(map[string]interface{}),
+		owners:       0,
+	}
+}
+
+// IsShared reports whether the blackboard is attached to more than one FSM.
+func (b *Blackboard) IsShared() bool {
+	return atomic.LoadInt32(&b.owners) > 1
+}
+
+func (b *Blackboard) retain() {
+	atomic.AddInt32(&b.owners, 1)
+}
+
+func (b *Blackboard) release() {
+	atomic.AddInt32(&b.owners, -1)
+}
+
+func (b *Blackboard) Set(key string, value interface{}) {
+	b.lck.Lock()
+	defer b.lck.Unlock()
+
+	b.mapKey2Value[key] = value
+}
+
+func (b *Blackboard) Get(key string) (interface{}, bool) {
+	b.lck.RLock()
+	defer b.lck.RUnlock()
+
+	value, ok := b.mapKey2Value[key]
+	return value, ok
+}
+
+func (b *Blackboard) Has(key string) bool {
+	b.lck.RLock()
+	defer b.lck.RUnlock()
+
+	_, ok := b.mapKey2Value[key]
+	return ok
+}
+
+func (b *Blackboard) Delete(key string) {
+	b.lck.Lock()
+	defer b.lck.Unlock()
+
+	_, ok := b.mapKey2Value[key]
+	if ok {
+		delete(b.mapKey2Value, key)
+	}
+}
+
+func (b *Blackboard) Clear() {
+	b.lck.Lock()
+	defer b.lck.Unlock()
+
+	b.mapKey2Value = make(map[string]interface{})
+}
+
+// Keys returns the keys in ascending order.
+func (b *Blackboard) Keys() []string {
+	b.lck.RLock()
+	defer b.lck.RUnlock()
+
+	keys := make([]string, 0, len(b.mapKey2Value))
+	for key := range b.mapKey2Value {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+// Snapshot returns a copy of the key value pairs, the values themselves are
+// not copied.
+func (b *Blackboard) Snapshot() map[string]interface{} {
+	b.lck.RLock()
+	defer b.lck.RUnlock()
+
+	m := make(map[string]interface{}, len(b.mapKey2Value))
+	for key, value := range b.mapKey2Value {
+		m[key] = value
+	}
+
+	return m
+}
+
+// Compare compares the value of key with value by op, one of the BB_OP_XXX.
+// Numbers are compared as float64 and strings lexically, other values only
+// support == and !=. A missing key or an invalid op is false.
+func (b *Blackboard) Compare(key string, op string, value interface{}) bool {
+	v, ok := b.Get(key)
+	if !ok {
+		return false
+	}
+
+	cmp, ok := compareBBValue(v, value)
+	if !ok {
+		switch op {
+		case BB_OP_EQ:
+			return reflect.DeepEqual(v, value)
+		case BB_OP_NE:
+			return !reflect.DeepEqual(v, value)
+		}
+
+		return false
+	}
+
+	switch op {
+	case BB_OP_EQ:
+		return cmp == 0
+	case BB_OP_NE:
+		return cmp != 0
+	case BB_OP_LT:
+		return cmp < 0
+	case BB_OP_GT:
+		return cmp > 0
+	case BB_OP_LE:
+		return cmp <= 0
+	case BB_OP_GE:
+		return cmp >= 0
+	}
+
+	return false
+}
+
+func isBBCompareOp(op string) bool {
+	switch op {
+	case BB_OP_EQ, BB_OP_NE, BB_OP_LT, BB_OP_GT, BB_OP_LE, BB_OP_GE:
+		return true
+	}
+
+	return false
+}
+
+// compareBBValue returns -1, 0 or 1, false if a and b are not both numbers
+// or both strings.
+func compareBBValue(a interface{}, b interface{}) (int, bool) {
+	sa, okA := a.(string)
+	sb, okB := b.(string)
+	if okA && okB {
+		switch {
+		case sa < sb:
+			return -1, true
+		case sa > sb:
+			return 1, true
+		}
+
+		return 0, true
+	}
+
+	fa, okA := toBBFloat(a)
+	fb, okB := toBBFloat(b)
+	if !okA || !okB {
+		return 0, false
+	}
+
+	switch {
+	case fa < fb:
+		return -1, true
+	case fa > fb:
+		return 1, true
+	}
+
+	return 0, true
+}
+
+func toBBFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+
+	return 0, false
+}
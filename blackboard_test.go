@@ -0,0 +1,144 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestBlackboardKeysAndSnapshot(t *testing.T) {
+	cases := []struct {
+		name  string
+		build func(bb *Blackboard)
+		keys  string
+		snap  string
+	}{
+		{"empty", func(bb *Blackboard) {}, "[]", "map[]"},
+		{"sorted keys", func(bb *Blackboard) {
+			bb.Set("hp", 10)
+			bb.Set("enemy", "orc")
+			bb.Set("ammo", 3)
+		}, "[ammo enemy hp]", "map[ammo:3 enemy:orc hp:10]"},
+		{"overwritten", func(bb *Blackboard) {
+			bb.Set("hp", 10)
+			bb.Set("hp", 5)
+		}, "[hp]", "map[hp:5]"},
+		{"deleted", func(bb *Blackboard) {
+			bb.Set("hp", 10)
+			bb.Set("enemy", "orc")
+			bb.Delete("enemy")
+			bb.Delete("none")
+		}, "[hp]", "map[hp:10]"},
+		{"cleared", func(bb *Blackboard) {
+			bb.Set("hp", 10)
+			bb.Clear()
+		}, "[]", "map[]"},
+		{"nil value", func(bb *Blackboard) { bb.Set("target", nil) }, "[target]", "map[target:<nil>]"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			bb := NewBlackboard()
+			c.build(bb)
+			if got := fmt.Sprint(bb.Keys()); got != c.keys {
+				t.Fatalf("Keys = %s, want %s", got, c.keys)
+			}
+
+			if got := fmt.Sprint(bb.Snapshot()); got != c.snap {
+				t.Fatalf("Snapshot = %s, want %s", got, c.snap)
+			}
+		})
+	}
+}
+
+func TestBlackboardSnapshotIsCopy(t *testing.T) {
+	bb := NewBlackboard()
+	bb.Set("hp", 10)
+	snap := bb.Snapshot()
+	snap["hp"] = 0
+	snap["ammo"] = 3
+	bb.Set("enemy", "orc")
+
+	v, _ := bb.Get("hp")
+	if v != 10 || bb.Has("ammo") || len(snap) != 2 {
+		t.Fatalf("snapshot shares the blackboard: hp %v snapshot %v", v, snap)
+	}
+}
+
+func TestBlackboardCompare(t *testing.T) {
+	bb := NewBlackboard()
+	bb.Set("hp", 30)
+	bb.Set("name", "orc")
+	bb.Set("pos", []int{1, 2})
+	cases := []struct {
+		key   string
+		op    string
+		value interface{}
+		want  bool
+	}{
+		{"hp", BB_OP_EQ, 30.0, true},
+		{"hp", BB_OP_NE, 30, false},
+		{"hp", BB_OP_LT, 31, true},
+		{"hp", BB_OP_GE, uint8(30), true},
+		{"name", BB_OP_GT, "elf", true},
+		{"name", BB_OP_LE, "orc", true},
+		{"pos", BB_OP_EQ, []int{1, 2}, true},
+		{"pos", BB_OP_NE, []int{2, 1}, true},
+		{"pos", BB_OP_LT, []int{2, 1}, false},
+		{"hp", "=~", 30, false},
+		{"mana", BB_OP_NE, 30, false},
+	}
+
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%s %s %v", c.key, c.op, c.value), func(t *testing.T) {
+			if got := bb.Compare(c.key, c.op, c.value); got != c.want {
+				t.Fatalf("Compare = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestBlackboardConcurrent(t *testing.T) {
+	const writers, keys = 8, 50
+	bb := NewBlackboard()
+	wg := sync.WaitGroup{}
+	for w := 0; w < writers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < keys; i++ {
+				key := strconv.Itoa(i)
+				bb.Set(key, w)
+				if i%5 == 0 {
+					bb.Delete(key)
+				}
+			}
+		}(w)
+
+		go func() {
+			defer wg.Done()
+			for i := 0; i < keys; i++ {
+				bb.Get(strconv.Itoa(i))
+				bb.Has(strconv.Itoa(i))
+				bb.Compare(strconv.Itoa(i), BB_OP_GE, 0)
+				bb.Keys()
+				bb.Snapshot()
+			}
+		}()
+	}
+
+	wg.Wait()
+	for i := 0; i < keys; i++ {
+		v, ok := bb.Get(strconv.Itoa(i))
+		if ok {
+			if w, bInt := v.(int); !bInt || w < 0 || w >= writers {
+				t.Fatalf("key %d value %v", i, v)
+			}
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+// BTreeBuilder builds a behavior tree by chained calls, every composite
+// must be closed by a paired End. The first composite becomes the root and
+// must use BTREE_ROOT_NODE_ID. The first error is kept and returned by Build.
+type BTreeBuilder struct {
+	treeId   uint32
+	listener AgentBNodeListener
+	root     BehaviorNode
+	stack    []BehaviorNode
+	err      error
+}
+
+func NewBTreeBuilder(treeId uint32, listener AgentBNodeListener) *BTreeBuilder {
+	return &BTreeBuilder{
+		treeId:   treeId,
+		listener: listener,
+		root:     nil,
+		stack:    make([]BehaviorNode, 0),
+		err:      nil,
+	}
+}
+
+func (b *BTreeBuilder) Sequence(nodeId uint32) *BTreeBuilder {
+	return b.Composite(NewSequenceNode(nodeId))
+}
+
+func (b *BTreeBuilder) Select(nodeId uint32) *BTreeBuilder {
+	return b.Composite(NewSelectNode(nodeId))
+}
+
+func (b *BTreeBuilder) Parallel(nodeId uint32) *BTreeBuilder {
+	return b.Composite(NewParallelNode(nodeId))
+}
+
+// Composite adds a node which takes children until the paired End.
+func (b *BTreeBuilder) Composite(node BehaviorNode) *BTreeBuilder {
+	if b.err != nil {
+		return b
+	}
+
+	if node == nil {
+		b.err = ErrBNodeNil
+		return b
+	}
+
+	if len(b.stack) == 0 {
+		if b.root != nil {
+			b.err = ErrBTreeMultiRoot
+			return b
+		}
+
+		if node.GetID() != BTREE_ROOT_NODE_ID {
+			b.err = ErrBTreeNoRoot
+			return b
+		}
+
+		b.root = node
+	} else {
+		b.stack[len(b.stack)-1].AddChild(node)
+	}
+
+	b.stack = append(b.stack, node)
+	return b
+}
+
+func (b *BTreeBuilder) Action(nodeId uint32, actionId uint32, maxStep uint32) *BTreeBuilder {
+	return b.Node(NewAgentBNode(nodeId, actionId, maxStep, b.listener))
+}
+
+// Node adds a leaf node to the current composite.
+func (b *BTreeBuilder) Node(node BehaviorNode) *BTreeBuilder {
+	if b.err != nil {
+		return b
+	}
+
+	if node == nil {
+		b.err = ErrBNodeNil
+		return b
+	}
+
+	if len(b.stack) == 0 {
+		b.err = ErrBTreeNoRoot
+		return b
+	}
+
+	b.stack[len(b.stack)-1].AddChild(node)
+	return b
+}
+
+func (b *BTreeBuilder) End() *BTreeBuilder {
+	if b.err != nil {
+		return b
+	}
+
+	if len(b.stack) == 0 {
+		b.err = ErrBTreeUnbalanced
+		return b
+	}
+
+	b.stack = b.stack[:len(b.stack)-1]
+	return b
+}
+
+func (b *BTreeBuilder) Build() (*BehaviorTree, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	if len(b.stack) != 0 {
+		return nil, ErrBTreeUnbalanced
+	}
+
+	if b.root == nil {
+		return nil, ErrBTreeNoRoot
+	}
+
+	t := NewBehaviorTree(b.treeId)
+	t.rootNode = b.root
+	return t, nil
+}
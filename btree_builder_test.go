@@ -0,0 +1,100 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import "testing"
+
+// equalTestBNode compares the structure of two node graphs.
+func equalTestBNode(a BehaviorNode, b BehaviorNode) bool {
+	if a.GetID() != b.GetID() || a.GetType() != b.GetType() {
+		return false
+	}
+
+	if a.GetActionID() != b.GetActionID() || a.GetMaxStep() != b.GetMaxStep() {
+		return false
+	}
+
+	ac, bc := a.Children(), b.Children()
+	if len(ac) != len(bc) {
+		return false
+	}
+
+	for i := range ac {
+		if !equalTestBNode(ac[i], bc[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestBTreeBuilderBuild(t *testing.T) {
+	tree, err := NewBTreeBuilder(9, nil).
+		Sequence(BTREE_ROOT_NODE_ID).
+		Select(2).
+		Action(3, 30, 1).
+		Action(4, 40, 2).
+		End().
+		Parallel(5).
+		Action(6, 60, 1).
+		End().
+		End().
+		Build()
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+
+	root := NewSequenceNode(BTREE_ROOT_NODE_ID)
+	sel := NewSelectNode(2)
+	sel.AddChild(NewAgentBNode(3, 30, 1, nil))
+	sel.AddChild(NewAgentBNode(4, 40, 2, nil))
+	parallel := NewParallelNode(5)
+	parallel.AddChild(NewAgentBNode(6, 60, 1, nil))
+	root.AddChild(sel)
+	root.AddChild(parallel)
+
+	if tree.GetID() != 9 {
+		t.Fatalf("tree id %d, want 9", tree.GetID())
+	}
+
+	if !equalTestBNode(tree.GetRootNode(), root) {
+		t.Fatalf("built tree differs from the manual one")
+	}
+
+	node, ok := tree.FindNodeByID(4)
+	if !ok {
+		t.Fatalf("node 4 not found")
+	}
+
+	parent, ok := node.GetParent()
+	if !ok || parent.GetID() != 2 {
+		t.Fatalf("parent of node 4 is not select 2")
+	}
+}
+
+func TestBTreeBuilderInvalid(t *testing.T) {
+	cases := []struct {
+		name    string
+		build   func(b *BTreeBuilder) *BTreeBuilder
+		wantErr error
+	}{
+		{"empty", func(b *BTreeBuilder) *BTreeBuilder { return b }, ErrBTreeNoRoot},
+		{"root id", func(b *BTreeBuilder) *BTreeBuilder { return b.Sequence(2).End() }, ErrBTreeNoRoot},
+		{"unclosed", func(b *BTreeBuilder) *BTreeBuilder { return b.Sequence(1).Select(2).End() }, ErrBTreeUnbalanced},
+		{"extra end", func(b *BTreeBuilder) *BTreeBuilder { return b.Sequence(1).End().End() }, ErrBTreeUnbalanced},
+		{"two roots", func(b *BTreeBuilder) *BTreeBuilder { return b.Sequence(1).End().Select(1).End() }, ErrBTreeMultiRoot},
+		{"leaf without root", func(b *BTreeBuilder) *BTreeBuilder { return b.Action(2, 1, 1) }, ErrBTreeNoRoot},
+		{"nil node", func(b *BTreeBuilder) *BTreeBuilder { return b.Sequence(1).Node(nil).End() }, ErrBNodeNil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := c.build(NewBTreeBuilder(1, nil)).Build()
+			if err != c.wantErr {
+				t.Fatalf("build = %v, want %v", err, c.wantErr)
+			}
+		})
+	}
+}
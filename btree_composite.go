@@ -0,0 +1,715 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import (
+	"math/rand"
+	"sort"
+)
+
+//========================
+//   PrioritySelectNode
+//========================
+type PriorityFunc func(param ...interface{}) int
+
+type bnodePriority struct {
+	f      PriorityFunc
+	params []interface{}
+}
+
+// PrioritySelectNode evaluates the priorities of its children every
+// execution and tries them in descending priority, children with the same
+// priority keep the adding order. A child without PriorityFunc has priority 0.
+// The executing child is aborted when a child with higher priority succeeds or
+// starts executing, a child cut off by a cancel or the tick budget is aborted
+// instead.
+type PrioritySelectNode struct {
+	*ControlNode
+	mapChild2Priority map[BehaviorNode]*bnodePriority
+	runningChild      BehaviorNode
+}
+
+func NewPrioritySelectNode(nodeId uint32) *PrioritySelectNode {
+	n := &PrioritySelectNode{
+		ControlNode:       NewControlNode(nodeId, BNODE_TYPE_SELECT),
+		mapChild2Priority: make(map[BehaviorNode]*bnodePriority),
+		runningChild:      nil,
+	}
+
+	n.owner = n
+	return n
+}
+
+func (n *PrioritySelectNode) AddChildWithPriority(child BehaviorNode, f PriorityFunc, param ...interface{}) {
+	if child == nil {
+		return
+	}
+
+	n.AddChild(child)
+	if f != nil {
+		n.mapChild2Priority[child] = &bnodePriority{
+			f:      f,
+			params: param,
+		}
+	}
+}
+
+func (n *PrioritySelectNode) CloneNode() BehaviorNode {
+	c := &PrioritySelectNode{
+		ControlNode:       n.cloneControl(),
+		mapChild2Priority: make(map[BehaviorNode]*bnodePriority),
+		runningChild:      nil,
+	}
+
+	c.owner = c
+
+	for _, child := range n.subNodes {
+		p, ok := n.mapChild2Priority[child]
+		if ok {
+			c.AddChildWithPriority(cloneBNode(child), p.f, p.params...)
+		} else {
+			c.AddChild(cloneBNode(child))
+		}
+	}
+
+	return c
+}
+
+func (n *PrioritySelectNode) RemoveChild(child BehaviorNode) {
+	n.ControlNode.RemoveChild(child)
+	delete(n.mapChild2Priority, child)
+	if n.runningChild == child {
+		n.runningChild = nil
+	}
+}
+
+func (n *PrioritySelectNode) RemoveChildByID(nodeId uint32) {
+	child, ok := n.GetChildByID(nodeId)
+	if ok {
+		n.RemoveChild(child)
+	}
+}
+
+func (n *PrioritySelectNode) Execute(ctx *BTreeContext) {
+	if n.IsCompleted() {
+		return
+	}
+
+	n.state = BNODE_STAT_EXECUTING
+
+	for _, child := range n.getSortedChildren() {
+		if child != n.runningChild {
+			child.Reset()
+		}
+
+		if !executeBNodeFully(ctx, child) {
+			if n.runningChild != nil && child != n.runningChild {
+				abortBNode(child)
+			} else if child.GetState() == BNODE_STAT_EXECUTING {
+				n.runningChild = child
+			}
+
+			return
+		}
+
+		if child.GetState() == BNODE_STAT_FAIL {
+			if child == n.runningChild {
+				n.runningChild = nil
+			}
+
+			continue
+		}
+
+		if n.runningChild != nil && n.runningChild != child {
+			abortBNode(n.runningChild)
+		}
+
+		n.runningChild = nil
+		if !child.IsCompleted() {
+			n.runningChild = child
+			return
+		}
+
+		n.state = BNODE_STAT_SUCC
+		return
+	}
+
+	n.state = BNODE_STAT_FAIL
+}
+
+func (n *PrioritySelectNode) Reset() {
+	n.ControlNode.Reset()
+	n.runningChild = nil
+}
+
+func (n *PrioritySelectNode) getSortedChildren() []BehaviorNode {
+	children := make([]BehaviorNode, len(n.subNodes))
+	copy(children, n.subNodes)
+
+	priorities := make(map[BehaviorNode]int, len(children))
+	for _, child := range children {
+		p, ok := n.mapChild2Priority[child]
+		if ok {
+			priorities[child] = p.f(p.params...)
+		}
+	}
+
+	sort.SliceStable(children, func(i, j int) bool {
+		return priorities[children[i]] > priorities[children[j]]
+	})
+
+	return children
+}
+
+//========================
+//   RandomSequenceNode
+//========================
+// RandomSequenceNode is a memory sequence executing its children in an
+// order shuffled at the beginning of each run, Reset drops the order. The
+// children removed during a run are skipped, the ones added wait for the
+// next run.
+type RandomSequenceNode struct {
+	*ControlNode
+	rnd          *rand.Rand
+	order        []BehaviorNode
+	runningIndex int
+}
+
+func NewRandomSequenceNode(nodeId uint32) *RandomSequenceNode {
+	n := &RandomSequenceNode{
+		ControlNode:  NewControlNode(nodeId, BNODE_TYPE_SEQUENCE),
+		rnd:          nil,
+		order:        nil,
+		runningIndex: 0,
+	}
+
+	n.owner = n
+	return n
+}
+
+// SetRandSource sets the source used to shuffle, nil uses the global one.
+func (n *RandomSequenceNode) SetRandSource(rnd *rand.Rand) {
+	n.rnd = rnd
+}
+
+// CloneNode shares the rand source with the clone.
+func (n *RandomSequenceNode) CloneNode() BehaviorNode {
+	c := &RandomSequenceNode{
+		ControlNode:  n.cloneControl(),
+		rnd:          n.rnd,
+		order:        nil,
+		runningIndex: 0,
+	}
+
+	c.owner = c
+	n.cloneChildren(c)
+	return c
+}
+
+func (n *RandomSequenceNode) Execute(ctx *BTreeContext) {
+	if n.IsCompleted() {
+		return
+	}
+
+	if n.order == nil {
+		n.order = n.shuffle()
+	}
+
+	n.state = BNODE_STAT_EXECUTING
+
+	for n.runningIndex < len(n.order) {
+		child := n.order[n.runningIndex]
+		if !containsBNode(n.subNodes, child) {
+			n.runningIndex++
+			continue
+		}
+
+		executeBNode(ctx, child)
+		if !child.IsCompleted() {
+			return
+		}
+
+		if child.GetState() == BNODE_STAT_FAIL {
+			n.state = BNODE_STAT_FAIL
+			return
+		}
+
+		n.runningIndex++
+	}
+
+	n.state = BNODE_STAT_SUCC
+}
+
+func (n *RandomSequenceNode) Reset() {
+	n.ControlNode.Reset()
+	n.order = nil
+	n.runningIndex = 0
+}
+
+func (n *RandomSequenceNode) shuffle() []BehaviorNode {
+	var perm []int
+	if n.rnd != nil {
+		perm = n.rnd.Perm(len(n.subNodes))
+	} else {
+		perm = rand.Perm(len(n.subNodes))
+	}
+
+	order := make([]BehaviorNode, 0, len(perm))
+	for _, i := range perm {
+		order = append(order, n.subNodes[i])
+	}
+
+	return order
+}
+
+func containsBNode(nodes []BehaviorNode, node BehaviorNode) bool {
+	for _, exist := range nodes {
+		if exist == node {
+			return true
+		}
+	}
+
+	return false
+}
+
+//========================
+//   DynamicSelectNode
+//========================
+// BNodeSupplier returns the children of a DynamicSelectNode. The nodes are
+// reset, reparented and run by the node, so the supplier must return nodes
+// used by no other node or tree, e.g. fresh ones on each call.
+type BNodeSupplier func() []BehaviorNode
+
+// DynamicSelectNode is a memory select whose children are queried from the
+// supplier at the beginning of each run, the supplied children are reset.
+// Reset makes the next execution query again.
+type DynamicSelectNode struct {
+	*ControlNode
+	supplier     BNodeSupplier
+	bQueried     bool
+	runningIndex int
+}
+
+func NewDynamicSelectNode(nodeId uint32, supplier BNodeSupplier) *DynamicSelectNode {
+	n := &DynamicSelectNode{
+		ControlNode:  NewControlNode(nodeId, BNODE_TYPE_SELECT),
+		supplier:     supplier,
+		bQueried:     false,
+		runningIndex: 0,
+	}
+
+	n.owner = n
+	return n
+}
+
+// CloneNode shares the supplier, the children are supplied again, so the
+// clones share the children of a supplier returning the same nodes on each
+// call, see BNodeSupplier.
+func (n *DynamicSelectNode) CloneNode() BehaviorNode {
+	c := &DynamicSelectNode{
+		ControlNode:  n.cloneControl(),
+		supplier:     n.supplier,
+		bQueried:     false,
+		runningIndex: 0,
+	}
+
+	c.owner = c
+	return c
+}
+
+func (n *DynamicSelectNode) Execute(ctx *BTreeContext) {
+	if n.IsCompleted() {
+		return
+	}
+
+	if !n.bQueried {
+		n.query()
+	}
+
+	n.state = BNODE_STAT_EXECUTING
+
+	for n.runningIndex < len(n.subNodes) {
+		child := n.subNodes[n.runningIndex]
+		executeBNode(ctx, child)
+		if !child.IsCompleted() {
+			return
+		}
+
+		if child.GetState() == BNODE_STAT_SUCC {
+			n.state = BNODE_STAT_SUCC
+			return
+		}
+
+		n.runningIndex++
+	}
+
+	n.state = BNODE_STAT_FAIL
+}
+
+func (n *DynamicSelectNode) Reset() {
+	n.ControlNode.Reset()
+	n.bQueried = false
+	n.runningIndex = 0
+}
+
+func (n *DynamicSelectNode) isChildSupplied() bool {
+	return n.supplier != nil
+}
+
+func (n *DynamicSelectNode) query() {
+	n.clearChildren()
+	if n.supplier != nil {
+		for _, child := range n.supplier() {
+			if child != nil {
+				child.Reset()
+				n.subNodes = append(n.subNodes, child)
+				setBNodeParent(child, n.BaseBehaviorNode)
+			}
+		}
+	}
+
+	n.bQueried = true
+	n.runningIndex = 0
+}
+
+//========================
+//   ParallelMonitorNode
+//========================
+// ParallelMonitorNode executes all its children each tick, the children
+// completed in the previous tick are reset and run again. It fails when
+// failureThreshold children fail in the same tick, aborting the executing
+// ones, and succeeds when all succeed in the same tick. A failureThreshold 0
+// never fails.
+type ParallelMonitorNode struct {
+	*ControlNode
+	failureThreshold uint32
+}
+
+func NewParallelMonitorNode(nodeId uint32, failureThreshold uint32) *ParallelMonitorNode {
+	n := &ParallelMonitorNode{
+		ControlNode:      NewControlNode(nodeId, BNODE_TYPE_PARALLEL),
+		failureThreshold: failureThreshold,
+	}
+
+	n.owner = n
+	return n
+}
+
+func (n *ParallelMonitorNode) CloneNode() BehaviorNode {
+	c := &ParallelMonitorNode{
+		ControlNode:      n.cloneControl(),
+		failureThreshold: n.failureThreshold,
+	}
+
+	c.owner = c
+	n.cloneChildren(c)
+	return c
+}
+
+func (n *ParallelMonitorNode) Execute(ctx *BTreeContext) {
+	if n.IsCompleted() {
+		return
+	}
+
+	n.state = BNODE_STAT_EXECUTING
+
+	failures := uint32(0)
+	bAllSucc := true
+	for _, child := range n.subNodes {
+		if child.IsCompleted() {
+			child.Reset()
+		}
+
+		executeBNode(ctx, child)
+		switch child.GetState() {
+		case BNODE_STAT_SUCC:
+		case BNODE_STAT_FAIL:
+			failures++
+			bAllSucc = false
+		default:
+			bAllSucc = false
+		}
+	}
+
+	if n.failureThreshold > 0 && failures >= n.failureThreshold {
+		for _, child := range n.subNodes {
+			if child.GetState() == BNODE_STAT_EXECUTING {
+				abortBNode(child)
+			}
+		}
+
+		n.state = BNODE_STAT_FAIL
+		return
+	}
+
+	if bAllSucc {
+		n.state = BNODE_STAT_SUCC
+	}
+}
+
+//========================
+//   UtilitySelectNode
+//========================
+type ScoreFunc func(param ...interface{}) float64
+
+type bnodeScore struct {
+	f      ScoreFunc
+	params []interface{}
+}
+
+// UtilitySelectNode scores its children every execution and executes the
+// one with the highest score, the earlier added for the same score, and takes
+// its state. A child without ScoreFunc scores 0. The executing child is
+// aborted when another one scores higher.
+type UtilitySelectNode struct {
+	*ControlNode
+	mapChild2Score map[BehaviorNode]*bnodeScore
+	runningChild   BehaviorNode
+}
+
+func NewUtilitySelectNode(nodeId uint32) *UtilitySelectNode {
+	n := &UtilitySelectNode{
+		ControlNode:    NewControlNode(nodeId, BNODE_TYPE_SELECT),
+		mapChild2Score: make(map[BehaviorNode]*bnodeScore),
+		runningChild:   nil,
+	}
+
+	n.owner = n
+	return n
+}
+
+func (n *UtilitySelectNode) AddScoredChild(child BehaviorNode, f ScoreFunc, param ...interface{}) {
+	if child == nil {
+		return
+	}
+
+	n.AddChild(child)
+	if f != nil {
+		n.mapChild2Score[child] = &bnodeScore{
+			f:      f,
+			params: param,
+		}
+	}
+}
+
+func (n *UtilitySelectNode) CloneNode() BehaviorNode {
+	c := &UtilitySelectNode{
+		ControlNode:    n.cloneControl(),
+		mapChild2Score: make(map[BehaviorNode]*bnodeScore),
+		runningChild:   nil,
+	}
+
+	c.owner = c
+
+	for _, child := range n.subNodes {
+		score, ok := n.mapChild2Score[child]
+		if ok {
+			c.AddScoredChild(cloneBNode(child), score.f, score.params...)
+		} else {
+			c.AddChild(cloneBNode(child))
+		}
+	}
+
+	return c
+}
+
+func (n *UtilitySelectNode) RemoveChild(child BehaviorNode) {
+	n.ControlNode.RemoveChild(child)
+	delete(n.mapChild2Score, child)
+	if n.runningChild == child {
+		n.runningChild = nil
+	}
+}
+
+func (n *UtilitySelectNode) RemoveChildByID(nodeId uint32) {
+	child, ok := n.GetChildByID(nodeId)
+	if ok {
+		n.RemoveChild(child)
+	}
+}
+
+func (n *UtilitySelectNode) Execute(ctx *BTreeContext) {
+	if n.IsCompleted() {
+		return
+	}
+
+	best := n.getBestChild()
+	if best == nil {
+		n.state = BNODE_STAT_FAIL
+		return
+	}
+
+	if n.runningChild != nil && n.runningChild != best {
+		abortBNode(n.runningChild)
+		n.runningChild = nil
+	}
+
+	if best != n.runningChild {
+		best.Reset()
+	}
+
+	executeBNode(ctx, best)
+	n.state = best.GetState()
+	n.runningChild = nil
+	if n.state == BNODE_STAT_EXECUTING {
+		n.runningChild = best
+	}
+}
+
+func (n *UtilitySelectNode) Reset() {
+	n.ControlNode.Reset()
+	n.runningChild = nil
+}
+
+func (n *UtilitySelectNode) getBestChild() BehaviorNode {
+	var best BehaviorNode
+	bestScore := 0.0
+	for _, child := range n.subNodes {
+		score := 0.0
+		s, ok := n.mapChild2Score[child]
+		if ok {
+			score = s.f(s.params...)
+		}
+
+		if best == nil || score > bestScore {
+			best = child
+			bestScore = score
+		}
+	}
+
+	return best
+}
+
+//========================
+//   WeightedRandomNode
+//========================
+// WeightedRandomNode picks one child by weight at the beginning of each run,
+// executes only it and takes its state. A child with weight <= 0 is never
+// picked, the node fails if no child can be picked.
+type WeightedRandomNode struct {
+	*ControlNode
+	mapChild2Weight map[BehaviorNode]float64
+	rnd             *rand.Rand
+	pickedChild     BehaviorNode
+}
+
+func NewWeightedRandomNode(nodeId uint32) *WeightedRandomNode {
+	n := &WeightedRandomNode{
+		ControlNode:     NewControlNode(nodeId, BNODE_TYPE_SELECT),
+		mapChild2Weight: make(map[BehaviorNode]float64),
+		rnd:             nil,
+		pickedChild:     nil,
+	}
+
+	n.owner = n
+	return n
+}
+
+func (n *WeightedRandomNode) AddWeightedChild(child BehaviorNode, weight float64) {
+	if child == nil {
+		return
+	}
+
+	n.AddChild(child)
+	n.mapChild2Weight[child] = weight
+}
+
+// SetRandSource sets the source used to pick, nil uses the global one.
+func (n *WeightedRandomNode) SetRandSource(rnd *rand.Rand) {
+	n.rnd = rnd
+}
+
+// CloneNode shares the rand source with the clone.
+func (n *WeightedRandomNode) CloneNode() BehaviorNode {
+	c := &WeightedRandomNode{
+		ControlNode:     n.cloneControl(),
+		mapChild2Weight: make(map[BehaviorNode]float64),
+		rnd:             n.rnd,
+		pickedChild:     nil,
+	}
+
+	c.owner = c
+
+	for _, child := range n.subNodes {
+		c.AddWeightedChild(cloneBNode(child), n.mapChild2Weight[child])
+	}
+
+	return c
+}
+
+func (n *WeightedRandomNode) RemoveChild(child BehaviorNode) {
+	n.ControlNode.RemoveChild(child)
+	delete(n.mapChild2Weight, child)
+	if n.pickedChild == child {
+		n.pickedChild = nil
+	}
+}
+
+func (n *WeightedRandomNode) RemoveChildByID(nodeId uint32) {
+	child, ok := n.GetChildByID(nodeId)
+	if ok {
+		n.RemoveChild(child)
+	}
+}
+
+func (n *WeightedRandomNode) Execute(ctx *BTreeContext) {
+	if n.IsCompleted() {
+		return
+	}
+
+	if n.pickedChild == nil {
+		n.pickedChild = n.pick()
+		if n.pickedChild == nil {
+			n.state = BNODE_STAT_FAIL
+			return
+		}
+	}
+
+	executeBNode(ctx, n.pickedChild)
+	n.state = n.pickedChild.GetState()
+}
+
+func (n *WeightedRandomNode) Reset() {
+	n.ControlNode.Reset()
+	n.pickedChild = nil
+}
+
+func (n *WeightedRandomNode) pick() BehaviorNode {
+	total := 0.0
+	for _, child := range n.subNodes {
+		weight := n.mapChild2Weight[child]
+		if weight > 0 {
+			total += weight
+		}
+	}
+
+	if total <= 0 {
+		return nil
+	}
+
+	var r float64
+	if n.rnd != nil {
+		r = n.rnd.Float64() * total
+	} else {
+		r = rand.Float64() * total
+	}
+
+	var last BehaviorNode
+	for _, child := range n.subNodes {
+		weight := n.mapChild2Weight[child]
+		if weight <= 0 {
+			continue
+		}
+
+		if r < weight {
+			return child
+		}
+
+		r -= weight
+		last = child
+	}
+
+	return last
+}
@@ -0,0 +1,469 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestPrioritySelectNode(t *testing.T) {
+	cases := []struct {
+		name        string
+		threats     []int
+		fleeExecs   int
+		attackExecs int
+		aborts      int
+	}{
+		{"attack only", []int{0, 0, 0}, 0, 3, 0},
+		{"flee interrupts attack", []int{0, 5}, 1, 1, 1},
+		{"flee first", []int{5, 5}, 2, 0, 0},
+		{"back to attack", []int{0, 5, 0}, 1, 2, 1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			threat := 0
+			attack := newTestAbortNode(2, BNODE_STAT_EXECUTING)
+			flee := NewScriptedNode(3, BNODE_STAT_EXECUTING)
+			n := NewPrioritySelectNode(1)
+			n.AddChildWithPriority(attack, func(param ...interface{}) int { return 1 })
+			n.AddChildWithPriority(flee, func(param ...interface{}) int {
+				return *param[0].(*int)
+			}, &threat)
+
+			ctx := NewBTreeContext(nil, 0)
+			for _, threat = range c.threats {
+				n.Execute(ctx)
+				if n.GetState() != BNODE_STAT_EXECUTING {
+					t.Fatalf("state %d, want executing", n.GetState())
+				}
+			}
+
+			if flee.GetExecCount() != c.fleeExecs || attack.GetExecCount() != c.attackExecs {
+				t.Fatalf("flee %d attack %d executions, want %d %d", flee.GetExecCount(), attack.GetExecCount(), c.fleeExecs, c.attackExecs)
+			}
+
+			if attack.aborts != c.aborts {
+				t.Fatalf("attack aborted %d times, want %d", attack.aborts, c.aborts)
+			}
+
+			if c.aborts > 0 && c.threats[len(c.threats)-1] > 1 && attack.GetState() != BNODE_STAT_NOT_EXECUTE {
+				t.Fatalf("interrupted attack not reset, state %d", attack.GetState())
+			}
+		})
+	}
+}
+
+func TestPrioritySelectNodeSucceeds(t *testing.T) {
+	n := NewPrioritySelectNode(1)
+	n.AddChild(NewScriptedNode(2, BNODE_STAT_FAIL))
+	n.AddChildWithPriority(NewScriptedNode(3, BNODE_STAT_SUCC), func(param ...interface{}) int { return -1 })
+	n.Execute(NewBTreeContext(nil, 0))
+	if n.GetState() != BNODE_STAT_SUCC {
+		t.Fatalf("state %d, want succ", n.GetState())
+	}
+
+	empty := NewPrioritySelectNode(1)
+	empty.Execute(NewBTreeContext(nil, 0))
+	if empty.GetState() != BNODE_STAT_FAIL {
+		t.Fatalf("empty state %d, want fail", empty.GetState())
+	}
+}
+
+func TestRandomSequenceNode(t *testing.T) {
+	const seed = 7
+	cases := []struct {
+		name  string
+		fail  int
+		want  BNodeState
+		count int
+	}{
+		{"all succeed", -1, BNODE_STAT_SUCC, 4},
+		{"mid list failure", 1, BNODE_STAT_FAIL, 2},
+		{"first fails", 0, BNODE_STAT_FAIL, 1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			order := rand.New(rand.NewSource(seed)).Perm(4)
+			executed := make([]int, 0)
+			n := NewRandomSequenceNode(1)
+			n.SetRandSource(rand.New(rand.NewSource(seed)))
+			for i := 0; i < 4; i++ {
+				idx := i
+				n.AddChild(newTestFuncNode(uint32(idx+2), func() BNodeState {
+					executed = append(executed, idx)
+					if c.fail >= 0 && idx == order[c.fail] {
+						return BNODE_STAT_FAIL
+					}
+
+					return BNODE_STAT_SUCC
+				}))
+			}
+
+			n.Execute(NewBTreeContext(nil, 0))
+			if n.GetState() != c.want {
+				t.Fatalf("state %d, want %d", n.GetState(), c.want)
+			}
+
+			if fmt.Sprint(executed) != fmt.Sprint(order[:c.count]) {
+				t.Fatalf("executed %v, want %v", executed, order[:c.count])
+			}
+		})
+	}
+}
+
+func TestRandomSequenceNodeKeepsOrder(t *testing.T) {
+	executed := make([]uint32, 0)
+	n := NewRandomSequenceNode(1)
+	n.SetRandSource(rand.New(rand.NewSource(3)))
+	for i := uint32(2); i < 8; i++ {
+		nodeId := i
+		n.AddChild(newTestFuncNode(nodeId, func() BNodeState {
+			executed = append(executed, nodeId)
+			if len(executed) <= 6 && len(executed)%2 == 1 {
+				return BNODE_STAT_EXECUTING
+			}
+
+			return BNODE_STAT_SUCC
+		}))
+	}
+
+	ctx := NewBTreeContext(nil, 0)
+	for i := 0; i < 10 && !n.IsCompleted(); i++ {
+		n.Execute(ctx)
+	}
+
+	// each of the first three children runs twice in a row, the order is
+	// kept while the sequence is running
+	for i := 0; i < 6; i += 2 {
+		if executed[i] != executed[i+1] {
+			t.Fatalf("order changed while running, executed %v", executed)
+		}
+	}
+
+	if n.GetState() != BNODE_STAT_SUCC || len(executed) != 9 {
+		t.Fatalf("state %d executed %v", n.GetState(), executed)
+	}
+}
+
+func TestRandomSequenceNodeRemoveChildRunning(t *testing.T) {
+	for seed := int64(1); seed <= 8; seed++ {
+		n := NewRandomSequenceNode(1)
+		n.SetRandSource(rand.New(rand.NewSource(seed)))
+		children := make([]*ScriptedNode, 0)
+		for i := uint32(2); i < 5; i++ {
+			children = append(children, NewScriptedNode(i, BNODE_STAT_SUCC))
+		}
+
+		running := NewScriptedNode(5, BNODE_STAT_EXECUTING, BNODE_STAT_SUCC)
+		children = append(children, running)
+		for _, child := range children {
+			n.AddChild(child)
+		}
+
+		ctx := NewBTreeContext(nil, 0)
+		n.Execute(ctx)
+		removed := children[0]
+		bRemovedRan := removed.GetExecCount() == 1
+		n.RemoveChildByID(removed.GetID())
+		for i := 0; i < 10 && !n.IsCompleted(); i++ {
+			n.Execute(ctx)
+		}
+
+		if n.GetState() != BNODE_STAT_SUCC || running.GetState() != BNODE_STAT_SUCC || running.GetExecCount() != 2 {
+			t.Fatalf("seed %d: state %d, running child state %d runs %d", seed, n.GetState(), running.GetState(), running.GetExecCount())
+		}
+
+		for _, child := range children[1:3] {
+			if child.GetExecCount() != 1 {
+				t.Fatalf("seed %d: child %d ran %d times, want 1", seed, child.GetID(), child.GetExecCount())
+			}
+		}
+
+		if bRemovedRan != (removed.GetExecCount() == 1) || removed.GetExecCount() > 1 {
+			t.Fatalf("seed %d: removed child ran after removal", seed)
+		}
+	}
+}
+
+func TestDynamicSelectNode(t *testing.T) {
+	melee := NewScriptedNode(2, BNODE_STAT_SUCC)
+	ranged := NewScriptedNode(3, BNODE_STAT_SUCC)
+	blocked := NewScriptedNode(4, BNODE_STAT_FAIL)
+	cases := []struct {
+		name     string
+		supplied []BehaviorNode
+		want     BNodeState
+		ran      []int
+	}{
+		{"melee", []BehaviorNode{melee}, BNODE_STAT_SUCC, []int{1, 0, 0}},
+		{"ranged after a failure", []BehaviorNode{blocked, ranged}, BNODE_STAT_SUCC, []int{0, 1, 1}},
+		{"nothing viable", []BehaviorNode{blocked}, BNODE_STAT_FAIL, []int{0, 0, 1}},
+		{"nothing supplied", []BehaviorNode{}, BNODE_STAT_FAIL, []int{0, 0, 0}},
+	}
+
+	var supplied []BehaviorNode
+	n := NewDynamicSelectNode(1, func() []BehaviorNode { return supplied })
+	ctx := NewBTreeContext(nil, 0)
+	for _, c := range cases {
+		supplied = c.supplied
+		counts := []int{melee.GetExecCount(), ranged.GetExecCount(), blocked.GetExecCount()}
+		n.Reset()
+		n.Execute(ctx)
+		if n.GetState() != c.want {
+			t.Fatalf("%s: state %d, want %d", c.name, n.GetState(), c.want)
+		}
+
+		for i, node := range []*ScriptedNode{melee, ranged, blocked} {
+			if node.GetExecCount()-counts[i] != c.ran[i] {
+				t.Fatalf("%s: node %d ran %d times, want %d", c.name, node.GetID(), node.GetExecCount()-counts[i], c.ran[i])
+			}
+		}
+
+		if len(n.Children()) != len(c.supplied) {
+			t.Fatalf("%s: %d children, want %d", c.name, len(n.Children()), len(c.supplied))
+		}
+	}
+}
+
+func TestParallelMonitorNode(t *testing.T) {
+	E, S, F := BNODE_STAT_EXECUTING, BNODE_STAT_SUCC, BNODE_STAT_FAIL
+	cases := []struct {
+		name      string
+		threshold uint32
+		healthy   []bool
+		want      []BNodeState
+		aborts    int
+	}{
+		{"keeps monitoring", 1, []bool{true, true, true}, []BNodeState{E, E, E}, 0},
+		{"health drops", 1, []bool{true, true, false}, []BNodeState{E, E, F}, 1},
+		{"toggles below threshold", 2, []bool{false, true, false, true}, []BNodeState{E, E, E, E}, 0},
+		{"never fails", 0, []bool{false, false}, []BNodeState{E, E}, 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			checks := 0
+			health := newTestFuncNode(2, func() BNodeState {
+				checks++
+				if c.healthy[checks-1] {
+					return S
+				}
+
+				return F
+			})
+			attack := newTestAbortNode(3, E)
+			n := NewParallelMonitorNode(1, c.threshold)
+			n.AddChild(health)
+			n.AddChild(attack)
+
+			ctx := NewBTreeContext(nil, 0)
+			for i, want := range c.want {
+				n.Execute(ctx)
+				if n.GetState() != want {
+					t.Fatalf("tick %d: state %d, want %d", i, n.GetState(), want)
+				}
+			}
+
+			if checks != len(c.want) || attack.GetExecCount() != len(c.want) {
+				t.Fatalf("health checked %d attack ran %d, want %d each", checks, attack.GetExecCount(), len(c.want))
+			}
+
+			if attack.aborts != c.aborts {
+				t.Fatalf("attack aborted %d times, want %d", attack.aborts, c.aborts)
+			}
+		})
+	}
+}
+
+func TestParallelMonitorNodeSucceeds(t *testing.T) {
+	E, S := BNODE_STAT_EXECUTING, BNODE_STAT_SUCC
+	first := NewScriptedNode(2, S)
+	second := NewScriptedNode(3, E, S)
+	n := NewParallelMonitorNode(1, 1)
+	n.AddChild(first)
+	n.AddChild(second)
+
+	ctx := NewBTreeContext(nil, 0)
+	for i, want := range []BNodeState{E, S} {
+		n.Execute(ctx)
+		if n.GetState() != want {
+			t.Fatalf("tick %d: state %d, want %d", i, n.GetState(), want)
+		}
+	}
+
+	if first.GetExecCount() != 2 {
+		t.Fatalf("completed child ran %d times, want re-run each tick", first.GetExecCount())
+	}
+}
+
+func TestUtilitySelectNode(t *testing.T) {
+	ticks := []struct {
+		name   string
+		eat    float64
+		flee   float64
+		runs   []int
+		aborts []int
+	}{
+		{"hungry", 0.8, 0.2, []int{1, 0, 0}, []int{0, 0, 0}},
+		{"still hungry", 0.7, 0.2, []int{2, 0, 0}, []int{0, 0, 0}},
+		{"enemy close", 0.7, 0.9, []int{2, 1, 0}, []int{1, 0, 0}},
+		{"tie keeps earlier", 0.5, 0.5, []int{3, 1, 0}, []int{1, 1, 0}},
+		{"nothing scores", -1, -1, []int{3, 1, 1}, []int{2, 1, 0}},
+	}
+
+	var eatScore, fleeScore float64
+	eat := newTestAbortNode(2, BNODE_STAT_EXECUTING)
+	flee := newTestAbortNode(3, BNODE_STAT_EXECUTING)
+	idle := newTestAbortNode(4, BNODE_STAT_EXECUTING)
+	n := NewUtilitySelectNode(1)
+	n.AddScoredChild(eat, func(param ...interface{}) float64 { return eatScore })
+	n.AddScoredChild(flee, func(param ...interface{}) float64 { return fleeScore })
+	n.AddScoredChild(idle, nil)
+
+	ctx := NewBTreeContext(nil, 0)
+	for _, tick := range ticks {
+		eatScore, fleeScore = tick.eat, tick.flee
+		n.Execute(ctx)
+		if n.GetState() != BNODE_STAT_EXECUTING {
+			t.Fatalf("%s: state %s", tick.name, n.GetState())
+		}
+
+		for i, child := range []*testAbortNode{eat, flee, idle} {
+			if child.GetExecCount() != tick.runs[i] || child.aborts != tick.aborts[i] {
+				t.Fatalf("%s: child %d runs %d aborts %d, want %d %d", tick.name, child.GetID(), child.GetExecCount(), child.aborts, tick.runs[i], tick.aborts[i])
+			}
+		}
+	}
+}
+
+func TestUtilitySelectNodeResult(t *testing.T) {
+	cases := []struct {
+		name  string
+		child BNodeState
+		want  BNodeState
+	}{
+		{"best succeeds", BNODE_STAT_SUCC, BNODE_STAT_SUCC},
+		{"best fails without fallback", BNODE_STAT_FAIL, BNODE_STAT_FAIL},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			best := NewScriptedNode(2, c.child)
+			other := NewScriptedNode(3, BNODE_STAT_SUCC)
+			n := NewUtilitySelectNode(1)
+			n.AddScoredChild(best, func(param ...interface{}) float64 { return param[0].(float64) }, 2.0)
+			n.AddScoredChild(other, func(param ...interface{}) float64 { return 1 })
+			n.Execute(NewBTreeContext(nil, 0))
+			if n.GetState() != c.want || other.GetExecCount() != 0 {
+				t.Fatalf("state %s other runs %d, want %s 0", n.GetState(), other.GetExecCount(), c.want)
+			}
+		})
+	}
+
+	empty := NewUtilitySelectNode(1)
+	empty.Execute(NewBTreeContext(nil, 0))
+	if empty.GetState() != BNODE_STAT_FAIL {
+		t.Fatalf("empty utility select state %s, want fail", empty.GetState())
+	}
+}
+
+func TestWeightedRandomNode(t *testing.T) {
+	const runs = 4000
+	walk := NewScriptedNode(2, BNODE_STAT_FAIL)
+	idle := NewScriptedNode(3, BNODE_STAT_SUCC)
+	never := NewScriptedNode(4, BNODE_STAT_SUCC)
+	n := NewWeightedRandomNode(1)
+	n.AddWeightedChild(walk, 1)
+	n.AddWeightedChild(idle, 3)
+	n.AddWeightedChild(never, 0)
+	n.SetRandSource(rand.New(rand.NewSource(3)))
+
+	ctx := NewBTreeContext(nil, 0)
+	for i := 0; i < runs; i++ {
+		walks, idles := walk.GetExecCount(), idle.GetExecCount()
+		n.Reset()
+		n.Execute(ctx)
+		walks, idles = walk.GetExecCount()-walks, idle.GetExecCount()-idles
+		if walks+idles != 1 {
+			t.Fatalf("run %d executed %d children, want 1", i, walks+idles)
+		}
+
+		want := BNODE_STAT_SUCC
+		if walks == 1 {
+			want = BNODE_STAT_FAIL
+		}
+
+		if n.GetState() != want {
+			t.Fatalf("run %d: state %s, want the picked child's %s", i, n.GetState(), want)
+		}
+	}
+
+	cases := []struct {
+		name     string
+		node     *ScriptedNode
+		min, max int
+	}{
+		{"weight 1", walk, runs/4 - runs/25, runs/4 + runs/25},
+		{"weight 3", idle, runs*3/4 - runs/25, runs*3/4 + runs/25},
+		{"weight 0", never, 0, 0},
+	}
+
+	for _, c := range cases {
+		if c.node.GetExecCount() < c.min || c.node.GetExecCount() > c.max {
+			t.Fatalf("%s: picked %d of %d, want [%d, %d]", c.name, c.node.GetExecCount(), runs, c.min, c.max)
+		}
+	}
+}
+
+func TestWeightedRandomNodeKeepsPick(t *testing.T) {
+	E, S := BNODE_STAT_EXECUTING, BNODE_STAT_SUCC
+	a := NewScriptedNode(2, E, E, S)
+	b := NewScriptedNode(3, E, E, S)
+	n := NewWeightedRandomNode(1)
+	n.AddWeightedChild(a, 1)
+	n.AddWeightedChild(b, 1)
+	n.SetRandSource(rand.New(rand.NewSource(11)))
+
+	ctx := NewBTreeContext(nil, 0)
+	for i, want := range []BNodeState{E, E, S} {
+		n.Execute(ctx)
+		if n.GetState() != want {
+			t.Fatalf("tick %d: state %s, want %s", i, n.GetState(), want)
+		}
+	}
+
+	if a.GetExecCount()*b.GetExecCount() != 0 || a.GetExecCount()+b.GetExecCount() != 3 {
+		t.Fatalf("children ran %d %d, want one child 3 times", a.GetExecCount(), b.GetExecCount())
+	}
+}
+
+func TestWeightedRandomNodeNoWeight(t *testing.T) {
+	cases := []struct {
+		name    string
+		weights []float64
+	}{
+		{"no child", nil},
+		{"zero weights", []float64{0, 0}},
+		{"negative weight", []float64{-1}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			n := NewWeightedRandomNode(1)
+			for i, w := range c.weights {
+				n.AddWeightedChild(NewScriptedNode(uint32(i+2), BNODE_STAT_SUCC), w)
+			}
+
+			n.Execute(NewBTreeContext(nil, 0))
+			if n.GetState() != BNODE_STAT_FAIL {
+				t.Fatalf("state %s, want fail", n.GetState())
+			}
+		})
+	}
+}
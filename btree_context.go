@@ -0,0 +1,186 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import "context"
+
+// BTreeContext carries the per-tick data of a behavior tree execution.
+// A nil context is valid, all getters return zero values.
+type BTreeContext struct {
+	tree       *BehaviorTree
+	fsm        *FSM
+	dt         int64
+	blackboard *Blackboard
+	cancelCtx  context.Context
+	skips      int
+	tickBudget int
+	leafCount  int
+	bTimeSet   bool
+	time       int64
+	treeTime   int64
+}
+
+// NewBTreeContext creates a context using the blackboard of fsm, the fsm
+// may be nil.
+func NewBTreeContext(fsm *FSM, dt int64) *BTreeContext {
+	c := &BTreeContext{
+		tree:       nil,
+		fsm:        fsm,
+		dt:         dt,
+		blackboard: nil,
+		cancelCtx:  nil,
+		skips:      0,
+		tickBudget: 0,
+		leafCount:  0,
+		bTimeSet:   false,
+		time:       0,
+		treeTime:   0,
+	}
+
+	if fsm != nil {
+		c.blackboard = fsm.GetBlackboard()
+	}
+
+	return c
+}
+
+// GetTree returns the tree being executed, it is set by BehaviorTree.Execute.
+func (c *BTreeContext) GetTree() *BehaviorTree {
+	if c == nil {
+		return nil
+	}
+
+	return c.tree
+}
+
+func (c *BTreeContext) GetFSM() *FSM {
+	if c == nil {
+		return nil
+	}
+
+	return c.fsm
+}
+
+func (c *BTreeContext) GetDt() int64 {
+	if c == nil {
+		return 0
+	}
+
+	return c.dt
+}
+
+// SetTime sets the time returned by GetTime.
+func (c *BTreeContext) SetTime(ms int64) {
+	c.bTimeSet = true
+	c.time = ms
+}
+
+// GetTime returns the shared clock of the nodes: the time set by SetTime,
+// else the total time of the fsm, else the sum of the dt given to the
+// executions of the outermost tree.
+func (c *BTreeContext) GetTime() int64 {
+	if c == nil {
+		return 0
+	}
+
+	if c.bTimeSet {
+		return c.time
+	}
+
+	if c.fsm != nil {
+		return c.fsm.GetTotalTime()
+	}
+
+	return c.treeTime
+}
+
+func (c *BTreeContext) SetBlackboard(bb *Blackboard) {
+	c.blackboard = bb
+}
+
+func (c *BTreeContext) GetBlackboard() *Blackboard {
+	if c == nil {
+		return nil
+	}
+
+	return c.blackboard
+}
+
+// SetContext sets the context checked before each node execution, the
+// execution stops once it is cancelled and the unexecuted nodes keep their
+// state. A nil ctx is never cancelled.
+func (c *BTreeContext) SetContext(ctx context.Context) {
+	c.cancelCtx = ctx
+}
+
+func (c *BTreeContext) GetContext() context.Context {
+	if c == nil {
+		return nil
+	}
+
+	return c.cancelCtx
+}
+
+func (c *BTreeContext) IsCancelled() bool {
+	if c == nil || c.cancelCtx == nil {
+		return false
+	}
+
+	return c.cancelCtx.Err() != nil
+}
+
+// onBNodeSkipped counts the nodes left unexecuted by a cancel or the tick
+// budget, a composite compares the count before and after executing a child
+// to know whether the child was evaluated fully.
+func (c *BTreeContext) onBNodeSkipped() {
+	if c != nil {
+		c.skips++
+	}
+}
+
+func (c *BTreeContext) getSkips() int {
+	if c == nil {
+		return 0
+	}
+
+	return c.skips
+}
+
+// onBNodeLeaf counts a leaf execution against the tick budget set by the
+// outermost tree.
+func (c *BTreeContext) onBNodeLeaf() {
+	if c != nil {
+		c.leafCount++
+	}
+}
+
+func (c *BTreeContext) isBudgetExhausted() bool {
+	return c != nil && c.tickBudget > 0 && c.leafCount >= c.tickBudget
+}
+
+func (c *BTreeContext) GetCurState() string {
+	if c == nil || c.fsm == nil {
+		return ""
+	}
+
+	return c.fsm.GetCurState()
+}
+
+func (c *BTreeContext) GetStateEntryCount() uint32 {
+	if c == nil || c.fsm == nil {
+		return 0
+	}
+
+	return c.fsm.GetStateEntryCount(c.fsm.GetCurState())
+}
+
+// GetFSMGeneration returns the generation of the fsm, see FSM.GetGeneration.
+func (c *BTreeContext) GetFSMGeneration() uint32 {
+	if c == nil || c.fsm == nil {
+		return 0
+	}
+
+	return c.fsm.GetGeneration()
+}
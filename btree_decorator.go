@@ -0,0 +1,510 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import "time"
+
+//========================
+//      TimeoutNode
+//========================
+// TimeoutNode fails and aborts its child if the child is still executing
+// after maxTicks executions. An execution left partial by a cancel or the
+// tick budget isn't counted.
+type TimeoutNode struct {
+	*DecoratorNode
+	maxTicks uint32
+	ticks    uint32
+}
+
+func NewTimeoutNode(nodeId uint32, maxTicks uint32) *TimeoutNode {
+	n := &TimeoutNode{
+		DecoratorNode: NewDecoratorNode(nodeId),
+		maxTicks:      maxTicks,
+		ticks:         0,
+	}
+
+	n.owner = n
+	return n
+}
+
+func (n *TimeoutNode) CloneNode() BehaviorNode {
+	c := &TimeoutNode{
+		DecoratorNode: n.cloneDecorator(),
+		maxTicks:      n.maxTicks,
+		ticks:         0,
+	}
+
+	c.owner = c
+	return c
+}
+
+func (n *TimeoutNode) Execute(ctx *BTreeContext) {
+	if n.IsCompleted() {
+		return
+	}
+
+	if n.child == nil {
+		n.state = BNODE_STAT_FAIL
+		return
+	}
+
+	if !executeBNodeFully(ctx, n.child) {
+		n.state = BNODE_STAT_EXECUTING
+		return
+	}
+
+	if n.child.IsCompleted() {
+		n.state = n.child.GetState()
+		return
+	}
+
+	n.ticks++
+	if n.ticks >= n.maxTicks {
+		abortBNode(n.child)
+		n.state = BNODE_STAT_FAIL
+		return
+	}
+
+	n.state = BNODE_STAT_EXECUTING
+}
+
+func (n *TimeoutNode) Reset() {
+	n.DecoratorNode.Reset()
+	n.ticks = 0
+}
+
+//========================
+//    WallTimeoutNode
+//========================
+// WallTimeoutNode fails and aborts its child if the child is still executing
+// when d has passed since the first execution, measured by the clock.
+type WallTimeoutNode struct {
+	*DecoratorNode
+	d         time.Duration
+	clock     func() time.Time
+	startTime time.Time
+	bStarted  bool
+}
+
+func NewWallTimeoutNode(nodeId uint32, d time.Duration) *WallTimeoutNode {
+	n := &WallTimeoutNode{
+		DecoratorNode: NewDecoratorNode(nodeId),
+		d:             d,
+		clock:         time.Now,
+		startTime:     time.Time{},
+		bStarted:      false,
+	}
+
+	n.owner = n
+	return n
+}
+
+// SetClock sets the func returning the current time, nil uses time.Now.
+func (n *WallTimeoutNode) SetClock(clock func() time.Time) {
+	if clock == nil {
+		clock = time.Now
+	}
+
+	n.clock = clock
+}
+
+// CloneNode shares the clock with the clone.
+func (n *WallTimeoutNode) CloneNode() BehaviorNode {
+	c := &WallTimeoutNode{
+		DecoratorNode: n.cloneDecorator(),
+		d:             n.d,
+		clock:         n.clock,
+		startTime:     time.Time{},
+		bStarted:      false,
+	}
+
+	c.owner = c
+	return c
+}
+
+func (n *WallTimeoutNode) Execute(ctx *BTreeContext) {
+	if n.IsCompleted() {
+		return
+	}
+
+	if n.child == nil {
+		n.state = BNODE_STAT_FAIL
+		return
+	}
+
+	if !n.bStarted {
+		n.startTime = n.clock()
+		n.bStarted = true
+	}
+
+	executeBNode(ctx, n.child)
+	if n.child.IsCompleted() {
+		n.state = n.child.GetState()
+		return
+	}
+
+	if n.clock().Sub(n.startTime) >= n.d {
+		abortBNode(n.child)
+		n.state = BNODE_STAT_FAIL
+		return
+	}
+
+	n.state = BNODE_STAT_EXECUTING
+}
+
+func (n *WallTimeoutNode) Reset() {
+	n.DecoratorNode.Reset()
+	n.startTime = time.Time{}
+	n.bStarted = false
+}
+
+//========================
+//      CooldownNode
+//========================
+// CooldownNode fails without executing its child for cooldownMs after the
+// child succeeded. The time is taken from BTreeContext.GetTime, so the
+// cooldown runs out even while the node isn't executed. Reset doesn't stop a
+// running cooldown.
+type CooldownNode struct {
+	*DecoratorNode
+	cooldownMs int64
+	lastUse    int64
+	bCooling   bool
+}
+
+func NewCooldownNode(nodeId uint32, cooldownMs int64) *CooldownNode {
+	n := &CooldownNode{
+		DecoratorNode: NewDecoratorNode(nodeId),
+		cooldownMs:    cooldownMs,
+		lastUse:       0,
+		bCooling:      false,
+	}
+
+	n.owner = n
+	return n
+}
+
+func (n *CooldownNode) CloneNode() BehaviorNode {
+	c := &CooldownNode{
+		DecoratorNode: n.cloneDecorator(),
+		cooldownMs:    n.cooldownMs,
+		lastUse:       0,
+		bCooling:      false,
+	}
+
+	c.owner = c
+	return c
+}
+
+func (n *CooldownNode) Execute(ctx *BTreeContext) {
+	if n.IsCompleted() {
+		return
+	}
+
+	if n.child == nil {
+		n.state = BNODE_STAT_FAIL
+		return
+	}
+
+	now := ctx.GetTime()
+	if n.bCooling {
+		if now-n.lastUse < n.cooldownMs {
+			n.state = BNODE_STAT_FAIL
+			return
+		}
+
+		n.bCooling = false
+		n.child.Reset()
+	}
+
+	executeBNode(ctx, n.child)
+	n.state = n.child.GetState()
+	if n.state == BNODE_STAT_SUCC {
+		n.bCooling = true
+		n.lastUse = now
+	}
+}
+
+func (n *CooldownNode) IsCooling() bool {
+	return n.bCooling
+}
+
+//========================
+//       GuardNode
+//========================
+// GuardNode evaluates its condition every execution, it fails without
+// executing the child if the condition is false and aborts the child if it
+// was executing. Otherwise it executes the child and mirrors its state.
+type GuardNode struct {
+	*DecoratorNode
+	cond   ConditionFunc
+	params []interface{}
+}
+
+func NewGuardNode(nodeId uint32, cond ConditionFunc, param ...interface{}) *GuardNode {
+	n := &GuardNode{
+		DecoratorNode: NewDecoratorNode(nodeId),
+		cond:          cond,
+		params:        param,
+	}
+
+	n.owner = n
+	return n
+}
+
+func (n *GuardNode) CloneNode() BehaviorNode {
+	c := &GuardNode{
+		DecoratorNode: n.cloneDecorator(),
+		cond:          n.cond,
+		params:        n.params,
+	}
+
+	c.owner = c
+	return c
+}
+
+func (n *GuardNode) Execute(ctx *BTreeContext) {
+	if n.IsCompleted() {
+		return
+	}
+
+	if n.child == nil || n.cond == nil || !n.cond(n.params...) {
+		if n.child != nil && n.child.GetState() == BNODE_STAT_EXECUTING {
+			abortBNode(n.child)
+		}
+
+		n.state = BNODE_STAT_FAIL
+		return
+	}
+
+	executeBNode(ctx, n.child)
+	n.state = n.child.GetState()
+}
+
+//========================
+//       LimitNode
+//========================
+// LimitNode lets its child run to completion at most maxRuns times, then it
+// fails without executing the child. Reset keeps the run count, ResetLimit
+// clears it.
+type LimitNode struct {
+	*DecoratorNode
+	maxRuns uint32
+	runs    uint32
+}
+
+func NewLimitNode(nodeId uint32, maxRuns uint32) *LimitNode {
+	n := &LimitNode{
+		DecoratorNode: NewDecoratorNode(nodeId),
+		maxRuns:       maxRuns,
+		runs:          0,
+	}
+
+	n.owner = n
+	return n
+}
+
+func (n *LimitNode) CloneNode() BehaviorNode {
+	c := &LimitNode{
+		DecoratorNode: n.cloneDecorator(),
+		maxRuns:       n.maxRuns,
+		runs:          0,
+	}
+
+	c.owner = c
+	return c
+}
+
+func (n *LimitNode) Execute(ctx *BTreeContext) {
+	if n.IsCompleted() {
+		return
+	}
+
+	if n.child == nil || n.runs >= n.maxRuns {
+		n.state = BNODE_STAT_FAIL
+		return
+	}
+
+	executeBNode(ctx, n.child)
+	n.state = n.child.GetState()
+	if n.child.IsCompleted() {
+		n.runs++
+	}
+}
+
+func (n *LimitNode) GetRunCount() uint32 {
+	return n.runs
+}
+
+func (n *LimitNode) ResetLimit() {
+	n.runs = 0
+}
+
+//========================
+//       DelayNode
+//========================
+// DelayNode is executing until the dt of its executions sums up to delayMs,
+// then it executes its child and mirrors its state.
+type DelayNode struct {
+	*DecoratorNode
+	delayMs int64
+	elapsed int64
+}
+
+func NewDelayNode(nodeId uint32, delayMs int64) *DelayNode {
+	n := &DelayNode{
+		DecoratorNode: NewDecoratorNode(nodeId),
+		delayMs:       delayMs,
+		elapsed:       0,
+	}
+
+	n.owner = n
+	return n
+}
+
+func (n *DelayNode) CloneNode() BehaviorNode {
+	c := &DelayNode{
+		DecoratorNode: n.cloneDecorator(),
+		delayMs:       n.delayMs,
+		elapsed:       0,
+	}
+
+	c.owner = c
+	return c
+}
+
+func (n *DelayNode) Execute(ctx *BTreeContext) {
+	if n.IsCompleted() {
+		return
+	}
+
+	if n.child == nil {
+		n.state = BNODE_STAT_FAIL
+		return
+	}
+
+	if n.elapsed < n.delayMs {
+		n.elapsed += ctx.GetDt()
+		if n.elapsed < n.delayMs {
+			n.state = BNODE_STAT_EXECUTING
+			return
+		}
+	}
+
+	executeBNode(ctx, n.child)
+	n.state = n.child.GetState()
+}
+
+func (n *DelayNode) Reset() {
+	n.DecoratorNode.Reset()
+	n.elapsed = 0
+}
+
+//========================
+//     ForceStateNode
+//========================
+// ForceStateNode never executes its child, it always takes the forced
+// state. Forced to BNODE_STAT_EXECUTING it never completes.
+type ForceStateNode struct {
+	*DecoratorNode
+	forced BNodeState
+}
+
+func NewForceStateNode(nodeId uint32, forced BNodeState) *ForceStateNode {
+	n := &ForceStateNode{
+		DecoratorNode: NewDecoratorNode(nodeId),
+		forced:        forced,
+	}
+
+	n.owner = n
+	return n
+}
+
+// NewAlwaysRunningNode creates a ForceStateNode forced to executing.
+func NewAlwaysRunningNode(nodeId uint32) *ForceStateNode {
+	return NewForceStateNode(nodeId, BNODE_STAT_EXECUTING)
+}
+
+func (n *ForceStateNode) CloneNode() BehaviorNode {
+	c := &ForceStateNode{
+		DecoratorNode: n.cloneDecorator(),
+		forced:        n.forced,
+	}
+
+	c.owner = c
+	return c
+}
+
+func (n *ForceStateNode) Execute(ctx *BTreeContext) {
+	if n.IsCompleted() {
+		return
+	}
+
+	n.state = n.forced
+}
+
+//========================
+//   RepeatUntilBBNode
+//========================
+// RepeatUntilBBNode runs its child again and again, one run at most per
+// execution, until the blackboard value of bbKey equals target, then it
+// succeeds. The value is checked before each run, the executing child isn't
+// interrupted. It fails without a blackboard.
+type RepeatUntilBBNode struct {
+	*DecoratorNode
+	bbKey  string
+	target interface{}
+}
+
+func NewRepeatUntilBBNode(nodeId uint32, bbKey string, target interface{}) *RepeatUntilBBNode {
+	n := &RepeatUntilBBNode{
+		DecoratorNode: NewDecoratorNode(nodeId),
+		bbKey:         bbKey,
+		target:        target,
+	}
+
+	n.owner = n
+	return n
+}
+
+func (n *RepeatUntilBBNode) CloneNode() BehaviorNode {
+	c := &RepeatUntilBBNode{
+		DecoratorNode: n.cloneDecorator(),
+		bbKey:         n.bbKey,
+		target:        n.target,
+	}
+
+	c.owner = c
+	return c
+}
+
+func (n *RepeatUntilBBNode) Execute(ctx *BTreeContext) {
+	if n.IsCompleted() {
+		return
+	}
+
+	bb := ctx.GetBlackboard()
+	if n.child == nil || bb == nil {
+		n.state = BNODE_STAT_FAIL
+		return
+	}
+
+	if n.child.GetState() != BNODE_STAT_EXECUTING {
+		if bb.Compare(n.bbKey, BB_OP_EQ, n.target) {
+			n.state = BNODE_STAT_SUCC
+			return
+		}
+
+		n.child.Reset()
+	}
+
+	executeBNode(ctx, n.child)
+	if n.child.IsCompleted() && bb.Compare(n.bbKey, BB_OP_EQ, n.target) {
+		n.state = BNODE_STAT_SUCC
+		return
+	}
+
+	n.state = BNODE_STAT_EXECUTING
+}
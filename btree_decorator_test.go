@@ -0,0 +1,500 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCooldownNodeSharedClock(t *testing.T) {
+	const dt = 40
+	cases := []struct {
+		name   string
+		newCtx func(t *testing.T) (*BTreeContext, func(tick int))
+	}{
+		{"tree clock", func(t *testing.T) (*BTreeContext, func(tick int)) {
+			return NewBTreeContext(nil, dt), func(tick int) {}
+		}},
+		{"fsm time", func(t *testing.T) (*BTreeContext, func(tick int)) {
+			f := newTestFSM(t, nil, "a")
+			f.Start("a")
+			return NewBTreeContext(f, dt), func(tick int) { f.Update(dt) }
+		}},
+		{"set time", func(t *testing.T) (*BTreeContext, func(tick int)) {
+			ctx := NewBTreeContext(nil, 0)
+			return ctx, func(tick int) { ctx.SetTime(int64(tick * dt)) }
+		}},
+	}
+
+	// the cooldown starts at tick 1, its branch is skipped at ticks 3 and 4,
+	// it runs out by tick 5 though it was executed once since
+	ticks := []struct {
+		bTick bool
+		want  BNodeState
+	}{
+		{true, BNODE_STAT_SUCC},
+		{true, BNODE_STAT_FAIL},
+		{false, BNODE_STAT_FAIL},
+		{false, BNODE_STAT_FAIL},
+		{true, BNODE_STAT_SUCC},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			bTick := false
+			cooldown := NewCooldownNode(3, 100)
+			cooldown.AddChild(NewScriptedNode(4, BNODE_STAT_SUCC))
+			guard := NewGuardNode(2, func(param ...interface{}) bool { return bTick })
+			guard.AddChild(cooldown)
+			tree := newTestTree(guard)
+
+			ctx, advance := c.newCtx(t)
+			for i, tick := range ticks {
+				advance(i + 1)
+				bTick = tick.bTick
+				tree.Reset()
+				tree.Execute(ctx)
+				if tree.GetState() != tick.want {
+					t.Fatalf("tick %d: state = %v, want %v", i+1, tree.GetState(), tick.want)
+				}
+			}
+		})
+	}
+}
+
+func TestTimeoutNode(t *testing.T) {
+	E, S := BNODE_STAT_EXECUTING, BNODE_STAT_SUCC
+	cases := []struct {
+		name    string
+		results []BNodeState
+		want    []BNodeState
+		aborts  int
+	}{
+		{"trips at max ticks", []BNodeState{E}, []BNodeState{E, E, BNODE_STAT_FAIL}, 1},
+		{"child succeeds in time", []BNodeState{E, E, S}, []BNodeState{E, E, S}, 0},
+		{"child fails", []BNodeState{BNODE_STAT_FAIL}, []BNodeState{BNODE_STAT_FAIL}, 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			child := newTestAbortNode(2, c.results...)
+			n := NewTimeoutNode(1, 3)
+			n.AddChild(child)
+
+			ctx := NewBTreeContext(nil, 0)
+			for i, want := range c.want {
+				n.Execute(ctx)
+				if n.GetState() != want {
+					t.Fatalf("tick %d: state %d, want %d", i, n.GetState(), want)
+				}
+			}
+
+			if child.aborts != c.aborts {
+				t.Fatalf("child aborted %d times, want %d", child.aborts, c.aborts)
+			}
+		})
+	}
+}
+
+func TestTimeoutNodeSkippedChild(t *testing.T) {
+	cctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cancelled := NewBTreeContext(nil, 0)
+	cancelled.SetContext(cctx)
+
+	child := NewScriptedNode(2, BNODE_STAT_EXECUTING)
+	n := NewTimeoutNode(1, 2)
+	n.AddChild(child)
+	n.Execute(NewBTreeContext(nil, 0))
+	for i := 0; i < 3; i++ {
+		n.Execute(cancelled)
+		if n.GetState() != BNODE_STAT_EXECUTING {
+			t.Fatalf("cancelled tick %d: state %d, want executing", i, n.GetState())
+		}
+	}
+
+	n.Execute(NewBTreeContext(nil, 0))
+	if n.GetState() != BNODE_STAT_FAIL || child.GetExecCount() != 2 {
+		t.Fatalf("state %d child runs %d, want fail 2", n.GetState(), child.GetExecCount())
+	}
+}
+
+func TestTimeoutNodeReset(t *testing.T) {
+	n := NewTimeoutNode(1, 2)
+	n.AddChild(NewScriptedNode(2, BNODE_STAT_EXECUTING))
+	ctx := NewBTreeContext(nil, 0)
+	n.Execute(ctx)
+	n.Reset()
+
+	n.Execute(ctx)
+	if n.GetState() != BNODE_STAT_EXECUTING {
+		t.Fatalf("state %d after Reset, want executing", n.GetState())
+	}
+
+	n.Execute(ctx)
+	if n.GetState() != BNODE_STAT_FAIL {
+		t.Fatalf("state %d, want fail", n.GetState())
+	}
+}
+
+func TestCooldownNode(t *testing.T) {
+	cases := []struct {
+		name   string
+		time   int64
+		want   BNodeState
+		execed int
+	}{
+		{"first run", 0, BNODE_STAT_SUCC, 1},
+		{"immediately again", 0, BNODE_STAT_FAIL, 1},
+		{"inside window", 99, BNODE_STAT_FAIL, 1},
+		{"window elapsed", 100, BNODE_STAT_SUCC, 2},
+		{"cooling again", 150, BNODE_STAT_FAIL, 2},
+	}
+
+	child := NewScriptedNode(2, BNODE_STAT_SUCC)
+	n := NewCooldownNode(1, 100)
+	n.AddChild(child)
+	ctx := NewBTreeContext(nil, 0)
+	for _, c := range cases {
+		ctx.SetTime(c.time)
+		n.Reset()
+		n.Execute(ctx)
+		if n.GetState() != c.want || child.GetExecCount() != c.execed {
+			t.Fatalf("%s: state %d child runs %d, want %d %d", c.name, n.GetState(), child.GetExecCount(), c.want, c.execed)
+		}
+	}
+}
+
+func TestGuardNode(t *testing.T) {
+	E, F := BNODE_STAT_EXECUTING, BNODE_STAT_FAIL
+	cases := []struct {
+		name   string
+		conds  []bool
+		want   []BNodeState
+		execs  int
+		aborts int
+	}{
+		{"condition holds", []bool{true, true}, []BNodeState{E, E}, 2, 0},
+		{"false from start", []bool{false}, []BNodeState{F}, 0, 0},
+		{"false mid execution", []bool{true, false}, []BNodeState{E, F}, 1, 1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			bCond := false
+			child := newTestAbortNode(2, BNODE_STAT_EXECUTING)
+			n := NewGuardNode(1, func(param ...interface{}) bool { return bCond })
+			n.AddChild(child)
+
+			ctx := NewBTreeContext(nil, 0)
+			for i := range c.conds {
+				bCond = c.conds[i]
+				n.Execute(ctx)
+				if n.GetState() != c.want[i] {
+					t.Fatalf("tick %d: state %d, want %d", i, n.GetState(), c.want[i])
+				}
+			}
+
+			if child.GetExecCount() != c.execs || child.aborts != c.aborts {
+				t.Fatalf("child executions %d aborts %d, want %d %d", child.GetExecCount(), child.aborts, c.execs, c.aborts)
+			}
+
+			if c.aborts > 0 && child.GetState() != BNODE_STAT_NOT_EXECUTE {
+				t.Fatalf("aborted child not reset, state %d", child.GetState())
+			}
+		})
+	}
+}
+
+func TestLimitNode(t *testing.T) {
+	S, F := BNODE_STAT_SUCC, BNODE_STAT_FAIL
+	cases := []struct {
+		name    string
+		maxRuns uint32
+		child   BNodeState
+		want    []BNodeState
+		execs   int
+	}{
+		{"one shot", 1, S, []BNodeState{S, F, F}, 1},
+		{"two runs", 2, S, []BNodeState{S, S, F}, 2},
+		{"failures count", 2, F, []BNodeState{F, F, F}, 2},
+		{"zero runs", 0, S, []BNodeState{F}, 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			child := NewScriptedNode(2, c.child)
+			n := NewLimitNode(1, c.maxRuns)
+			n.AddChild(child)
+
+			ctx := NewBTreeContext(nil, 0)
+			for i, want := range c.want {
+				n.Reset()
+				n.Execute(ctx)
+				if n.GetState() != want {
+					t.Fatalf("run %d: state %d, want %d", i, n.GetState(), want)
+				}
+			}
+
+			if child.GetExecCount() != c.execs {
+				t.Fatalf("child executed %d times, want %d", child.GetExecCount(), c.execs)
+			}
+		})
+	}
+}
+
+func TestLimitNodeResetLimit(t *testing.T) {
+	n := NewLimitNode(1, 1)
+	n.AddChild(NewScriptedNode(2, BNODE_STAT_EXECUTING, BNODE_STAT_SUCC))
+	ctx := NewBTreeContext(nil, 0)
+	n.Execute(ctx)
+	if n.GetRunCount() != 0 {
+		t.Fatalf("unfinished run counted")
+	}
+
+	n.Execute(ctx)
+	n.Reset()
+	n.Execute(ctx)
+	if n.GetState() != BNODE_STAT_FAIL || n.GetRunCount() != 1 {
+		t.Fatalf("state %d runs %d, want fail 1", n.GetState(), n.GetRunCount())
+	}
+
+	n.ResetLimit()
+	n.Reset()
+	n.Execute(ctx)
+	if n.GetState() != BNODE_STAT_SUCC {
+		t.Fatalf("state %d after ResetLimit, want succ", n.GetState())
+	}
+}
+
+func TestDelayNode(t *testing.T) {
+	E, S := BNODE_STAT_EXECUTING, BNODE_STAT_SUCC
+	cases := []struct {
+		name    string
+		results []BNodeState
+		dts     []int64
+		want    []BNodeState
+		execs   int
+	}{
+		{"before delay", []BNodeState{S}, []int64{40, 40}, []BNodeState{E, E}, 0},
+		{"delay reached", []BNodeState{S}, []int64{40, 60}, []BNodeState{E, S}, 1},
+		{"one long tick", []BNodeState{S}, []int64{150}, []BNodeState{S}, 1},
+		{"child runs after", []BNodeState{E, E, S}, []int64{100, 10, 10}, []BNodeState{E, E, S}, 3},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			child := NewScriptedNode(2, c.results...)
+			n := NewDelayNode(1, 100)
+			n.AddChild(child)
+			for i, dt := range c.dts {
+				n.Execute(NewBTreeContext(nil, dt))
+				if n.GetState() != c.want[i] {
+					t.Fatalf("tick %d: state %d, want %d", i, n.GetState(), c.want[i])
+				}
+			}
+
+			if child.GetExecCount() != c.execs {
+				t.Fatalf("child executed %d times, want %d", child.GetExecCount(), c.execs)
+			}
+		})
+	}
+}
+
+func TestDelayNodeReset(t *testing.T) {
+	child := NewScriptedNode(2, BNODE_STAT_SUCC)
+	n := NewDelayNode(1, 100)
+	n.AddChild(child)
+	n.Execute(NewBTreeContext(nil, 100))
+	n.Reset()
+
+	n.Execute(NewBTreeContext(nil, 50))
+	if n.GetState() != BNODE_STAT_EXECUTING || child.GetExecCount() != 1 {
+		t.Fatalf("state %d child runs %d after Reset, want executing 1", n.GetState(), child.GetExecCount())
+	}
+}
+
+func TestForceStateNode(t *testing.T) {
+	cases := []struct {
+		name string
+		node *ForceStateNode
+		want BNodeState
+	}{
+		{"success", NewForceStateNode(1, BNODE_STAT_SUCC), BNODE_STAT_SUCC},
+		{"failure", NewForceStateNode(1, BNODE_STAT_FAIL), BNODE_STAT_FAIL},
+		{"executing", NewForceStateNode(1, BNODE_STAT_EXECUTING), BNODE_STAT_EXECUTING},
+		{"always running", NewAlwaysRunningNode(1), BNODE_STAT_EXECUTING},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			child := NewScriptedNode(2, BNODE_STAT_SUCC)
+			c.node.AddChild(child)
+			ctx := NewBTreeContext(nil, 0)
+			for i := 0; i < 3; i++ {
+				c.node.Execute(ctx)
+				if c.node.GetState() != c.want {
+					t.Fatalf("tick %d: state %d, want %d", i, c.node.GetState(), c.want)
+				}
+			}
+
+			if child.GetExecCount() != 0 {
+				t.Fatalf("child executed %d times", child.GetExecCount())
+			}
+		})
+	}
+}
+
+func TestForceStateNodeWithoutChild(t *testing.T) {
+	tree := newTestTree(NewAlwaysRunningNode(2))
+	ctx := NewBTreeContext(nil, 0)
+	for i := 0; i < 5; i++ {
+		tree.Execute(ctx)
+	}
+
+	if tree.IsCompleted() {
+		t.Fatalf("tree pinned by an always running node completed")
+	}
+}
+
+func TestWallTimeoutNode(t *testing.T) {
+	E, S := BNODE_STAT_EXECUTING, BNODE_STAT_SUCC
+	ms := time.Millisecond
+	cases := []struct {
+		name    string
+		results []BNodeState
+		steps   []time.Duration
+		want    []BNodeState
+		aborts  int
+	}{
+		{"trips when elapsed", []BNodeState{E}, []time.Duration{0, 50 * ms, 50 * ms}, []BNodeState{E, E, BNODE_STAT_FAIL}, 1},
+		{"one long tick", []BNodeState{E}, []time.Duration{0, time.Second}, []BNodeState{E, BNODE_STAT_FAIL}, 1},
+		{"child succeeds in time", []BNodeState{E, S}, []time.Duration{0, 99 * ms}, []BNodeState{E, S}, 0},
+		{"child succeeds late", []BNodeState{E, S}, []time.Duration{0, time.Second}, []BNodeState{E, S}, 0},
+		{"ticks don't count", []BNodeState{E}, []time.Duration{0, 0, 0, 0, 0}, []BNodeState{E, E, E, E, E}, 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			now := time.Unix(1000, 0)
+			child := newTestAbortNode(2, c.results...)
+			n := NewWallTimeoutNode(1, 100*ms)
+			n.SetClock(func() time.Time { return now })
+			n.AddChild(child)
+
+			ctx := NewBTreeContext(nil, 0)
+			for i, step := range c.steps {
+				now = now.Add(step)
+				n.Execute(ctx)
+				if n.GetState() != c.want[i] {
+					t.Fatalf("tick %d: state %d, want %d", i, n.GetState(), c.want[i])
+				}
+			}
+
+			if child.aborts != c.aborts {
+				t.Fatalf("child aborted %d times, want %d", child.aborts, c.aborts)
+			}
+		})
+	}
+}
+
+func TestWallTimeoutNodeReset(t *testing.T) {
+	now := time.Unix(1000, 0)
+	n := NewWallTimeoutNode(1, 100*time.Millisecond)
+	n.SetClock(func() time.Time { return now })
+	n.AddChild(NewScriptedNode(2, BNODE_STAT_EXECUTING))
+	ctx := NewBTreeContext(nil, 0)
+	n.Execute(ctx)
+	now = now.Add(90 * time.Millisecond)
+	n.Reset()
+
+	n.Execute(ctx)
+	now = now.Add(90 * time.Millisecond)
+	n.Execute(ctx)
+	if n.GetState() != BNODE_STAT_EXECUTING {
+		t.Fatalf("state %d, want executing after Reset restarted the clock", n.GetState())
+	}
+
+	now = now.Add(10 * time.Millisecond)
+	n.Execute(ctx)
+	if n.GetState() != BNODE_STAT_FAIL {
+		t.Fatalf("state %d, want fail", n.GetState())
+	}
+
+	c := n.CloneNode().(*WallTimeoutNode)
+	c.AddChild(NewScriptedNode(3, BNODE_STAT_EXECUTING))
+	c.Execute(ctx)
+	now = now.Add(100 * time.Millisecond)
+	c.Execute(ctx)
+	if c.GetState() != BNODE_STAT_FAIL {
+		t.Fatalf("clone state %d, want fail on the shared clock", c.GetState())
+	}
+}
+
+func TestRepeatUntilBBNode(t *testing.T) {
+	E, S, F := BNODE_STAT_EXECUTING, BNODE_STAT_SUCC, BNODE_STAT_FAIL
+	cases := []struct {
+		name     string
+		arriveAt int
+		steps    []BNodeState
+		want     []BNodeState
+		runs     int
+	}{
+		{"arrives on third run", 3, []BNodeState{S}, []BNodeState{E, E, S}, 3},
+		{"already there", 0, []BNodeState{S}, []BNodeState{S}, 0},
+		{"executing child kept", 2, []BNodeState{E, S}, []BNodeState{E, E, E, S}, 2},
+		{"failures repeat too", 2, []BNodeState{F}, []BNodeState{E, S}, 2},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			bb := NewBlackboard()
+			bb.Set("at_destination", false)
+			if c.arriveAt == 0 {
+				bb.Set("at_destination", true)
+			}
+
+			runs, step := 0, 0
+			move := newTestFuncNode(2, func() BNodeState {
+				stat := c.steps[step%len(c.steps)]
+				step++
+				if stat != E {
+					runs++
+					if runs == c.arriveAt {
+						bb.Set("at_destination", true)
+					}
+				}
+
+				return stat
+			})
+			n := NewRepeatUntilBBNode(1, "at_destination", true)
+			n.AddChild(move)
+
+			ctx := NewBTreeContext(nil, 0)
+			ctx.SetBlackboard(bb)
+			for i, want := range c.want {
+				n.Execute(ctx)
+				if n.GetState() != want {
+					t.Fatalf("tick %d: state %s, want %s", i, n.GetState(), want)
+				}
+			}
+
+			if runs != c.runs {
+				t.Fatalf("child ran %d times, want %d", runs, c.runs)
+			}
+		})
+	}
+}
+
+func TestRepeatUntilBBNodeNoBlackboard(t *testing.T) {
+	n := NewRepeatUntilBBNode(1, "at_destination", true)
+	n.AddChild(NewScriptedNode(2, BNODE_STAT_SUCC))
+	n.Execute(NewBTreeContext(nil, 0))
+	if n.GetState() != BNODE_STAT_FAIL {
+		t.Fatalf("state %s without blackboard, want fail", n.GetState())
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import "encoding/json"
+
+type BTreeNodeDef struct {
+	ID       uint32          `json:"id"`
+	Type     string          `json:"type"`
+	ActionID uint32          `json:"actionId"`
+	MaxStep  uint32          `json:"maxStep"`
+	Children []*BTreeNodeDef `json:"children"`
+}
+
+type BTreeDef struct {
+	ID   uint32        `json:"id"`
+	Root *BTreeNodeDef `json:"root"`
+}
+
+type BNodeFactory func(def BTreeNodeDef) BehaviorNode
+
+// LoadBehaviorTreeFromJSON builds a behavior tree from a JSON encoded
+// BTreeDef. SEQUENCE, SELECT and PARALLEL nodes are built by the loader,
+// any other node type is built by nodeFactory.
+func LoadBehaviorTreeFromJSON(data []byte, nodeFactory BNodeFactory) (*BehaviorTree, error) {
+	def := &BTreeDef{}
+	err := json.Unmarshal(data, def)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewBehaviorTreeFromDef(def, nodeFactory)
+}
+
+func NewBehaviorTreeFromDef(def *BTreeDef, nodeFactory BNodeFactory) (*BehaviorTree, error) {
+	if def.Root == nil || def.Root.ID != BTREE_ROOT_NODE_ID {
+		return nil, ErrBTreeNoRoot
+	}
+
+	mapId2Exist := make(map[uint32]bool)
+	root, err := newBNodeFromDef(def.Root, nodeFactory, mapId2Exist)
+	if err != nil {
+		return nil, err
+	}
+
+	t := NewBehaviorTree(def.ID)
+	t.rootNode = root
+	return t, nil
+}
+
+func newBNodeFromDef(def *BTreeNodeDef, nodeFactory BNodeFactory, mapId2Exist map[uint32]bool) (BehaviorNode, error) {
+	if mapId2Exist[def.ID] {
+		return nil, ErrBNodeIDDuplicate
+	}
+
+	mapId2Exist[def.ID] = true
+
+	var node BehaviorNode = nil
+	switch def.Type {
+	case BNODE_TYPE_SEQUENCE.String():
+		node = NewSequenceNode(def.ID)
+	case BNODE_TYPE_SELECT.String():
+		node = NewSelectNode(def.ID)
+	case BNODE_TYPE_PARALLEL.String():
+		node = NewParallelNode(def.ID)
+	default:
+		if nodeFactory != nil {
+			node = nodeFactory(*def)
+		}
+	}
+
+	if node == nil {
+		return nil, ErrBNodeNil
+	}
+
+	for _, childDef := range def.Children {
+		child, err := newBNodeFromDef(childDef, nodeFactory, mapId2Exist)
+		if err != nil {
+			return nil, err
+		}
+
+		node.AddChild(child)
+	}
+
+	return node, nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import "testing"
+
+func newTestBNodeFactory() BNodeFactory {
+	return func(def BTreeNodeDef) BehaviorNode {
+		if def.Type != "ACTION" {
+			return nil
+		}
+
+		return NewBaseBehaviorNode(def.ID, def.ActionID, def.MaxStep)
+	}
+}
+
+func TestLoadBehaviorTreeFromJSON(t *testing.T) {
+	data := []byte(`{
+		"id": 7,
+		"root": {"id": 1, "type": "SEQUENCE", "children": [
+			{"id": 2, "type": "SELECT", "children": [
+				{"id": 3, "type": "ACTION", "actionId": 30, "maxStep": 2},
+				{"id": 4, "type": "ACTION", "actionId": 40}
+			]},
+			{"id": 5, "type": "PARALLEL"}
+		]}
+	}`)
+
+	tree, err := LoadBehaviorTreeFromJSON(data, newTestBNodeFactory())
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	if tree.GetID() != 7 {
+		t.Fatalf("tree id %d, want 7", tree.GetID())
+	}
+
+	root := tree.GetRootNode()
+	sel, ok := root.GetChildByID(2)
+	if !ok || sel.GetType() != BNODE_TYPE_SELECT {
+		t.Fatalf("select 2 not under root")
+	}
+
+	parallel, ok := root.GetChildByID(5)
+	if !ok || parallel.GetType() != BNODE_TYPE_PARALLEL {
+		t.Fatalf("parallel 5 not under root")
+	}
+
+	action, ok := sel.GetChildByID(3)
+	if !ok || action.GetActionID() != 30 || action.GetMaxStep() != 2 {
+		t.Fatalf("action 3 not loaded under select 2")
+	}
+
+	_, ok = sel.GetChildByID(4)
+	if !ok {
+		t.Fatalf("action 4 not loaded under select 2")
+	}
+
+	_, ok = root.GetChildByID(3)
+	if ok {
+		t.Fatalf("action 3 loaded under root")
+	}
+}
+
+func TestLoadBehaviorTreeFromJSONInvalid(t *testing.T) {
+	cases := []struct {
+		name    string
+		data    string
+		wantErr error
+	}{
+		{"no root", `{"id": 1}`, ErrBTreeNoRoot},
+		{"root id", `{"root": {"id": 2, "type": "SEQUENCE"}}`, ErrBTreeNoRoot},
+		{"duplicate id", `{"root": {"id": 1, "type": "SEQUENCE", "children": [{"id": 2, "type": "ACTION"}, {"id": 2, "type": "ACTION"}]}}`, ErrBNodeIDDuplicate},
+		{"unknown type", `{"root": {"id": 1, "type": "SEQUENCE", "children": [{"id": 2, "type": "FOO"}]}}`, ErrBNodeNil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := LoadBehaviorTreeFromJSON([]byte(c.data), newTestBNodeFactory())
+			if err != c.wantErr {
+				t.Fatalf("load = %v, want %v", err, c.wantErr)
+			}
+		})
+	}
+}
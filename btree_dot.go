@@ -0,0 +1,61 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import (
+	"fmt"
+	"strings"
+)
+
+func getBNodeStateColor(stat BNodeState) string {
+	switch stat {
+	case BNODE_STAT_EXECUTING:
+		return "yellow"
+	case BNODE_STAT_SUCC:
+		return "green"
+	case BNODE_STAT_FAIL:
+		return "red"
+	}
+
+	return "white"
+}
+
+// ExportDOT renders the behavior tree as a Graphviz digraph, nodes are
+// colored by their current state.
+func ExportDOT(t *BehaviorTree) string {
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "digraph btree_%d {\n", t.GetID())
+	sb.WriteString("\tnode [shape=box, style=filled];\n")
+
+	visited := make(map[BehaviorNode]bool)
+	exportBNodeDOT(sb, t.GetRootNode(), visited)
+
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+func exportBNodeDOT(sb *strings.Builder, node BehaviorNode, visited map[BehaviorNode]bool) {
+	if node == nil || visited[node] {
+		return
+	}
+
+	visited[node] = true
+
+	label := fmt.Sprintf("%d %s", node.GetID(), node.GetType().String())
+	if node.GetType() == BNODE_TYPE_ACTION {
+		label += fmt.Sprintf("\\naction %d", node.GetActionID())
+	}
+
+	fmt.Fprintf(sb, "\tn%d [label=\"%s\", fillcolor=%s];\n", node.GetID(), label, getBNodeStateColor(node.GetState()))
+
+	children := node.Children()
+	for _, child := range children {
+		fmt.Fprintf(sb, "\tn%d -> n%d;\n", node.GetID(), child.GetID())
+	}
+
+	for _, child := range children {
+		exportBNodeDOT(sb, child, visited)
+	}
+}
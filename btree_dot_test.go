@@ -0,0 +1,51 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBTreeExportDOT(t *testing.T) {
+	tree := NewBehaviorTree(5)
+	sel := NewSelectNode(2)
+	action := NewBaseBehaviorNode(3, 7, 1)
+	action.state = BNODE_STAT_FAIL
+	sel.AddChild(action)
+
+	decorator := NewDecoratorNode(4)
+	running := NewBaseBehaviorNode(6, 8, 1)
+	running.state = BNODE_STAT_EXECUTING
+	decorator.AddChild(running)
+	sel.AddChild(decorator)
+	tree.GetRootNode().AddChild(sel)
+
+	succ := NewBaseBehaviorNode(9, 10, 1)
+	succ.state = BNODE_STAT_SUCC
+	tree.GetRootNode().AddChild(succ)
+
+	dot := ExportDOT(tree)
+	lines := []string{
+		"digraph btree_5 {",
+		"\tn1 [label=\"1 SEQUENCE\", fillcolor=white];",
+		"\tn2 [label=\"2 SELECT\", fillcolor=white];",
+		"\tn3 [label=\"3 ACTION\\naction 7\", fillcolor=red];",
+		"\tn4 [label=\"4 DECORATOR\", fillcolor=white];",
+		"\tn6 [label=\"6 ACTION\\naction 8\", fillcolor=yellow];",
+		"\tn9 [label=\"9 ACTION\\naction 10\", fillcolor=green];",
+		"\tn1 -> n2;",
+		"\tn1 -> n9;",
+		"\tn2 -> n3;",
+		"\tn2 -> n4;",
+		"\tn4 -> n6;",
+	}
+
+	for _, line := range lines {
+		if !strings.Contains(dot, line+"\n") {
+			t.Errorf("dot misses line %q:\n%s", line, dot)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import "math/rand"
+
+//========================
+//        WaitNode
+//========================
+// WaitNode is executing until the dt of its executions sums up to
+// durationMs, then it succeeds.
+type WaitNode struct {
+	*BaseBehaviorNode
+	durationMs int64
+	elapsed    int64
+}
+
+func NewWaitNode(nodeId uint32, durationMs int64) *WaitNode {
+	return &WaitNode{
+		BaseBehaviorNode: NewBaseBehaviorNode(nodeId, 0, 0),
+		durationMs:       durationMs,
+		elapsed:          0,
+	}
+}
+
+func (n *WaitNode) CloneNode() BehaviorNode {
+	return &WaitNode{
+		BaseBehaviorNode: n.cloneBase(),
+		durationMs:       n.durationMs,
+		elapsed:          0,
+	}
+}
+
+func (n *WaitNode) Execute(ctx *BTreeContext) {
+	if n.IsCompleted() {
+		return
+	}
+
+	n.elapsed += ctx.GetDt()
+	if n.elapsed >= n.durationMs {
+		n.state = BNODE_STAT_SUCC
+	} else {
+		n.state = BNODE_STAT_EXECUTING
+	}
+}
+
+func (n *WaitNode) Reset() {
+	n.BaseBehaviorNode.Reset()
+	n.elapsed = 0
+}
+
+//========================
+//      SubtreeNode
+//========================
+// SubtreeNode executes another behavior tree and takes the state of its
+// root. The subtree keeps its runtime state, so a subtree shared by several
+// nodes is executed as one.
+type SubtreeNode struct {
+	*BaseBehaviorNode
+	subtree *BehaviorTree
+}
+
+func NewSubtreeNode(nodeId uint32, subtree *BehaviorTree) *SubtreeNode {
+	return &SubtreeNode{
+		BaseBehaviorNode: NewBaseBehaviorNode(nodeId, 0, 0),
+		subtree:          subtree,
+	}
+}
+
+func (n *SubtreeNode) GetSubtree() *BehaviorTree {
+	return n.subtree
+}
+
+// CloneNode clones the subtree too, the clone doesn't share it.
+func (n *SubtreeNode) CloneNode() BehaviorNode {
+	c := &SubtreeNode{
+		BaseBehaviorNode: n.cloneBase(),
+		subtree:          nil,
+	}
+
+	if n.subtree != nil {
+		c.subtree = n.subtree.Clone(n.subtree.GetID())
+	}
+
+	return c
+}
+
+func (n *SubtreeNode) Execute(ctx *BTreeContext) {
+	if n.IsCompleted() {
+		return
+	}
+
+	if n.subtree == nil {
+		n.state = BNODE_STAT_FAIL
+		return
+	}
+
+	n.subtree.Execute(ctx)
+	n.state = n.subtree.GetState()
+}
+
+func (n *SubtreeNode) Reset() {
+	n.BaseBehaviorNode.Reset()
+	if n.subtree != nil {
+		n.subtree.Reset()
+	}
+}
+
+//========================
+//    ProbabilityNode
+//========================
+// ProbabilityNode succeeds with probability p and fails otherwise, p <= 0
+// always fails and p >= 1 always succeeds.
+type ProbabilityNode struct {
+	*BaseBehaviorNode
+	p   float64
+	rnd *rand.Rand
+}
+
+func NewProbabilityNode(nodeId uint32, p float64) *ProbabilityNode {
+	return &ProbabilityNode{
+		BaseBehaviorNode: NewBaseBehaviorNode(nodeId, 0, 0),
+		p:                p,
+		rnd:              nil,
+	}
+}
+
+// SetRandSource sets the source of the rolls, nil uses the global one.
+func (n *ProbabilityNode) SetRandSource(rnd *rand.Rand) {
+	n.rnd = rnd
+}
+
+// CloneNode shares the rand source with the clone.
+func (n *ProbabilityNode) CloneNode() BehaviorNode {
+	return &ProbabilityNode{
+		BaseBehaviorNode: n.cloneBase(),
+		p:                n.p,
+		rnd:              n.rnd,
+	}
+}
+
+func (n *ProbabilityNode) Execute(ctx *BTreeContext) {
+	if n.IsCompleted() {
+		return
+	}
+
+	if n.roll() < n.p {
+		n.state = BNODE_STAT_SUCC
+	} else {
+		n.state = BNODE_STAT_FAIL
+	}
+}
+
+func (n *ProbabilityNode) roll() float64 {
+	if n.rnd != nil {
+		return n.rnd.Float64()
+	}
+
+	return rand.Float64()
+}
+
+//========================
+//      ScriptedNode
+//========================
+// ScriptedNode takes the next of its scripted states on each Execute, the
+// last one is kept once exhausted, no state fails. It helps to test
+// composites. Reset doesn't rewind the script, Rewind does.
+type ScriptedNode struct {
+	*BaseBehaviorNode
+	results   []BNodeState
+	index     int
+	execCount int
+}
+
+func NewScriptedNode(nodeId uint32, results ...BNodeState) *ScriptedNode {
+	return &ScriptedNode{
+		BaseBehaviorNode: NewBaseBehaviorNode(nodeId, 0, 0),
+		results:          results,
+		index:            0,
+		execCount:        0,
+	}
+}
+
+func (n *ScriptedNode) CloneNode() BehaviorNode {
+	return &ScriptedNode{
+		BaseBehaviorNode: n.cloneBase(),
+		results:          n.results,
+		index:            0,
+		execCount:        0,
+	}
+}
+
+func (n *ScriptedNode) Execute(ctx *BTreeContext) {
+	n.execCount++
+	if len(n.results) == 0 {
+		n.state = BNODE_STAT_FAIL
+		return
+	}
+
+	n.state = n.results[n.index]
+	if n.index < len(n.results)-1 {
+		n.index++
+	}
+}
+
+// GetExecCount returns the number of executions since created or rewound.
+func (n *ScriptedNode) GetExecCount() int {
+	return n.execCount
+}
+
+func (n *ScriptedNode) Rewind() {
+	n.BaseBehaviorNode.Reset()
+	n.index = 0
+	n.execCount = 0
+}
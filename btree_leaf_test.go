@@ -0,0 +1,269 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestWaitNode(t *testing.T) {
+	E, S := BNODE_STAT_EXECUTING, BNODE_STAT_SUCC
+	cases := []struct {
+		name string
+		dts  []int64
+		want []BNodeState
+	}{
+		{"exact", []int64{40, 40, 20}, []BNodeState{E, E, S}},
+		{"overshoot", []int64{60, 60}, []BNodeState{E, S}},
+		{"zero dt", []int64{0, 0, 100}, []BNodeState{E, E, S}},
+		{"one tick", []int64{150}, []BNodeState{S}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			n := NewWaitNode(1, 100)
+			for i, dt := range c.dts {
+				n.Execute(NewBTreeContext(nil, dt))
+				if n.GetState() != c.want[i] {
+					t.Fatalf("tick %d: state %d, want %d", i, n.GetState(), c.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestWaitNodeReset(t *testing.T) {
+	n := NewWaitNode(1, 100)
+	n.Execute(NewBTreeContext(nil, 90))
+	n.Reset()
+
+	n.Execute(NewBTreeContext(nil, 20))
+	if n.GetState() != BNODE_STAT_EXECUTING {
+		t.Fatalf("state %d after Reset, want executing", n.GetState())
+	}
+}
+
+func TestSubtreeNode(t *testing.T) {
+	E, S, F := BNODE_STAT_EXECUTING, BNODE_STAT_SUCC, BNODE_STAT_FAIL
+	cases := []struct {
+		name    string
+		results []BNodeState
+		want    []BNodeState
+		bNext   bool
+	}{
+		{"subtree succeeds", []BNodeState{S}, []BNodeState{S}, true},
+		{"subtree succeeds later", []BNodeState{E, S}, []BNodeState{E, S}, true},
+		{"subtree fails", []BNodeState{F}, []BNodeState{F}, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			subtree := newTestTree(NewScriptedNode(2, c.results...))
+			next := NewScriptedNode(3, BNODE_STAT_SUCC)
+			tree := newTestTree(NewSubtreeNode(2, subtree), next)
+
+			ctx := NewBTreeContext(nil, 0)
+			for i, want := range c.want {
+				tree.Execute(ctx)
+				if tree.GetState() != want {
+					t.Fatalf("tick %d: state %d, want %d", i, tree.GetState(), want)
+				}
+			}
+
+			if (next.GetExecCount() > 0) != c.bNext {
+				t.Fatalf("node after the subtree executed %d times", next.GetExecCount())
+			}
+		})
+	}
+}
+
+func TestSubtreeNodeReset(t *testing.T) {
+	subtree := newTestTree(NewScriptedNode(2, BNODE_STAT_SUCC))
+	n := NewSubtreeNode(1, subtree)
+	n.Execute(NewBTreeContext(nil, 0))
+	n.Reset()
+
+	if subtree.GetState() != BNODE_STAT_NOT_EXECUTE {
+		t.Fatalf("subtree state %d after Reset, want not executed", subtree.GetState())
+	}
+
+	empty := NewSubtreeNode(1, nil)
+	empty.Execute(NewBTreeContext(nil, 0))
+	if empty.GetState() != BNODE_STAT_FAIL {
+		t.Fatalf("state %d without subtree, want fail", empty.GetState())
+	}
+}
+
+func TestProbabilityNode(t *testing.T) {
+	const runs = 10000
+	cases := []struct {
+		name string
+		p    float64
+		min  int
+		max  int
+	}{
+		{"never", 0, 0, 0},
+		{"below zero", -0.5, 0, 0},
+		{"always", 1, runs, runs},
+		{"above one", 1.5, runs, runs},
+		{"thirty percent", 0.3, runs*3/10 - runs/50, runs*3/10 + runs/50},
+		{"half", 0.5, runs/2 - runs/50, runs/2 + runs/50},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			n := NewProbabilityNode(1, c.p)
+			n.SetRandSource(rand.New(rand.NewSource(42)))
+			ctx := NewBTreeContext(nil, 0)
+			succ := 0
+			for i := 0; i < runs; i++ {
+				n.Reset()
+				n.Execute(ctx)
+				if n.GetState() == BNODE_STAT_SUCC {
+					succ++
+				} else if n.GetState() != BNODE_STAT_FAIL {
+					t.Fatalf("run %d: state %d", i, n.GetState())
+				}
+			}
+
+			if succ < c.min || succ > c.max {
+				t.Fatalf("%d successes of %d, want [%d, %d]", succ, runs, c.min, c.max)
+			}
+		})
+	}
+}
+
+func TestProbabilityNodeSeeded(t *testing.T) {
+	results := func(n *ProbabilityNode) []BNodeState {
+		ctx := NewBTreeContext(nil, 0)
+		states := make([]BNodeState, 0, 20)
+		for i := 0; i < 20; i++ {
+			n.Reset()
+			n.Execute(ctx)
+			states = append(states, n.GetState())
+		}
+
+		return states
+	}
+
+	a := NewProbabilityNode(1, 0.5)
+	a.SetRandSource(rand.New(rand.NewSource(7)))
+	b := NewProbabilityNode(1, 0.5)
+	b.SetRandSource(rand.New(rand.NewSource(7)))
+	ra, rb := results(a), results(b)
+	for i := range ra {
+		if ra[i] != rb[i] {
+			t.Fatalf("run %d: %d and %d from the same seed", i, ra[i], rb[i])
+		}
+	}
+}
+
+func TestProbabilityNodeInSequence(t *testing.T) {
+	branch := NewScriptedNode(3, BNODE_STAT_SUCC)
+	gate := NewProbabilityNode(2, 0.3)
+	gate.SetRandSource(rand.New(rand.NewSource(1)))
+	seq := NewSequenceNode(1)
+	seq.AddChild(gate)
+	seq.AddChild(branch)
+
+	ctx := NewBTreeContext(nil, 0)
+	succ := 0
+	for i := 0; i < 1000; i++ {
+		seq.Reset()
+		for !seq.IsCompleted() {
+			seq.Execute(ctx)
+		}
+
+		if seq.GetState() == BNODE_STAT_SUCC {
+			succ++
+		}
+	}
+
+	if succ != branch.GetExecCount() || succ < 250 || succ > 350 {
+		t.Fatalf("branch ran %d times, sequence succeeded %d of 1000", branch.GetExecCount(), succ)
+	}
+}
+
+func TestScriptedNode(t *testing.T) {
+	E, S, F := BNODE_STAT_EXECUTING, BNODE_STAT_SUCC, BNODE_STAT_FAIL
+	cases := []struct {
+		name    string
+		results []BNodeState
+		want    []BNodeState
+	}{
+		{"single", []BNodeState{S}, []BNodeState{S, S, S}},
+		{"script in order", []BNodeState{E, E, F}, []BNodeState{E, E, F}},
+		{"last kept", []BNodeState{E, S}, []BNodeState{E, S, S, S}},
+		{"no state fails", nil, []BNodeState{F, F}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			n := NewScriptedNode(1, c.results...)
+			ctx := NewBTreeContext(nil, 0)
+			for i, want := range c.want {
+				n.Execute(ctx)
+				if n.GetState() != want {
+					t.Fatalf("execute %d: state %s, want %s", i, n.GetState(), want)
+				}
+			}
+
+			if n.GetExecCount() != len(c.want) {
+				t.Fatalf("exec count %d, want %d", n.GetExecCount(), len(c.want))
+			}
+		})
+	}
+}
+
+func TestScriptedNodeRewind(t *testing.T) {
+	E, S := BNODE_STAT_EXECUTING, BNODE_STAT_SUCC
+	n := NewScriptedNode(1, E, S)
+	ctx := NewBTreeContext(nil, 0)
+	n.Execute(ctx)
+	n.Reset()
+	if n.GetState() != BNODE_STAT_NOT_EXECUTE {
+		t.Fatalf("state %s after Reset", n.GetState())
+	}
+
+	n.Execute(ctx)
+	if n.GetState() != S {
+		t.Fatalf("Reset rewound the script, state %s", n.GetState())
+	}
+
+	c := n.CloneNode().(*ScriptedNode)
+	n.Rewind()
+	for _, node := range []*ScriptedNode{n, c} {
+		if node.GetExecCount() != 0 {
+			t.Fatalf("node exec count %d, want 0", node.GetExecCount())
+		}
+
+		node.Execute(ctx)
+		if node.GetState() != E {
+			t.Fatalf("state %s, want the script from its start", node.GetState())
+		}
+	}
+}
+
+func TestScriptedNodeDrivesComposite(t *testing.T) {
+	E, S := BNODE_STAT_EXECUTING, BNODE_STAT_SUCC
+	first := NewScriptedNode(2, E, E, S)
+	second := NewScriptedNode(3, S)
+	seq := NewSequenceNode(1)
+	seq.AddChild(first)
+	seq.AddChild(second)
+
+	ctx := NewBTreeContext(nil, 0)
+	for i, want := range []BNodeState{E, E, S} {
+		seq.Execute(ctx)
+		if seq.GetState() != want {
+			t.Fatalf("tick %d: state %s, want %s", i, seq.GetState(), want)
+		}
+	}
+
+	if first.GetExecCount() != 3 || second.GetExecCount() != 1 {
+		t.Fatalf("children ran %d %d, want 3 1", first.GetExecCount(), second.GetExecCount())
+	}
+}
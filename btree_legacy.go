@@ -0,0 +1,87 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+// LegacyBehaviorNode is the BehaviorNode before Execute took a BTreeContext
+// and before Reset, OnAbort, GetParent and Children were added, wrap such a
+// node with NewLegacyBNodeAdapter to use it in a tree.
+type LegacyBehaviorNode interface {
+	GetID() uint32
+	GetActionID() uint32
+	GetType() BNodeType
+	UpdateStep()
+	GetStep() uint32
+	GetMaxStep() uint32
+	GetState() BNodeState
+	IsCompleted() bool
+	Execute()
+
+	AddChild(child BehaviorNode)
+	RemoveChild(child BehaviorNode)
+	RemoveChildByID(nodeId uint32)
+	GetChildByID(nodeId uint32) (BehaviorNode, bool)
+}
+
+//========================
+//     LegacyBNodeAdapter
+//========================
+// LegacyBNodeAdapter makes a LegacyBehaviorNode a BehaviorNode, Execute
+// ignores the context. Reset, OnAbort, GetParent and Children are forwarded
+// if the wrapped node has them, e.g. by embedding *BaseBehaviorNode, and do
+// nothing otherwise.
+type LegacyBNodeAdapter struct {
+	LegacyBehaviorNode
+}
+
+func NewLegacyBNodeAdapter(node LegacyBehaviorNode) *LegacyBNodeAdapter {
+	return &LegacyBNodeAdapter{
+		LegacyBehaviorNode: node,
+	}
+}
+
+func (a *LegacyBNodeAdapter) Execute(ctx *BTreeContext) {
+	a.LegacyBehaviorNode.Execute()
+}
+
+func (a *LegacyBNodeAdapter) Reset() {
+	r, ok := a.LegacyBehaviorNode.(interface{ Reset() })
+	if ok {
+		r.Reset()
+	}
+}
+
+func (a *LegacyBNodeAdapter) OnAbort() {
+	r, ok := a.LegacyBehaviorNode.(interface{ OnAbort() })
+	if ok {
+		r.OnAbort()
+	}
+}
+
+func (a *LegacyBNodeAdapter) GetParent() (BehaviorNode, bool) {
+	r, ok := a.LegacyBehaviorNode.(interface {
+		GetParent() (BehaviorNode, bool)
+	})
+	if !ok {
+		return nil, false
+	}
+
+	return r.GetParent()
+}
+
+func (a *LegacyBNodeAdapter) Children() []BehaviorNode {
+	r, ok := a.LegacyBehaviorNode.(interface{ Children() []BehaviorNode })
+	if !ok {
+		return nil
+	}
+
+	return r.Children()
+}
+
+func (a *LegacyBNodeAdapter) setParent(parent *BaseBehaviorNode) {
+	setter, ok := a.LegacyBehaviorNode.(bnodeParentSetter)
+	if ok {
+		setter.setParent(parent)
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import "testing"
+
+type testLegacyNode struct {
+	*BaseBehaviorNode
+	execCount int
+}
+
+func (n *testLegacyNode) Execute() {
+	n.execCount++
+	n.SetState(BNODE_STAT_SUCC)
+}
+
+func TestLegacyBNodeAdapter(t *testing.T) {
+	legacy := &testLegacyNode{BaseBehaviorNode: NewBaseBehaviorNode(2, 0, 0)}
+	node := NewLegacyBNodeAdapter(legacy)
+	tree := newTestTree(node)
+
+	tree.Execute(NewBTreeContext(nil, 0))
+	if legacy.execCount != 1 || !tree.IsCompleted() {
+		t.Fatalf("exec count %d completed %v, want 1 true", legacy.execCount, tree.IsCompleted())
+	}
+
+	parent, ok := node.GetParent()
+	if !ok || parent != tree.GetRootNode() {
+		t.Fatalf("parent %v %v, want the root node", parent, ok)
+	}
+
+	tree.Reset()
+	if legacy.GetState() != BNODE_STAT_NOT_EXECUTE {
+		t.Fatalf("state %v after Reset, want %v", legacy.GetState(), BNODE_STAT_NOT_EXECUTE)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import "sync"
+
+//========================
+//        NodePool
+//========================
+// NodePool recycles the basic nodes: BaseBehaviorNode for ACTION,
+// SequenceNode, SelectNode, ParallelNode and DecoratorNode. Release only
+// recycles the node itself, the children are dropped, not released.
+type NodePool struct {
+	mapType2Pool map[BNodeType]*sync.Pool
+}
+
+func NewNodePool() *NodePool {
+	p := &NodePool{
+		mapType2Pool: make(map[BNodeType]*sync.Pool),
+	}
+
+	p.mapType2Pool[BNODE_TYPE_ACTION] = &sync.Pool{New: func() interface{} {
+		return NewBaseBehaviorNode(0, 0, 0)
+	}}
+
+	p.mapType2Pool[BNODE_TYPE_SEQUENCE] = &sync.Pool{New: func() interface{} {
+		return NewSequenceNode(0)
+	}}
+
+	p.mapType2Pool[BNODE_TYPE_SELECT] = &sync.Pool{New: func() interface{} {
+		return NewSelectNode(0)
+	}}
+
+	p.mapType2Pool[BNODE_TYPE_PARALLEL] = &sync.Pool{New: func() interface{} {
+		return NewParallelNode(0)
+	}}
+
+	p.mapType2Pool[BNODE_TYPE_DECORATOR] = &sync.Pool{New: func() interface{} {
+		return NewDecoratorNode(0)
+	}}
+
+	return p
+}
+
+// Acquire returns a node of nodeType with a clean state, nil if the type
+// is unknown.
+func (p *NodePool) Acquire(nodeType BNodeType, nodeId uint32, actionId uint32, maxStep uint32) BehaviorNode {
+	pool, ok := p.mapType2Pool[nodeType]
+	if !ok {
+		return nil
+	}
+
+	node := pool.Get()
+	switch n := node.(type) {
+	case *BaseBehaviorNode:
+		n.init(nodeId, nodeType, actionId, maxStep)
+		return n
+	case *SequenceNode:
+		n.init(nodeId, nodeType, actionId, maxStep)
+		return n
+	case *SelectNode:
+		n.init(nodeId, nodeType, actionId, maxStep)
+		n.abortMode = BNODE_ABORT_NONE
+		return n
+	case *ParallelNode:
+		n.init(nodeId, nodeType, actionId, maxStep)
+		return n
+	case *DecoratorNode:
+		n.init(nodeId, nodeType, actionId, maxStep)
+		return n
+	}
+
+	return nil
+}
+
+// Release puts the node back to the pool, nodes of other types are ignored.
+// The node must not be used after.
+func (p *NodePool) Release(node BehaviorNode) {
+	switch n := node.(type) {
+	case *BaseBehaviorNode:
+		p.put(BNODE_TYPE_ACTION, n)
+	case *SequenceNode:
+		n.clearChildren()
+		p.put(BNODE_TYPE_SEQUENCE, n)
+	case *SelectNode:
+		n.clearChildren()
+		p.put(BNODE_TYPE_SELECT, n)
+	case *ParallelNode:
+		n.clearChildren()
+		p.put(BNODE_TYPE_PARALLEL, n)
+	case *DecoratorNode:
+		n.RemoveChild(n.child)
+		p.put(BNODE_TYPE_DECORATOR, n)
+	}
+}
+
+func (p *NodePool) put(nodeType BNodeType, node interface{}) {
+	pool, ok := p.mapType2Pool[nodeType]
+	if ok {
+		pool.Put(node)
+	}
+}
+
+func (n *BaseBehaviorNode) init(nodeId uint32, nodeType BNodeType, actionId uint32, maxStep uint32) {
+	n.nodeId = nodeId
+	n.nodeType = nodeType
+	n.actionId = actionId
+	n.state = BNODE_STAT_NOT_EXECUTE
+	n.step = 0
+	n.maxStep = maxStep
+	n.parent = nil
+}
+
+func (n *ControlNode) clearChildren() {
+	for i, child := range n.subNodes {
+		setBNodeParent(child, nil)
+		n.subNodes[i] = nil
+	}
+
+	n.subNodes = n.subNodes[:0]
+}
@@ -0,0 +1,110 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import "testing"
+
+func TestNodePoolReacquireClean(t *testing.T) {
+	cases := []struct {
+		name     string
+		nodeType BNodeType
+	}{
+		{"action", BNODE_TYPE_ACTION},
+		{"sequence", BNODE_TYPE_SEQUENCE},
+		{"select", BNODE_TYPE_SELECT},
+		{"parallel", BNODE_TYPE_PARALLEL},
+		{"decorator", BNODE_TYPE_DECORATOR},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := NewNodePool()
+			node := p.Acquire(c.nodeType, 2, 20, 3)
+			if node.GetID() != 2 || node.GetType() != c.nodeType || node.GetActionID() != 20 || node.GetMaxStep() != 3 {
+				t.Fatalf("acquired node %d type %v action %d max step %d", node.GetID(), node.GetType(), node.GetActionID(), node.GetMaxStep())
+			}
+
+			// dirty the node
+			parent := NewSequenceNode(1)
+			parent.AddChild(node)
+			child := NewBaseBehaviorNode(5, 0, 0)
+			node.AddChild(child)
+			node.UpdateStep()
+			ctx := NewBTreeContext(nil, 0)
+			node.Execute(ctx)
+			sel, ok := node.(*SelectNode)
+			if ok {
+				sel.SetAbortMode(BNODE_ABORT_LOWER_PRIORITY)
+			}
+
+			p.Release(node)
+			_, ok = child.GetParent()
+			if ok {
+				t.Fatalf("released node keeps its child")
+			}
+
+			node = p.Acquire(c.nodeType, 3, 30, 4)
+			if node.GetID() != 3 || node.GetType() != c.nodeType || node.GetActionID() != 30 || node.GetMaxStep() != 4 {
+				t.Fatalf("reacquired node %d type %v action %d max step %d", node.GetID(), node.GetType(), node.GetActionID(), node.GetMaxStep())
+			}
+
+			if node.GetState() != BNODE_STAT_NOT_EXECUTE || node.GetStep() != 0 || len(node.Children()) != 0 {
+				t.Fatalf("reacquired node state %d step %d children %d", node.GetState(), node.GetStep(), len(node.Children()))
+			}
+
+			_, ok = node.GetParent()
+			if ok {
+				t.Fatalf("reacquired node has a parent")
+			}
+
+			sel, ok = node.(*SelectNode)
+			if ok && sel.GetAbortMode() != BNODE_ABORT_NONE {
+				t.Fatalf("reacquired select keeps its abort mode")
+			}
+		})
+	}
+}
+
+func TestNodePoolUnknownType(t *testing.T) {
+	p := NewNodePool()
+	node := p.Acquire(BNodeType(99), 1, 0, 0)
+	if node != nil {
+		t.Fatalf("acquired a node of an unknown type")
+	}
+
+	p.Release(NewWaitNode(1, 10))
+}
+
+const benchPoolTreeLeaves = 8
+
+func BenchmarkNodeAllocWithoutPool(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		root := NewSequenceNode(1)
+		for j := 0; j < benchPoolTreeLeaves; j++ {
+			root.AddChild(NewBaseBehaviorNode(uint32(j+2), 1, 0))
+		}
+	}
+}
+
+func BenchmarkNodeAllocWithPool(b *testing.B) {
+	p := NewNodePool()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		root := p.Acquire(BNODE_TYPE_SEQUENCE, 1, 0, 0)
+		leaves := make([]BehaviorNode, 0, benchPoolTreeLeaves)
+		for j := 0; j < benchPoolTreeLeaves; j++ {
+			leaf := p.Acquire(BNODE_TYPE_ACTION, uint32(j+2), 1, 0)
+			root.AddChild(leaf)
+			leaves = append(leaves, leaf)
+		}
+
+		for _, leaf := range leaves {
+			p.Release(leaf)
+		}
+
+		p.Release(root)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+//========================
+//      NodeRuntime
+//========================
+// NodeRuntime is the saved runtime of a node. RunningIndex is the child
+// a MemSequenceNode, MemSelectNode or RandomSequenceNode resumes at, Order
+// the ids of the children of a RandomSequenceNode in the shuffled order.
+type NodeRuntime struct {
+	State        BNodeState
+	Step         uint32
+	RunningIndex int
+	Order        []uint32
+}
+
+type bnodeRuntimeSaver interface {
+	saveRuntime(r *NodeRuntime)
+}
+
+type bnodeRuntimeRestorer interface {
+	restoreRuntime(r NodeRuntime)
+}
+
+func (n *BaseBehaviorNode) restoreRuntime(r NodeRuntime) {
+	n.state = r.State
+	n.step = r.Step
+}
+
+func (n *MemSequenceNode) saveRuntime(r *NodeRuntime) {
+	r.RunningIndex = n.runningIndex
+}
+
+func (n *MemSequenceNode) restoreRuntime(r NodeRuntime) {
+	n.BaseBehaviorNode.restoreRuntime(r)
+	n.runningIndex = clampBNodeIndex(r.RunningIndex, len(n.subNodes))
+}
+
+func (n *MemSelectNode) saveRuntime(r *NodeRuntime) {
+	r.RunningIndex = n.runningIndex
+}
+
+func (n *MemSelectNode) restoreRuntime(r NodeRuntime) {
+	n.BaseBehaviorNode.restoreRuntime(r)
+	n.runningIndex = clampBNodeIndex(r.RunningIndex, len(n.subNodes))
+}
+
+func (n *RandomSequenceNode) saveRuntime(r *NodeRuntime) {
+	r.RunningIndex = n.runningIndex
+	if n.order == nil {
+		return
+	}
+
+	r.Order = make([]uint32, 0, len(n.order))
+	for _, child := range n.order {
+		r.Order = append(r.Order, child.GetID())
+	}
+}
+
+// restoreRuntime keeps a nil in the order for a child no longer in the
+// node, so the running index still points to the same child.
+func (n *RandomSequenceNode) restoreRuntime(r NodeRuntime) {
+	n.BaseBehaviorNode.restoreRuntime(r)
+	if r.Order == nil {
+		return
+	}
+
+	n.order = make([]BehaviorNode, 0, len(r.Order))
+	for _, nodeId := range r.Order {
+		child, _ := n.GetChildByID(nodeId)
+		n.order = append(n.order, child)
+	}
+
+	n.runningIndex = clampBNodeIndex(r.RunningIndex, len(n.order))
+}
+
+func clampBNodeIndex(index int, count int) int {
+	if index < 0 {
+		return 0
+	}
+
+	if index > count {
+		return count
+	}
+
+	return index
+}
+
+// SaveRuntime returns the runtime of the nodes by node id. The other runtime
+// of a node, like the elapsed time of a WaitNode, the child picked by a
+// WeightedRandomNode or the children supplied to a DynamicSelectNode, isn't
+// saved, such a node picks or queries again on its next execution.
+func (t *BehaviorTree) SaveRuntime() map[uint32]NodeRuntime {
+	t.lock()
+	defer t.unlock()
+
+	m := make(map[uint32]NodeRuntime)
+	Walk(t.rootNode, func(node BehaviorNode, depth int) bool {
+		r := NodeRuntime{
+			State:        node.GetState(),
+			Step:         node.GetStep(),
+			RunningIndex: 0,
+			Order:        nil,
+		}
+
+		saver, ok := node.(bnodeRuntimeSaver)
+		if ok {
+			saver.saveRuntime(&r)
+		}
+
+		m[node.GetID()] = r
+		return true
+	})
+
+	return m
+}
+
+// RestoreRuntime resets the tree, then restores the nodes in m. The nodes
+// not in m stay BNODE_STAT_NOT_EXECUTE.
+func (t *BehaviorTree) RestoreRuntime(m map[uint32]NodeRuntime) {
+	t.lock()
+	defer t.unlock()
+
+	t.rootNode.Reset()
+	Walk(t.rootNode, func(node BehaviorNode, depth int) bool {
+		r, ok := m[node.GetID()]
+		if !ok {
+			return true
+		}
+
+		restorer, ok := node.(bnodeRuntimeRestorer)
+		if ok {
+			restorer.restoreRuntime(r)
+		}
+
+		return true
+	})
+}
@@ -0,0 +1,38 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import (
+	"fmt"
+	"strings"
+)
+
+const BTREE_STRING_INDENT = "  "
+
+// String renders the behavior tree as an indented outline, one node per
+// line: "<id> <type> action=<action id> state=<state>", the children are
+// indented one level deeper than their parent.
+func String(t *BehaviorTree) string {
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "btree %d\n", t.GetID())
+
+	visited := make(map[BehaviorNode]bool)
+	writeBNodeString(sb, t.GetRootNode(), 1, visited)
+	return sb.String()
+}
+
+func writeBNodeString(sb *strings.Builder, node BehaviorNode, depth int, visited map[BehaviorNode]bool) {
+	if node == nil || visited[node] {
+		return
+	}
+
+	visited[node] = true
+
+	sb.WriteString(strings.Repeat(BTREE_STRING_INDENT, depth))
+	fmt.Fprintf(sb, "%d %s action=%d state=%s\n", node.GetID(), node.GetType().String(), node.GetActionID(), node.GetState().String())
+	for _, child := range node.Children() {
+		writeBNodeString(sb, child, depth+1, visited)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import "testing"
+
+func TestBehaviorTreeString(t *testing.T) {
+	newTree := func() *BehaviorTree {
+		sel := NewSelectNode(3)
+		sel.AddChild(NewScriptedNode(4, BNODE_STAT_EXECUTING))
+		tree := newTestTree(NewAgentBNode(2, 10, 0, &testBNodeListener{results: []BNodeState{BNODE_STAT_SUCC}}), sel)
+		tree.treeId = 7
+		return tree
+	}
+
+	cases := []struct {
+		name  string
+		ticks int
+		want  string
+	}{
+		{"not executed", 0, "btree 7\n" +
+			"  1 SEQUENCE action=0 state=NOT_EXECUTE\n" +
+			"    2 ACTION action=10 state=NOT_EXECUTE\n" +
+			"    3 SELECT action=0 state=NOT_EXECUTE\n" +
+			"      4 ACTION action=0 state=NOT_EXECUTE\n"},
+		{"mid execution", 1, "btree 7\n" +
+			"  1 SEQUENCE action=0 state=EXECUTING\n" +
+			"    2 ACTION action=10 state=SUCC\n" +
+			"    3 SELECT action=0 state=EXECUTING\n" +
+			"      4 ACTION action=0 state=EXECUTING\n"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tree := newTree()
+			for i := 0; i < c.ticks; i++ {
+				tree.Execute(NewBTreeContext(nil, 0))
+			}
+
+			got := String(tree)
+			if got != c.want {
+				t.Fatalf("String =\n%s\nwant\n%s", got, c.want)
+			}
+		})
+	}
+}
+
+func TestBehaviorTreeStringSharedNode(t *testing.T) {
+	shared := NewScriptedNode(3, BNODE_STAT_SUCC)
+	sel := NewSelectNode(2)
+	sel.AddChild(shared)
+	tree := newTestTree(sel, shared)
+
+	want := "btree 1\n" +
+		"  1 SEQUENCE action=0 state=NOT_EXECUTE\n" +
+		"    2 SELECT action=0 state=NOT_EXECUTE\n" +
+		"      3 ACTION action=0 state=NOT_EXECUTE\n"
+	if got := String(tree); got != want {
+		t.Fatalf("String =\n%s\nwant\n%s", got, want)
+	}
+}
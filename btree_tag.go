@@ -0,0 +1,85 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+// SetTag tags the node of nodeId, a node may have several tags. The tags
+// are kept by the tree and copied by Clone.
+func (t *BehaviorTree) SetTag(nodeId uint32, tag string) error {
+	t.lock()
+	defer t.unlock()
+
+	_, ok := findBNodeByID(t.rootNode, nodeId)
+	if !ok {
+		return ErrBNodeNotExist
+	}
+
+	mapId2Tagged, ok := t.mapTag2NodeIds[tag]
+	if !ok {
+		mapId2Tagged = make(map[uint32]bool)
+		t.mapTag2NodeIds[tag] = mapId2Tagged
+	}
+
+	mapId2Tagged[nodeId] = true
+	return nil
+}
+
+func (t *BehaviorTree) RemoveTag(nodeId uint32, tag string) {
+	t.lock()
+	defer t.unlock()
+
+	mapId2Tagged, ok := t.mapTag2NodeIds[tag]
+	if !ok {
+		return
+	}
+
+	delete(mapId2Tagged, nodeId)
+	if len(mapId2Tagged) == 0 {
+		delete(t.mapTag2NodeIds, tag)
+	}
+}
+
+// removeStaleTags removes the tags of the node ids not found in the tree.
+func (t *BehaviorTree) removeStaleTags() {
+	mapId2Exist := make(map[uint32]bool)
+	Walk(t.rootNode, func(node BehaviorNode, depth int) bool {
+		mapId2Exist[node.GetID()] = true
+		return true
+	})
+
+	for tag, mapId2Tagged := range t.mapTag2NodeIds {
+		for nodeId := range mapId2Tagged {
+			if !mapId2Exist[nodeId] {
+				delete(mapId2Tagged, nodeId)
+			}
+		}
+
+		if len(mapId2Tagged) == 0 {
+			delete(t.mapTag2NodeIds, tag)
+		}
+	}
+}
+
+// FindByTag returns the nodes with tag in depth first order, the tagged
+// nodes removed from the tree are skipped.
+func (t *BehaviorTree) FindByTag(tag string) []BehaviorNode {
+	t.lock()
+	defer t.unlock()
+
+	nodes := make([]BehaviorNode, 0)
+	mapId2Tagged, ok := t.mapTag2NodeIds[tag]
+	if !ok {
+		return nodes
+	}
+
+	Walk(t.rootNode, func(node BehaviorNode, depth int) bool {
+		if mapId2Tagged[node.GetID()] {
+			nodes = append(nodes, node)
+		}
+
+		return true
+	})
+
+	return nodes
+}
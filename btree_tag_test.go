@@ -0,0 +1,136 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import (
+	"fmt"
+	"testing"
+)
+
+func testNodeIDs(nodes []BehaviorNode) string {
+	ids := make([]uint32, 0, len(nodes))
+	for _, node := range nodes {
+		ids = append(ids, node.GetID())
+	}
+
+	return fmt.Sprint(ids)
+}
+
+func TestBehaviorTreeFindByTag(t *testing.T) {
+	tree := newTestWalkTree()
+	tags := []struct {
+		nodeId uint32
+		tag    string
+	}{
+		{6, "combat"},
+		{3, "combat"},
+		{5, "combat"},
+		{2, "movement"},
+		{5, "movement"},
+	}
+
+	for _, tag := range tags {
+		err := tree.SetTag(tag.nodeId, tag.tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		tag  string
+		want string
+	}{
+		{"combat", "[3 5 6]"},
+		{"movement", "[2 5]"},
+		{"unknown", "[]"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.tag, func(t *testing.T) {
+			got := testNodeIDs(tree.FindByTag(c.tag))
+			if got != c.want {
+				t.Fatalf("FindByTag = %s, want %s", got, c.want)
+			}
+		})
+	}
+}
+
+func TestBehaviorTreeTagChanges(t *testing.T) {
+	cases := []struct {
+		name   string
+		change func(tree *BehaviorTree)
+		want   string
+	}{
+		{"tag again", func(tree *BehaviorTree) { tree.SetTag(3, "combat") }, "[3 6]"},
+		{"remove tag", func(tree *BehaviorTree) { tree.RemoveTag(3, "combat") }, "[6]"},
+		{"remove untagged", func(tree *BehaviorTree) { tree.RemoveTag(5, "combat") }, "[3 6]"},
+		{"remove last", func(tree *BehaviorTree) {
+			tree.RemoveTag(3, "combat")
+			tree.RemoveTag(6, "combat")
+		}, "[]"},
+		{"node removed", func(tree *BehaviorTree) { tree.RemoveChild(BTREE_ROOT_NODE_ID, 6) }, "[3]"},
+		{"subtree removed", func(tree *BehaviorTree) { tree.RemoveChild(BTREE_ROOT_NODE_ID, 2) }, "[6]"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tree := newTestWalkTree()
+			tree.SetTag(3, "combat")
+			tree.SetTag(6, "combat")
+			c.change(tree)
+			got := testNodeIDs(tree.FindByTag("combat"))
+			if got != c.want {
+				t.Fatalf("FindByTag = %s, want %s", got, c.want)
+			}
+		})
+	}
+}
+
+func TestBehaviorTreeSetTagUnknownNode(t *testing.T) {
+	tree := newTestWalkTree()
+	err := tree.SetTag(99, "combat")
+	if err != ErrBNodeNotExist {
+		t.Fatalf("err %v, want ErrBNodeNotExist", err)
+	}
+
+	if len(tree.FindByTag("combat")) != 0 {
+		t.Fatalf("unknown node tagged")
+	}
+}
+
+func TestBehaviorTreeCloneCopiesTags(t *testing.T) {
+	tree := newTestTree(NewScriptedNode(2, BNODE_STAT_SUCC), NewScriptedNode(3, BNODE_STAT_SUCC))
+	tree.SetTag(2, "combat")
+	c := tree.Clone(2)
+	tree.SetTag(3, "combat")
+
+	nodes := c.FindByTag("combat")
+	if testNodeIDs(nodes) != "[2]" {
+		t.Fatalf("clone tags %s, want [2]", testNodeIDs(nodes))
+	}
+
+	orig, _ := tree.FindNodeByID(2)
+	if nodes[0] == orig {
+		t.Fatalf("clone tag finds the node of the original tree")
+	}
+}
+
+func TestBehaviorTreeSetRootRemovesStaleTags(t *testing.T) {
+	tree := newTestTree(NewScriptedNode(2, BNODE_STAT_SUCC), NewScriptedNode(3, BNODE_STAT_SUCC))
+	tree.SetTag(2, "combat")
+	tree.SetTag(3, "combat")
+	tree.SetTag(3, "idle")
+
+	root := NewSelectNode(BTREE_ROOT_NODE_ID)
+	root.AddChild(NewScriptedNode(2, BNODE_STAT_SUCC))
+	err := tree.SetRoot(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tree.mapTag2NodeIds) != 1 || len(tree.mapTag2NodeIds["combat"]) != 1 || !tree.mapTag2NodeIds["combat"][2] {
+		t.Fatalf("tags %v, want combat on node 2 only", tree.mapTag2NodeIds)
+	}
+}
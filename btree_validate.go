@@ -0,0 +1,69 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import "fmt"
+
+// Validate checks the behavior tree for cycles, duplicate node ids and
+// composite nodes without child, the composites supplying their children at
+// execution are exempted. The returned error names the node.
+func Validate(t *BehaviorTree) error {
+	root := t.GetRootNode()
+	if root == nil {
+		return ErrBTreeNoRoot
+	}
+
+	mapId2Node := make(map[uint32]BehaviorNode)
+	mapNode2InPath := make(map[BehaviorNode]bool)
+	return validateBNode(root, mapId2Node, mapNode2InPath)
+}
+
+func validateBNode(node BehaviorNode, mapId2Node map[uint32]BehaviorNode, mapNode2InPath map[BehaviorNode]bool) error {
+	nodeId := node.GetID()
+	if mapNode2InPath[node] {
+		return fmt.Errorf("%w: node %d", ErrBTreeCycle, nodeId)
+	}
+
+	_, ok := mapId2Node[nodeId]
+	if ok {
+		return fmt.Errorf("%w: node %d", ErrBNodeIDDuplicate, nodeId)
+	}
+
+	mapId2Node[nodeId] = node
+
+	children := node.Children()
+	if isBNodeComposite(node) && len(children) == 0 && !isBNodeChildSupplied(node) {
+		return fmt.Errorf("%w: node %d", ErrBNodeNoChild, nodeId)
+	}
+
+	mapNode2InPath[node] = true
+	for _, child := range children {
+		if child == nil {
+			return fmt.Errorf("%w: child of node %d", ErrBNodeNil, nodeId)
+		}
+
+		err := validateBNode(child, mapId2Node, mapNode2InPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	delete(mapNode2InPath, node)
+	return nil
+}
+
+func isBNodeComposite(node BehaviorNode) bool {
+	nodeType := node.GetType()
+	return nodeType == BNODE_TYPE_SEQUENCE || nodeType == BNODE_TYPE_SELECT || nodeType == BNODE_TYPE_PARALLEL
+}
+
+type bnodeChildSupplier interface {
+	isChildSupplied() bool
+}
+
+func isBNodeChildSupplied(node BehaviorNode) bool {
+	supplier, ok := node.(bnodeChildSupplier)
+	return ok && supplier.isChildSupplied()
+}
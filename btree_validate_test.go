@@ -0,0 +1,74 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	supplier := func() []BehaviorNode {
+		return []BehaviorNode{NewBaseBehaviorNode(20, 1, 0)}
+	}
+
+	cases := []struct {
+		name     string
+		children []BehaviorNode
+		err      error
+	}{
+		{"valid", []BehaviorNode{NewBaseBehaviorNode(2, 1, 0)}, nil},
+		{"empty root", nil, ErrBNodeNoChild},
+		{"empty select", []BehaviorNode{NewSelectNode(2)}, ErrBNodeNoChild},
+		{"duplicate id", []BehaviorNode{NewBaseBehaviorNode(2, 1, 0), NewBaseBehaviorNode(2, 1, 0)}, ErrBNodeIDDuplicate},
+		{"dynamic select", []BehaviorNode{NewDynamicSelectNode(2, supplier)}, nil},
+		{"dynamic select without supplier", []BehaviorNode{NewDynamicSelectNode(2, nil)}, ErrBNodeNoChild},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := Validate(newTestTree(c.children...))
+			if !errors.Is(err, c.err) {
+				t.Fatalf("err = %v, want %v", err, c.err)
+			}
+		})
+	}
+}
+
+func TestValidateCycle(t *testing.T) {
+	seq := NewSequenceNode(2)
+	tree := newTestTree(seq)
+	seq.AddChild(tree.GetRootNode())
+
+	err := Validate(tree)
+	if !errors.Is(err, ErrBTreeCycle) {
+		t.Fatalf("err = %v, want %v", err, ErrBTreeCycle)
+	}
+}
+
+func TestValidateNamesNode(t *testing.T) {
+	self := NewSequenceNode(3)
+	self.subNodes = append(self.subNodes, self)
+
+	cases := []struct {
+		name string
+		tree *BehaviorTree
+		err  error
+		msg  string
+	}{
+		{"self cycle", newTestTree(self), ErrBTreeCycle, "node 3"},
+		{"duplicate id", newTestTree(NewBaseBehaviorNode(4, 1, 0), NewBaseBehaviorNode(4, 2, 0)), ErrBNodeIDDuplicate, "node 4"},
+		{"empty parallel", newTestTree(NewParallelNode(5)), ErrBNodeNoChild, "node 5"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := Validate(c.tree)
+			if !errors.Is(err, c.err) || err.Error() != c.err.Error()+": "+c.msg {
+				t.Fatalf("err = %v, want %v naming %s", err, c.err, c.msg)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+type BNodeVisitFunc func(node BehaviorNode, depth int) bool
+
+// Walk visits the nodes in pre-order starting from root at depth 0. The
+// children of a node are skipped when visit returns false. The tree must
+// not contain cycle, see Validate.
+func Walk(root BehaviorNode, visit BNodeVisitFunc) {
+	if root == nil || visit == nil {
+		return
+	}
+
+	walkBNode(root, 0, visit)
+}
+
+func walkBNode(node BehaviorNode, depth int, visit BNodeVisitFunc) {
+	if !visit(node, depth) {
+		return
+	}
+
+	for _, child := range node.Children() {
+		if child != nil {
+			walkBNode(child, depth+1, visit)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import (
+	"fmt"
+	"testing"
+)
+
+// newTestWalkTree returns root 1 ( select 2 ( 3, decorator 4 ( 5 ) ), 6 ).
+func newTestWalkTree() *BehaviorTree {
+	sel := NewSelectNode(2)
+	sel.AddChild(NewBaseBehaviorNode(3, 0, 0))
+	decorator := NewDecoratorNode(4)
+	decorator.AddChild(NewBaseBehaviorNode(5, 0, 0))
+	sel.AddChild(decorator)
+	return newTestTree(sel, NewBaseBehaviorNode(6, 0, 0))
+}
+
+func TestWalk(t *testing.T) {
+	cases := []struct {
+		name string
+		stop uint32
+		want string
+	}{
+		{"all", 0, "[1:0 2:1 3:2 4:2 5:3 6:1]"},
+		{"skip select", 2, "[1:0 2:1 6:1]"},
+		{"skip decorator", 4, "[1:0 2:1 3:2 4:2 6:1]"},
+		{"skip root", 1, "[1:0]"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			visited := make([]string, 0)
+			Walk(newTestWalkTree().GetRootNode(), func(node BehaviorNode, depth int) bool {
+				visited = append(visited, fmt.Sprintf("%d:%d", node.GetID(), depth))
+				return node.GetID() != c.stop
+			})
+
+			got := fmt.Sprint(visited)
+			if got != c.want {
+				t.Fatalf("visited %s, want %s", got, c.want)
+			}
+		})
+	}
+}
+
+func TestWalkNil(t *testing.T) {
+	Walk(nil, func(node BehaviorNode, depth int) bool {
+		t.Fatalf("visited a nil root")
+		return true
+	})
+
+	Walk(NewSequenceNode(1), nil)
+}
@@ -4,7 +4,13 @@
 
 package ai
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+)
 
 var (
 	ErrNameLenZero      = errors.New("len of name is 0")
@@ -17,6 +23,29 @@ var (
 	ErrNoOldStat        = errors.New("no old state")
 	ErrFromStatNotExist = errors.New("from state not exist")
 	ErrToStatNotExist   = errors.New("to state not exist")
+	ErrStatNotExist     = errors.New("state not exist")
+	ErrStatExist        = errors.New("state exist")
+	ErrActExist         = errors.New("action exist")
+	ErrActNotExist      = errors.New("action not exist")
+	ErrBinaryMagic      = errors.New("invalid binary magic")
+	ErrBinaryVersion    = errors.New("unsupported binary version")
+	ErrBinaryData       = errors.New("invalid binary data")
+	ErrActVetoed        = errors.New("action vetoed")
+	ErrBBOpInvalid      = errors.New("invalid blackboard compare op")
+	ErrStatIDExist      = errors.New("state id exist")
+	ErrStatIDNotExist   = errors.New("state id not exist")
+	ErrEvtIDExist       = errors.New("event id exist")
+	ErrEvtIDNotExist    = errors.New("event id not exist")
+	ErrEvtCooling       = errors.New("event is cooling down")
+)
+
+type TriggerResult uint8
+
+const (
+	TRIGGER_RESULT_TRANSITIONED TriggerResult = iota
+	TRIGGER_RESULT_ACTION_VETOED
+	TRIGGER_RESULT_NO_TRANSITION
+	TRIGGER_RESULT_COOLDOWN
 )
 
 type FSMState interface {
@@ -26,45 +55,295 @@ type FSMState interface {
 	OnExit(toState string)
 }
 
+// FSMStateResettable is an optional interface of FSMState, OnReset is called
+// by FSM.Reset.
+type FSMStateResettable interface {
+	OnReset()
+}
+
 type FSMAction interface {
 	GetName() string
 	DoAction(evt string, param ...interface{}) bool
 }
 
+// FSMActionEx is an optional interface of FSMAction, when implemented the
+// FSM calls DoActionEx instead of DoAction to know why a transition is vetoed.
+type FSMActionEx interface {
+	DoActionEx(evt string, param ...interface{}) (bool, error)
+}
+
+type FSMEventValidator func(param ...interface{}) error
+
+type FSMListener interface {
+	OnTransitionBlocked(from string, evt string, to string, err error)
+}
+
+type FSMGuardFunc func(bb *Blackboard) bool
+
+type FSMUnhandledEventFunc func(fsmId uint32, state string, evt string, param ...interface{})
+
+// FSMTransition.ActionParams are passed to the action after the params of
+// the event. A transition not Enabled, or whose Guard returns false with
+// the blackboard of the FSM, is skipped by Trigger. Among the transitions
+// of the same state and event, the higher Priority is tried first, then the
+// earlier added. The Priority of an added transition is changed by
+// SetTransitionPriority. Pattern is true if the transition is added by
+// AddPatternTransition, its Event is then a pattern.
 type FSMTransition struct {
-	From   string
-	Event  string
-	To     string
-	Action string
+	From         string
+	Event        string
+	To           string
+	Action       string
+	ActionParams []interface{}
+	Enabled      bool
+	Guard        FSMGuardFunc
+	Priority     int
+	Pattern      bool
 }
 
 func NewFSMTransition(from string, evt string, to string, action string) *FSMTransition {
 	return &FSMTransition{
-		From:   from,
-		Event:  evt,
-		To:     to,
-		Action: action,
+		From:         from,
+		Event:        evt,
+		To:           to,
+		Action:       action,
+		ActionParams: nil,
+		Enabled:      true,
+		Guard:        nil,
+		Priority:     0,
+		Pattern:      false,
 	}
 }
 
+// HistoryEntry records a state entering, Time is the sum of the dt given to
+// Update before it.
+type HistoryEntry struct {
+	From  string
+	To    string
+	Event string
+	Time  int64
+}
+
+type globalTransition struct {
+	tran      *FSMTransition
+	mapExcept map[string]bool
+}
+
+type FSMWatchdogFunc func(fsmId uint32, state string)
+
+type stateWatchdog struct {
+	maxMs   int64
+	onStuck FSMWatchdogFunc
+	bFired  bool
+}
+
+type stateDwell struct {
+	maxMs    int64
+	fallback string
+}
+
+// FSM is lock free by default. In thread safe mode lckEvt serializes the
+// operations invoking callbacks (Start, Stop, Update, Trigger, PopState...)
+// and lckData guards the fields against getters and setters. Callbacks may
+// use getters and setters, but must not run an operation of the same FSM.
+// The transition guards are called with lckData held, they must only use
+// the blackboard they get.
 type FSM struct {
-	id             uint32
-	state          string
-	oldStates      []string
-	mapName2State  map[string]FSMState
-	mapName2Action map[string]FSMAction
-	transitions    []*FSMTransition
+	id                   uint32
+	initState            string
+	state                string
+	oldStates            []string
+	mapName2State        map[string]FSMState
+	stateNames           []string
+	mapName2Action       map[string]FSMAction
+	transitions          []*FSMTransition
+	mapKey2Transitions   map[string][]*FSMTransition
+	globalTransitions    []*globalTransition
+	mapState2EntryAction map[string]string
+	mapState2ExitAction  map[string]string
+	mapState2UpdAction   map[string]string
+	mapState2EntryCount  map[string]uint32
+	stateTime            int64
+	totalTime            int64
+	generation           uint32
+	bHistory             bool
+	history              []HistoryEntry
+	blackboard           *Blackboard
+	mapState2Dwell       map[string]*stateDwell
+	mapState2Watchdog    map[string]*stateWatchdog
+	mapState2Data        map[string]interface{}
+	listener             FSMListener
+	unhandledHandler     FSMUnhandledEventFunc
+	mapEvt2Validator     map[string]FSMEventValidator
+	mapId2StateName      map[uint32]string
+	mapId2Event          map[uint32]string
+	mapEvt2Cooldown      map[string]int64
+	mapEvt2LastUse       map[string]int64
+	delayedEvents        []*FSMDelayedEvent
+	mapState2Patterns    map[string][]*FSMTransition
+	bThreadSafe          bool
+	lckEvt               sync.Mutex
+	lckData              sync.RWMutex
 }
 
 func NewFSM(id uint32) *FSM {
 	return &FSM{
-		id:             id,
-		state:          "",
-		oldStates:      make([]string, 0),
-		mapName2State:  make(map[string]FSMState),
-		mapName2Action: make(map[string]FSMAction),
-		transitions:    make([]*FSMTransition, 0),
+		id:                   id,
+		initState:            "",
+		state:                "",
+		oldStates:            make([]string, 0),
+		mapName2State:        make(map[string]FSMState),
+		stateNames:           make([]string, 0),
+		mapName2Action:       make(map[string]FSMAction),
+		transitions:          make([]*FSMTransition, 0),
+		mapKey2Transitions:   make(map[string][]*FSMTransition),
+		globalTransitions:    make([]*globalTransition, 0),
+		mapState2EntryAction: make(map[string]string),
+		mapState2ExitAction:  make(map[string]string),
+		mapState2UpdAction:   make(map[string]string),
+		mapState2EntryCount:  make(map[string]uint32),
+		stateTime:            0,
+		totalTime:            0,
+		generation:           0,
+		bHistory:             false,
+		history:              nil,
+		blackboard:           nil,
+		mapState2Dwell:       make(map[string]*stateDwell),
+		mapState2Watchdog:    make(map[string]*stateWatchdog),
+		mapState2Data:        make(map[string]interface{}),
+		listener:             nil,
+		mapEvt2Validator:     make(map[string]FSMEventValidator),
+		mapId2StateName:      make(map[uint32]string),
+		mapId2Event:          make(map[uint32]string),
+		mapEvt2Cooldown:      make(map[string]int64),
+		mapEvt2LastUse:       make(map[string]int64),
+		delayedEvents:        make([]*FSMDelayedEvent, 0),
+		mapState2Patterns:    make(map[string][]*FSMTransition),
+		unhandledHandler:     nil,
+		bThreadSafe:          false,
+	}
+}
+
+// SetListener sets the listener, nil removes it.
+func (f *FSM) SetListener(listener FSMListener) {
+	f.lockData()
+	defer f.unlockData()
+
+	f.listener = listener
+}
+
+func (f *FSM) GetListener() FSMListener {
+	f.rlockData()
+	defer f.runlockData()
+
+	return f.listener
+}
+
+// SetUnhandledEventHandler sets the handler called when Trigger finds no
+// transition of the event from the current state, nil removes it.
+func (f *FSM) SetUnhandledEventHandler(handler FSMUnhandledEventFunc) {
+	f.lockData()
+	defer f.unlockData()
+
+	f.unhandledHandler = handler
+}
+
+func (f *FSM) getUnhandledEventHandler() FSMUnhandledEventFunc {
+	f.rlockData()
+	defer f.runlockData()
+
+	return f.unhandledHandler
+}
+
+// SetEventValidator sets the validator of the params of evt, Trigger
+// returns its error before looking for a transition. A nil validator
+// removes it.
+func (f *FSM) SetEventValidator(evt string, validator FSMEventValidator) {
+	f.lockData()
+	defer f.unlockData()
+
+	if validator == nil {
+		delete(f.mapEvt2Validator, evt)
+		return
+	}
+
+	f.mapEvt2Validator[evt] = validator
+}
+
+func (f *FSM) getEventValidator(evt string) (FSMEventValidator, bool) {
+	f.rlockData()
+	defer f.runlockData()
+
+	validator, ok := f.mapEvt2Validator[evt]
+	return validator, ok
+}
+
+// SetEventCooldown makes Trigger ignore evt with TRIGGER_RESULT_COOLDOWN
+// until cooldownMs of Update time passed since its last transition. A
+// cooldownMs <= 0 removes the cooldown.
+func (f *FSM) SetEventCooldown(evt string, cooldownMs int64) {
+	f.lockData()
+	defer f.unlockData()
+
+	if cooldownMs <= 0 {
+		delete(f.mapEvt2Cooldown, evt)
+		delete(f.mapEvt2LastUse, evt)
+		return
 	}
+
+	f.mapEvt2Cooldown[evt] = cooldownMs
+}
+
+func (f *FSM) isEventCooling(evt string) bool {
+	f.rlockData()
+	defer f.runlockData()
+
+	cooldown, ok := f.mapEvt2Cooldown[evt]
+	if !ok {
+		return false
+	}
+
+	lastUse, ok := f.mapEvt2LastUse[evt]
+	return ok && f.totalTime-lastUse < cooldown
+}
+
+func (f *FSM) markEventUse(evt string) {
+	f.lockData()
+	defer f.unlockData()
+
+	_, ok := f.mapEvt2Cooldown[evt]
+	if ok {
+		f.mapEvt2LastUse[evt] = f.totalTime
+	}
+}
+
+// RecordHistory turns the recording of the entered states on or off, the
+// recorded history is cleared.
+func (f *FSM) RecordHistory(bRecord bool) {
+	f.lockData()
+	defer f.unlockData()
+
+	f.bHistory = bRecord
+	f.history = nil
+}
+
+func (f *FSM) GetHistory() []HistoryEntry {
+	f.rlockData()
+	defer f.runlockData()
+
+	history := make([]HistoryEntry, len(f.history))
+	copy(history, f.history)
+	return history
+}
+
+// SetThreadSafe turns the locking on or off, it must be called before the
+// FSM is shared between goroutines.
+func (f *FSM) SetThreadSafe(bThreadSafe bool) {
+	f.bThreadSafe = bThreadSafe
+}
+
+func (f *FSM) IsThreadSafe() bool {
+	return f.bThreadSafe
 }
 
 func (f *FSM) GetID() uint32 {
@@ -72,10 +351,81 @@ func (f *FSM) GetID() uint32 {
 }
 
 func (f *FSM) GetCurState() string {
+	f.rlockData()
+	defer f.runlockData()
+
 	return f.state
 }
 
+func (f *FSM) SetInitialState(name string) {
+	f.lockData()
+	defer f.unlockData()
+
+	f.initState = name
+}
+
+func (f *FSM) GetInitialState() string {
+	f.rlockData()
+	defer f.runlockData()
+
+	return f.initState
+}
+
+// GetTotalTime returns the sum of the dt given to Update since started.
+func (f *FSM) GetTotalTime() int64 {
+	f.rlockData()
+	defer f.runlockData()
+
+	return f.totalTime
+}
+
+// GetGeneration returns the count of the runtime replacements by Reset,
+// RestoreRuntime and UnmarshalBinary, it is never cleared. The entry counts
+// of the states are only comparable within a generation.
+func (f *FSM) GetGeneration() uint32 {
+	f.rlockData()
+	defer f.runlockData()
+
+	return f.generation
+}
+
+// GetStateTime returns the elapsed time in the current state, accumulated by Update.
+func (f *FSM) GetStateTime() int64 {
+	f.rlockData()
+	defer f.runlockData()
+
+	return f.stateTime
+}
+
+func (f *FSM) SetBlackboard(bb *Blackboard) {
+	f.lockData()
+	defer f.unlockData()
+
+	if f.blackboard == bb {
+		return
+	}
+
+	if f.blackboard != nil {
+		f.blackboard.release()
+	}
+
+	f.blackboard = bb
+	if bb != nil {
+		bb.retain()
+	}
+}
+
+func (f *FSM) GetBlackboard() *Blackboard {
+	f.rlockData()
+	defer f.runlockData()
+
+	return f.blackboard
+}
+
 func (f *FSM) AddState(name string, stat FSMState) error {
+	f.lockData()
+	defer f.unlockData()
+
 	if len(name) == 0 {
 		return ErrNameLenZero
 	}
@@ -84,193 +434,1083 @@ func (f *FSM) AddState(name string, stat FSMState) error {
 		return ErrStatNil
 	}
 
-	f.mapName2State[name] = stat
-	return nil
-}
-
-func (f *FSM) RemoveState(name string) {
 	_, ok := f.mapName2State[name]
 	if ok {
-		delete(f.mapName2State, name)
+		return ErrStatExist
 	}
-}
 
-func (f *FSM) GetState(name string) (FSMState, bool) {
-	stat, ok := f.mapName2State[name]
-	return stat, ok
+	f.mapName2State[name] = stat
+	f.stateNames = append(f.stateNames, name)
+	return nil
 }
 
-func (f *FSM) AddAction(name string, act FSMAction) error {
+// ReplaceState registers the state, overwriting the one with the same name.
+func (f *FSM) ReplaceState(name string, stat FSMState) error {
+	f.lockData()
+	defer f.unlockData()
+
 	if len(name) == 0 {
 		return ErrNameLenZero
 	}
 
-	if act == nil {
-		return ErrActNil
+	if stat == nil {
+		return ErrStatNil
 	}
 
-	f.mapName2Action[name] = act
+	_, ok := f.mapName2State[name]
+	if !ok {
+		f.stateNames = append(f.stateNames, name)
+	}
+
+	f.mapName2State[name] = stat
 	return nil
 }
 
-func (f *FSM) RemoveAction(name string) {
-	_, ok := f.mapName2Action[name]
+func (f *FSM) RemoveState(name string) {
+	f.lockData()
+	defer f.unlockData()
+
+	_, ok := f.mapName2State[name]
 	if ok {
-		delete(f.mapName2Action, name)
+		delete(f.mapName2State, name)
+		for i, exist := range f.stateNames {
+			if exist == name {
+				f.stateNames = append(f.stateNames[:i], f.stateNames[i+1:]...)
+				break
+			}
+		}
 	}
-}
-
-func (f *FSM) GetAction(name string) (FSMAction, bool) {
-	act, ok := f.mapName2Action[name]
-	return act, ok
-}
 
-func (f *FSM) AddTransition(from string, evt string, to string, action string) error {
-	if len(from) == 0 {
-		return ErrFromStatNotExist
+	_, ok = f.mapState2EntryAction[name]
+	if ok {
+		delete(f.mapState2EntryAction, name)
 	}
 
-	if len(evt) == 0 {
-		return ErrEvtEmpty
+	_, ok = f.mapState2ExitAction[name]
+	if ok {
+		delete(f.mapState2ExitAction, name)
 	}
 
-	if len(to) == 0 {
-		return ErrToStatNotExist
+	_, ok = f.mapState2UpdAction[name]
+	if ok {
+		delete(f.mapState2UpdAction, name)
 	}
 
-	tran := NewFSMTransition(from, evt, to, action)
-	f.transitions = append(f.transitions, tran)
-	return nil
-}
+	_, ok = f.mapState2EntryCount[name]
+	if ok {
+		delete(f.mapState2EntryCount, name)
+	}
 
-func (f *FSM) RemoveTransition(from string, evt string) {
-	if len(from) == 0 {
-		return
+	_, ok = f.mapState2Dwell[name]
+	if ok {
+		delete(f.mapState2Dwell, name)
 	}
 
-	if len(evt) == 0 {
-		return
+	_, ok = f.mapState2Watchdog[name]
+	if ok {
+		delete(f.mapState2Watchdog, name)
 	}
 
-	for i, tran := range f.transitions {
-		if tran.From == from && tran.Event == evt {
-			f.transitions = append(f.transitions[:i], f.transitions[i+1:]...)
-			break
-		}
+	_, ok = f.mapState2Data[name]
+	if ok {
+		delete(f.mapState2Data, name)
 	}
 }
 
-func (f *FSM) GetTransition(from string, evt string) (*FSMTransition, bool) {
-	if len(from) == 0 {
-		return nil, false
-	}
+func (f *FSM) GetState(name string) (FSMState, bool) {
+	f.rlockData()
+	defer f.runlockData()
 
-	if len(evt) == 0 {
-		return nil, false
-	}
+	stat, ok := f.mapName2State[name]
+	return stat, ok
+}
 
-	for _, tran := range f.transitions {
-		if tran.From == from && tran.Event == evt {
-			return tran, true
-		}
-	}
+// GetStateEntryCount returns how many times the state has been entered.
+func (f *FSM) GetStateEntryCount(name string) uint32 {
+	f.rlockData()
+	defer f.runlockData()
 
-	return nil, false
+	return f.mapState2EntryCount[name]
 }
 
-func (f *FSM) Start(firstState string) error {
-	if len(firstState) == 0 {
-		return ErrNoFirstStat
+// SetEntryAction binds a registered action to run when entering the state,
+// before OnEnter. An empty action name clears the binding.
+func (f *FSM) SetEntryAction(state string, actionName string) error {
+	f.lockData()
+	defer f.unlockData()
+
+	if len(state) == 0 {
+		return ErrNameLenZero
 	}
 
-	stat, ok := f.GetState(firstState)
-	if ok {
-		f.state = firstState
-		stat.OnEnter("")
+	if len(actionName) == 0 {
+		delete(f.mapState2EntryAction, state)
+		return nil
 	}
+
+	f.mapState2EntryAction[state] = actionName
 	return nil
 }
 
-func (f *FSM) Stop() {
-	if len(f.state) == 0 {
-		return
-	}
+// SetExitAction binds a registered action to run when exiting the state,
+// after OnExit. An empty action name clears the binding.
+func (f *FSM) SetExitAction(state string, actionName string) error {
+	f.lockData()
+	defer f.unlockData()
 
-	stat, ok := f.GetState(f.state)
-	if ok {
-		stat.OnExit("")
+	if len(state) == 0 {
+		return ErrNameLenZero
 	}
-}
 
-func (f *FSM) Update(dt int64) {
-	stat, ok := f.GetState(f.state)
-	if ok {
-		stat.OnUpdate(dt)
+	if len(actionName) == 0 {
+		delete(f.mapState2ExitAction, state)
+		return nil
 	}
+
+	f.mapState2ExitAction[state] = actionName
+	return nil
 }
 
-func (f *FSM) Trigger(evt string, param ...interface{}) error {
-	if len(evt) == 0 {
-		return ErrEvtEmpty
+// SetStateUpdateAction binds a registered action to run by every Update in
+// the state, after OnUpdate. The action gets an empty event and dt as param.
+// An empty action name clears the binding.
+func (f *FSM) SetStateUpdateAction(state string, actionName string) error {
+	f.lockData()
+	defer f.unlockData()
+
+	if len(state) == 0 {
+		return ErrNameLenZero
 	}
 
-	if len(f.state) == 0 {
-		return ErrNoFirstStat
+	if len(actionName) == 0 {
+		delete(f.mapState2UpdAction, state)
+		return nil
 	}
 
-	triggerTran, ok := f.GetTransition(f.state, evt)
-	if !ok {
-		return ErrTranNotExist
+	f.mapState2UpdAction[state] = actionName
+	return nil
+}
+
+// SetStateMaxDwell forces a transition to fallbackState when the FSM stays
+// in the state for maxMs or longer. It is checked by Update, a maxMs <= 0
+// removes the cap.
+func (f *FSM) SetStateMaxDwell(name string, maxMs int64, fallbackState string) error {
+	f.lockData()
+	defer f.unlockData()
+
+	if len(name) == 0 {
+		return ErrNameLenZero
 	}
 
-	// check transition
-	oldStat, ok := f.GetState(f.state)
-	if !ok {
-		return ErrFromStatNotExist
+	if maxMs <= 0 {
+		delete(f.mapState2Dwell, name)
+		return nil
 	}
 
-	newStat, ok := f.GetState(triggerTran.To)
-	if !ok {
+	if len(fallbackState) == 0 {
 		return ErrToStatNotExist
 	}
 
-	// do transition
-	act, ok := f.GetAction(triggerTran.Action)
-	if ok {
-		succ := act.DoAction(evt, param...)
-		if !succ {
-			return nil
-		}
+	f.mapState2Dwell[name] = &stateDwell{
+		maxMs:    maxMs,
+		fallback: fallbackState,
 	}
 
-	oldStat.OnExit(triggerTran.To)
-	newStat.OnEnter(f.state)
-
-	f.oldStates = append(f.oldStates, f.state)
-	f.state = triggerTran.To
 	return nil
 }
 
-func (f *FSM) PopState() error {
-	if len(f.oldStates) == 0 {
-		return ErrNoOldStat
-	}
+// SetStateWatchdog calls onStuck once when the FSM stays in the state for
+// maxMs of Update time, it is armed again when the state is entered. A
+// maxMs <= 0 or a nil onStuck removes the watchdog.
+func (f *FSM) SetStateWatchdog(state string, maxMs int64, onStuck FSMWatchdogFunc) error {
+	f.lockData()
+	defer f.unlockData()
 
-	oldStat, ok := f.GetState(f.state)
-	if !ok {
-		return ErrFromStatNotExist
+	if len(state) == 0 {
+		return ErrNameLenZero
 	}
 
-	idx := len(f.oldStates) - 1
-	newStat, ok := f.GetState(f.oldStates[idx])
-	if !ok {
-		return ErrToStatNotExist
+	if maxMs <= 0 || onStuck == nil {
+		delete(f.mapState2Watchdog, state)
+		return nil
 	}
 
-	oldStat.OnExit(f.oldStates[idx])
-	newStat.OnEnter(f.state)
+	f.mapState2Watchdog[state] = &stateWatchdog{
+		maxMs:   maxMs,
+		onStuck: onStuck,
+		bFired:  false,
+	}
 
-	f.state = f.oldStates[idx]
-	f.oldStates = f.oldStates[:idx]
 	return nil
 }
+
+// SetStateData attaches user data to the state, a nil data removes it.
+// The data is dropped by RemoveState.
+func (f *FSM) SetStateData(name string, data interface{}) {
+	f.lockData()
+	defer f.unlockData()
+
+	if data == nil {
+		delete(f.mapState2Data, name)
+		return
+	}
+
+	f.mapState2Data[name] = data
+}
+
+func (f *FSM) GetStateData(name string) (interface{}, bool) {
+	f.rlockData()
+	defer f.runlockData()
+
+	data, ok := f.mapState2Data[name]
+	return data, ok
+}
+
+func (f *FSM) AddAction(name string, act FSMAction) error {
+	f.lockData()
+	defer f.unlockData()
+
+	if len(name) == 0 {
+		return ErrNameLenZero
+	}
+
+	if act == nil {
+		return ErrActNil
+	}
+
+	_, ok := f.mapName2Action[name]
+	if ok {
+		return ErrActExist
+	}
+
+	f.mapName2Action[name] = act
+	return nil
+}
+
+// ReplaceAction registers the action, overwriting the one with the same name.
+func (f *FSM) ReplaceAction(name string, act FSMAction) error {
+	f.lockData()
+	defer f.unlockData()
+
+	if len(name) == 0 {
+		return ErrNameLenZero
+	}
+
+	if act == nil {
+		return ErrActNil
+	}
+
+	f.mapName2Action[name] = act
+	return nil
+}
+
+func (f *FSM) RemoveAction(name string) {
+	f.lockData()
+	defer f.unlockData()
+
+	_, ok := f.mapName2Action[name]
+	if ok {
+		delete(f.mapName2Action, name)
+	}
+}
+
+func (f *FSM) GetAction(name string) (FSMAction, bool) {
+	f.rlockData()
+	defer f.runlockData()
+
+	act, ok := f.mapName2Action[name]
+	return act, ok
+}
+
+func (f *FSM) AddTransition(from string, evt string, to string, action string) error {
+	return f.AddTransitionWithParams(from, evt, to, action)
+}
+
+// AddTransitionWithParams adds a transition whose action gets actionParams
+// after the params of the event.
+func (f *FSM) AddTransitionWithParams(from string, evt string, to string, action string, actionParams ...interface{}) error {
+	tran := NewFSMTransition(from, evt, to, action)
+	tran.ActionParams = actionParams
+	return f.addTransition(tran)
+}
+
+// AddBlackboardTransition adds a transition guarded by the comparison of
+// the blackboard value of bbKey with value, see Blackboard.Compare. The
+// transitions of the same state and event are tried by priority then adding
+// order, so several guarded transitions can branch on the blackboard.
+func (f *FSM) AddBlackboardTransition(from string, evt string, to string, action string, bbKey string, op string, value interface{}) error {
+	if !isBBCompareOp(op) {
+		return ErrBBOpInvalid
+	}
+
+	tran := NewFSMTransition(from, evt, to, action)
+	tran.Guard = func(bb *Blackboard) bool {
+		return bb != nil && bb.Compare(bbKey, op, value)
+	}
+
+	return f.addTransition(tran)
+}
+
+// AddMultiEventTransition adds a transition from the state to the state to
+// for each of evts, nothing is added if an event is empty.
+func (f *FSM) AddMultiEventTransition(from string, evts []string, to string, action string) error {
+	if len(evts) == 0 {
+		return ErrEvtEmpty
+	}
+
+	for _, evt := range evts {
+		if len(evt) == 0 {
+			return ErrEvtEmpty
+		}
+	}
+
+	for _, evt := range evts {
+		err := f.addTransition(NewFSMTransition(from, evt, to, action))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f *FSM) addTransition(tran *FSMTransition) error {
+	f.lockData()
+	defer f.unlockData()
+
+	if len(tran.From) == 0 {
+		return ErrFromStatNotExist
+	}
+
+	if len(tran.Event) == 0 {
+		return ErrEvtEmpty
+	}
+
+	if len(tran.To) == 0 {
+		return ErrToStatNotExist
+	}
+
+	f.transitions = append(f.transitions, tran)
+
+	key := getFSMTransitionKey(tran.From, tran.Event)
+	f.mapKey2Transitions[key] = insertFSMTransition(f.mapKey2Transitions[key], tran)
+	return nil
+}
+
+func (f *FSM) RemoveTransition(from string, evt string) {
+	f.lockData()
+	defer f.unlockData()
+
+	if len(from) == 0 {
+		return
+	}
+
+	if len(evt) == 0 {
+		return
+	}
+
+	key := getFSMTransitionKey(from, evt)
+	trans, ok := f.mapKey2Transitions[key]
+	if !ok {
+		return
+	}
+
+	if len(trans) == 1 {
+		delete(f.mapKey2Transitions, key)
+	} else {
+		f.mapKey2Transitions[key] = trans[1:]
+	}
+
+	for i, tran := range f.transitions {
+		if tran == trans[0] {
+			f.transitions = append(f.transitions[:i], f.transitions[i+1:]...)
+			break
+		}
+	}
+}
+
+// AddGlobalTransition adds a transition of evt from every state except the
+// ones in except. Trigger uses it when the current state has no transition
+// of evt. The From of a global transition is empty.
+func (f *FSM) AddGlobalTransition(evt string, to string, action string, except ...string) error {
+	f.lockData()
+	defer f.unlockData()
+
+	if len(evt) == 0 {
+		return ErrEvtEmpty
+	}
+
+	if len(to) == 0 {
+		return ErrToStatNotExist
+	}
+
+	g := &globalTransition{
+		tran:      NewFSMTransition("", evt, to, action),
+		mapExcept: make(map[string]bool),
+	}
+
+	for _, name := range except {
+		g.mapExcept[name] = true
+	}
+
+	i := len(f.globalTransitions)
+	for i > 0 && f.globalTransitions[i-1].tran.Priority < g.tran.Priority {
+		i--
+	}
+
+	f.globalTransitions = append(f.globalTransitions, nil)
+	copy(f.globalTransitions[i+1:], f.globalTransitions[i:])
+	f.globalTransitions[i] = g
+	return nil
+}
+
+func (f *FSM) RemoveGlobalTransition(evt string) {
+	f.lockData()
+	defer f.unlockData()
+
+	for i, g := range f.globalTransitions {
+		if g.tran.Event == evt {
+			f.globalTransitions = append(f.globalTransitions[:i], f.globalTransitions[i+1:]...)
+			break
+		}
+	}
+}
+
+// SetTransitionPriority sets the priority of the transitions of evt from the
+// state to the state to, an empty from targets the global transitions, evt
+// may be the pattern of a pattern transition.
+func (f *FSM) SetTransitionPriority(from string, evt string, to string, priority int) error {
+	f.lockData()
+	defer f.unlockData()
+
+	bFound := false
+	if len(from) == 0 {
+		for _, g := range f.globalTransitions {
+			if g.tran.Event == evt && g.tran.To == to {
+				g.tran.Priority = priority
+				bFound = true
+			}
+		}
+
+		sort.SliceStable(f.globalTransitions, func(i, j int) bool {
+			return f.globalTransitions[i].tran.Priority > f.globalTransitions[j].tran.Priority
+		})
+	} else {
+		trans := f.mapKey2Transitions[getFSMTransitionKey(from, evt)]
+		for _, tran := range trans {
+			if tran.To == to {
+				tran.Priority = priority
+				bFound = true
+			}
+		}
+
+		sortFSMTransitions(trans)
+
+		patterns := f.mapState2Patterns[from]
+		for _, tran := range patterns {
+			if tran.Event == evt && tran.To == to {
+				tran.Priority = priority
+				bFound = true
+			}
+		}
+
+		sortFSMTransitions(patterns)
+	}
+
+	if !bFound {
+		return ErrTranNotExist
+	}
+
+	return nil
+}
+
+// SetTransitionEnabled enables or disables the transitions of evt from the
+// state, an empty from targets the global transitions of evt, evt may be the
+// pattern of a pattern transition.
+func (f *FSM) SetTransitionEnabled(from string, evt string, bEnabled bool) error {
+	f.lockData()
+	defer f.unlockData()
+
+	bFound := false
+	if len(from) == 0 {
+		for _, g := range f.globalTransitions {
+			if g.tran.Event == evt {
+				g.tran.Enabled = bEnabled
+				bFound = true
+			}
+		}
+	} else {
+		for _, tran := range f.mapKey2Transitions[getFSMTransitionKey(from, evt)] {
+			tran.Enabled = bEnabled
+			bFound = true
+		}
+
+		for _, tran := range f.mapState2Patterns[from] {
+			if tran.Event == evt {
+				tran.Enabled = bEnabled
+				bFound = true
+			}
+		}
+	}
+
+	if !bFound {
+		return ErrTranNotExist
+	}
+
+	return nil
+}
+
+func (f *FSM) findTransition(from string, evt string) (*FSMTransition, bool) {
+	f.rlockData()
+	defer f.runlockData()
+
+	for _, tran := range f.mapKey2Transitions[getFSMTransitionKey(from, evt)] {
+		if f.isTransitionPassable(tran) {
+			return tran, true
+		}
+	}
+
+	for _, g := range f.globalTransitions {
+		if g.tran.Event == evt && !g.mapExcept[from] && f.isTransitionPassable(g.tran) {
+			return g.tran, true
+		}
+	}
+
+	return f.findPatternTransition(from, evt)
+}
+
+// insertFSMTransition inserts tran after the transitions of higher or equal
+// priority, trans is kept by descending priority then adding order.
+func insertFSMTransition(trans []*FSMTransition, tran *FSMTransition) []*FSMTransition {
+	i := len(trans)
+	for i > 0 && trans[i-1].Priority < tran.Priority {
+		i--
+	}
+
+	trans = append(trans, nil)
+	copy(trans[i+1:], trans[i:])
+	trans[i] = tran
+	return trans
+}
+
+// sortFSMTransitions sorts trans by descending priority in place, the order
+// is kept for the same priority.
+func sortFSMTransitions(trans []*FSMTransition) {
+	sort.SliceStable(trans, func(i, j int) bool {
+		return trans[i].Priority > trans[j].Priority
+	})
+}
+
+func (f *FSM) isTransitionPassable(tran *FSMTransition) bool {
+	if !tran.Enabled {
+		return false
+	}
+
+	return tran.Guard == nil || tran.Guard(f.blackboard)
+}
+
+func (f *FSM) GetTransition(from string, evt string) (*FSMTransition, bool) {
+	f.rlockData()
+	defer f.runlockData()
+
+	if len(from) == 0 {
+		return nil, false
+	}
+
+	if len(evt) == 0 {
+		return nil, false
+	}
+
+	trans, ok := f.mapKey2Transitions[getFSMTransitionKey(from, evt)]
+	if !ok {
+		return nil, false
+	}
+
+	return trans[0], true
+}
+
+func getFSMTransitionKey(from string, evt string) string {
+	return from + "\x00" + evt
+}
+
+// ListStates returns the names of the registered states in adding order.
+func (f *FSM) ListStates() []string {
+	f.rlockData()
+	defer f.runlockData()
+
+	return f.listStates()
+}
+
+// ListActions returns the names of the registered actions, sorted.
+func (f *FSM) ListActions() []string {
+	f.rlockData()
+	defer f.runlockData()
+
+	names := make([]string, 0, len(f.mapName2Action))
+	for name := range f.mapName2Action {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// ListTransitions returns copies of the transitions in adding order, the
+// pattern ones included with Pattern set.
+func (f *FSM) ListTransitions() []*FSMTransition {
+	f.rlockData()
+	defer f.runlockData()
+
+	trans := make([]*FSMTransition, 0, len(f.transitions))
+	for _, tran := range f.transitions {
+		trans = append(trans, copyFSMTransition(tran))
+	}
+
+	return trans
+}
+
+func copyFSMTransition(tran *FSMTransition) *FSMTransition {
+	c := *tran
+	c.ActionParams = append([]interface{}(nil), tran.ActionParams...)
+	return &c
+}
+
+func (f *FSM) listStates() []string {
+	names := make([]string, len(f.stateNames))
+	copy(names, f.stateNames)
+	return names
+}
+
+// Start enters firstState, the initial state is used if firstState is empty.
+func (f *FSM) Start(firstState string) error {
+	f.lockEvt()
+	defer f.unlockEvt()
+
+	if len(firstState) == 0 {
+		firstState = f.GetInitialState()
+	}
+
+	if len(firstState) == 0 {
+		return ErrNoFirstStat
+	}
+
+	stat, ok := f.GetState(firstState)
+	if ok {
+		f.setState(firstState)
+		f.enterState(firstState, stat, "", "")
+	}
+	return nil
+}
+
+func (f *FSM) Stop() {
+	f.lockEvt()
+	defer f.unlockEvt()
+
+	if len(f.state) == 0 {
+		return
+	}
+
+	stat, ok := f.GetState(f.state)
+	if ok {
+		f.exitState(f.state, stat, "", "")
+	}
+}
+
+// Reset clears the runtime of the FSM, no state is exited, then calls OnReset
+// of the registered states implementing FSMStateResettable. The blackboard
+// is kept.
+func (f *FSM) Reset() {
+	f.lockEvt()
+	defer f.unlockEvt()
+
+	f.lockData()
+	f.state = ""
+	f.oldStates = make([]string, 0)
+	f.stateTime = 0
+	f.totalTime = 0
+	f.mapState2EntryCount = make(map[string]uint32)
+	f.history = nil
+	f.mapEvt2LastUse = make(map[string]int64)
+	f.delayedEvents = make([]*FSMDelayedEvent, 0)
+	f.generation++
+
+	names := f.listStates()
+	f.unlockData()
+
+	for _, name := range names {
+		stat, ok := f.GetState(name)
+		if !ok {
+			continue
+		}
+
+		resettable, ok := stat.(FSMStateResettable)
+		if ok {
+			resettable.OnReset()
+		}
+	}
+}
+
+func (f *FSM) Update(dt int64) {
+	f.lockEvt()
+	defer f.unlockEvt()
+
+	stat, ok := f.GetState(f.state)
+	if ok {
+		f.lockData()
+		f.stateTime += dt
+		f.totalTime += dt
+		f.unlockData()
+
+		stat.OnUpdate(dt)
+
+		f.rlockData()
+		actName, ok := f.mapState2UpdAction[f.state]
+		f.runlockData()
+
+		if ok {
+			f.doStateAction(actName, f.state, "", dt)
+		}
+
+		f.checkWatchdog()
+		f.checkMaxDwell()
+	}
+
+	f.dispatchDelayedEvents()
+}
+
+func (f *FSM) checkWatchdog() {
+	f.lockData()
+	watchdog, ok := f.mapState2Watchdog[f.state]
+	bStuck := ok && !watchdog.bFired && f.stateTime >= watchdog.maxMs
+	if bStuck {
+		watchdog.bFired = true
+	}
+
+	state := f.state
+	f.unlockData()
+
+	if bStuck {
+		watchdog.onStuck(f.id, state)
+	}
+}
+
+func (f *FSM) checkMaxDwell() {
+	f.rlockData()
+	dwell, ok := f.mapState2Dwell[f.state]
+	f.runlockData()
+	if !ok || f.stateTime < dwell.maxMs {
+		return
+	}
+
+	oldStat, ok := f.GetState(f.state)
+	if !ok {
+		return
+	}
+
+	newStat, ok := f.GetState(dwell.fallback)
+	if !ok {
+		log.Printf("fsm %d: fallback state %s of state %s not exist", f.id, dwell.fallback, f.state)
+		return
+	}
+
+	f.changeState(oldStat, dwell.fallback, newStat, "")
+}
+
+func (f *FSM) Trigger(evt string, param ...interface{}) error {
+	_, err := f.TriggerEx(evt, param...)
+	return err
+}
+
+// FSMChainError is returned by TriggerChain, Succeeded is the count of the
+// events applied before Event failed.
+type FSMChainError struct {
+	Succeeded int
+	Event     string
+	Err       error
+}
+
+func (e *FSMChainError) Error() string {
+	return fmt.Sprintf("trigger chain stopped at event %s after %d events: %v", e.Event, e.Succeeded, e.Err)
+}
+
+func (e *FSMChainError) Unwrap() error {
+	return e.Err
+}
+
+// TriggerChain triggers the events in order and stops at the first one
+// which doesn't change state, a vetoed one fails with ErrActVetoed.
+func (f *FSM) TriggerChain(evts ...string) error {
+	for i, evt := range evts {
+		result, err := f.TriggerEx(evt)
+		if err == nil && result == TRIGGER_RESULT_ACTION_VETOED {
+			err = ErrActVetoed
+		}
+
+		if err == nil && result == TRIGGER_RESULT_COOLDOWN {
+			err = ErrEvtCooling
+		}
+
+		if err != nil {
+			return &FSMChainError{Succeeded: i, Event: evt, Err: err}
+		}
+	}
+
+	return nil
+}
+
+func (f *FSM) TriggerEx(evt string, param ...interface{}) (TriggerResult, error) {
+	f.lockEvt()
+	defer f.unlockEvt()
+
+	return f.triggerEx(evt, param...)
+}
+
+func (f *FSM) triggerEx(evt string, param ...interface{}) (TriggerResult, error) {
+	if len(evt) == 0 {
+		return TRIGGER_RESULT_NO_TRANSITION, ErrEvtEmpty
+	}
+
+	if f.isEventCooling(evt) {
+		return TRIGGER_RESULT_COOLDOWN, nil
+	}
+
+	validator, ok := f.getEventValidator(evt)
+	if ok {
+		err := validator(param...)
+		if err != nil {
+			return TRIGGER_RESULT_NO_TRANSITION, err
+		}
+	}
+
+	if len(f.state) == 0 {
+		return TRIGGER_RESULT_NO_TRANSITION, ErrNoFirstStat
+	}
+
+	triggerTran, ok := f.findTransition(f.state, evt)
+	if !ok {
+		handler := f.getUnhandledEventHandler()
+		if handler != nil {
+			handler(f.id, f.state, evt, param...)
+		}
+
+		return TRIGGER_RESULT_NO_TRANSITION, ErrTranNotExist
+	}
+
+	// check transition
+	oldStat, ok := f.GetState(f.state)
+	if !ok {
+		return TRIGGER_RESULT_NO_TRANSITION, ErrFromStatNotExist
+	}
+
+	newStat, ok := f.GetState(triggerTran.To)
+	if !ok {
+		return TRIGGER_RESULT_NO_TRANSITION, ErrToStatNotExist
+	}
+
+	// do transition
+	act, ok := f.GetAction(triggerTran.Action)
+	if ok {
+		if len(triggerTran.ActionParams) > 0 {
+			param = append(append([]interface{}{}, param...), triggerTran.ActionParams...)
+		}
+
+		succ, err := doTransitionAction(act, evt, param...)
+		if !succ {
+			listener := f.GetListener()
+			if listener != nil {
+				listener.OnTransitionBlocked(f.state, evt, triggerTran.To, err)
+			}
+
+			return TRIGGER_RESULT_ACTION_VETOED, nil
+		}
+	}
+
+	f.changeState(oldStat, triggerTran.To, newStat, evt)
+	f.markEventUse(evt)
+	return TRIGGER_RESULT_TRANSITIONED, nil
+}
+
+// PushState enters the state name without a transition, the current state
+// is remembered and PopState returns to it. The action, if exists, is done
+// before the change with an empty event, ErrActVetoed if it vetoes.
+func (f *FSM) PushState(name string, action string) error {
+	f.lockEvt()
+	defer f.unlockEvt()
+
+	if len(f.state) == 0 {
+		return ErrNoFirstStat
+	}
+
+	oldStat, ok := f.GetState(f.state)
+	if !ok {
+		return ErrFromStatNotExist
+	}
+
+	newStat, ok := f.GetState(name)
+	if !ok {
+		return ErrToStatNotExist
+	}
+
+	act, ok := f.GetAction(action)
+	if ok {
+		succ, err := doTransitionAction(act, "")
+		if !succ {
+			listener := f.GetListener()
+			if listener != nil {
+				listener.OnTransitionBlocked(f.state, "", name, err)
+			}
+
+			return ErrActVetoed
+		}
+	}
+
+	f.changeState(oldStat, name, newStat, "")
+	return nil
+}
+
+// ForceState moves to the state name even if no transition exists, no guard
+// is checked and no action is done. As PushState, PopState returns to the
+// current state.
+func (f *FSM) ForceState(name string) error {
+	return f.PushState(name, "")
+}
+
+func (f *FSM) PopState() error {
+	f.lockEvt()
+	defer f.unlockEvt()
+
+	if len(f.oldStates) == 0 {
+		return ErrNoOldStat
+	}
+
+	oldStat, ok := f.GetState(f.state)
+	if !ok {
+		return ErrFromStatNotExist
+	}
+
+	idx := len(f.oldStates) - 1
+	newStat, ok := f.GetState(f.oldStates[idx])
+	if !ok {
+		return ErrToStatNotExist
+	}
+
+	f.exitState(f.state, oldStat, f.oldStates[idx], "")
+	f.enterState(f.oldStates[idx], newStat, f.state, "")
+
+	f.lockData()
+	f.state = f.oldStates[idx]
+	f.oldStates = f.oldStates[:idx]
+	f.unlockData()
+	return nil
+}
+
+func (f *FSM) changeState(oldStat FSMState, toState string, newStat FSMState, evt string) {
+	f.exitState(f.state, oldStat, toState, evt)
+	f.enterState(toState, newStat, f.state, evt)
+
+	f.lockData()
+	f.oldStates = append(f.oldStates, f.state)
+	f.state = toState
+	f.unlockData()
+}
+
+func (f *FSM) setState(name string) {
+	f.lockData()
+	defer f.unlockData()
+
+	f.state = name
+}
+
+func (f *FSM) enterState(name string, stat FSMState, fromState string, evt string) {
+	f.lockData()
+	f.mapState2EntryCount[name]++
+	f.stateTime = 0
+	watchdog, ok := f.mapState2Watchdog[name]
+	if ok {
+		watchdog.bFired = false
+	}
+
+	if f.bHistory {
+		f.history = append(f.history, HistoryEntry{From: fromState, To: name, Event: evt, Time: f.totalTime})
+	}
+
+	actName, ok := f.mapState2EntryAction[name]
+	f.unlockData()
+
+	if ok {
+		f.doStateAction(actName, name, evt)
+	}
+
+	stat.OnEnter(fromState)
+}
+
+func (f *FSM) exitState(name string, stat FSMState, toState string, evt string) {
+	stat.OnExit(toState)
+
+	f.rlockData()
+	actName, ok := f.mapState2ExitAction[name]
+	f.runlockData()
+
+	if ok {
+		f.doStateAction(actName, name, evt)
+	}
+}
+
+// entry and exit can't be vetoed, a false result is only logged
+func (f *FSM) doStateAction(actName string, state string, evt string, param ...interface{}) {
+	act, ok := f.GetAction(actName)
+	if !ok {
+		log.Printf("fsm %d: action %s of state %s not exist", f.id, actName, state)
+		return
+	}
+
+	succ := act.DoAction(evt, param...)
+	if !succ {
+		log.Printf("fsm %d: action %s of state %s return false", f.id, actName, state)
+	}
+}
+
+func doTransitionAction(act FSMAction, evt string, param ...interface{}) (bool, error) {
+	actEx, ok := act.(FSMActionEx)
+	if ok {
+		return actEx.DoActionEx(evt, param...)
+	}
+
+	return act.DoAction(evt, param...), nil
+}
+
+func (f *FSM) lockEvt() {
+	if f.bThreadSafe {
+		f.lckEvt.Lock()
+	}
+}
+
+func (f *FSM) unlockEvt() {
+	if f.bThreadSafe {
+		f.lckEvt.Unlock()
+	}
+}
+
+func (f *FSM) lockData() {
+	if f.bThreadSafe {
+		f.lckData.Lock()
+	}
+}
+
+func (f *FSM) unlockData() {
+	if f.bThreadSafe {
+		f.lckData.Unlock()
+	}
+}
+
+func (f *FSM) rlockData() {
+	if f.bThreadSafe {
+		f.lckData.RLock()
+	}
+}
+
+func (f *FSM) runlockData() {
+	if f.bThreadSafe {
+		f.lckData.RUnlock()
+	}
+}
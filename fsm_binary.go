@@ -0,0 +1,619 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"sort"
+)
+
+// The binary layout starts with FSM_BINARY_MAGIC and the version byte.
+//
+// Version 1: current state, pushed states, elapsed time in state, state
+// entry counts, blackboard.
+//
+// Version 2: the fields of version 1, then total time, history, delayed
+// events, last use of the cooling events, fired watchdogs.
+const (
+	FSM_BINARY_MAGIC   = "YFSM"
+	FSM_BINARY_VERSION = 2
+)
+
+const (
+	bbValueBool uint8 = iota + 1
+	bbValueInt
+	bbValueInt8
+	bbValueInt16
+	bbValueInt32
+	bbValueInt64
+	bbValueUint
+	bbValueUint8
+	bbValueUint16
+	bbValueUint32
+	bbValueUint64
+	bbValueFloat32
+	bbValueFloat64
+	bbValueString
+)
+
+// MarshalBinary encodes the runtime of the FSM with FSM_BINARY_VERSION:
+// current state, old states, state entry counts, elapsed time in state, the
+// attached blackboard, the total time, the recorded history, the delayed
+// events, the last use of the cooling events and the fired watchdogs. Only
+// primitive blackboard values and delayed event params (bool, integers,
+// floats and string) are encoded, a blackboard value or a delayed event
+// with a param of another type is skipped silently.
+func (f *FSM) MarshalBinary() ([]byte, error) {
+	f.rlockData()
+	defer f.runlockData()
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(FSM_BINARY_MAGIC)
+	buf.WriteByte(FSM_BINARY_VERSION)
+
+	writeBinaryString(buf, f.state)
+	writeBinaryUvarint(buf, uint64(len(f.oldStates)))
+	for _, name := range f.oldStates {
+		writeBinaryString(buf, name)
+	}
+
+	writeBinaryVarint(buf, f.stateTime)
+
+	names := make([]string, 0, len(f.mapState2EntryCount))
+	for name := range f.mapState2EntryCount {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	writeBinaryUvarint(buf, uint64(len(names)))
+	for _, name := range names {
+		writeBinaryString(buf, name)
+		writeBinaryUvarint(buf, uint64(f.mapState2EntryCount[name]))
+	}
+
+	f.marshalBlackboard(buf)
+
+	writeBinaryVarint(buf, f.totalTime)
+	writeBinaryUvarint(buf, uint64(len(f.history)))
+	for _, h := range f.history {
+		writeBinaryString(buf, h.From)
+		writeBinaryString(buf, h.To)
+		writeBinaryString(buf, h.Event)
+		writeBinaryVarint(buf, h.Time)
+	}
+
+	delayedEvents := make([]*FSMDelayedEvent, 0, len(f.delayedEvents))
+	for _, e := range f.delayedEvents {
+		if areBinaryPrimitives(e.Params) {
+			delayedEvents = append(delayedEvents, e)
+		}
+	}
+
+	writeBinaryUvarint(buf, uint64(len(delayedEvents)))
+	for _, e := range delayedEvents {
+		writeBinaryString(buf, e.Event)
+		writeBinaryVarint(buf, e.DueTime)
+		writeBinaryUvarint(buf, uint64(len(e.Params)))
+		for _, param := range e.Params {
+			writeBinaryValue(buf, param)
+		}
+	}
+
+	evts := make([]string, 0, len(f.mapEvt2LastUse))
+	for evt := range f.mapEvt2LastUse {
+		evts = append(evts, evt)
+	}
+
+	sort.Strings(evts)
+	writeBinaryUvarint(buf, uint64(len(evts)))
+	for _, evt := range evts {
+		writeBinaryString(buf, evt)
+		writeBinaryVarint(buf, f.mapEvt2LastUse[evt])
+	}
+
+	fired := f.listFiredWatchdogs()
+	writeBinaryUvarint(buf, uint64(len(fired)))
+	for _, name := range fired {
+		writeBinaryString(buf, name)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary restores the runtime encoded by MarshalBinary, version 1
+// data leaves the fields added by version 2 cleared. The states must be
+// registered already. No enter or exit callbacks are invoked. The
+// attached blackboard is cleared then filled with the decoded values, a new
+// one is attached if there is none.
+func (f *FSM) UnmarshalBinary(data []byte) error {
+	f.lockEvt()
+	defer f.unlockEvt()
+
+	r := bytes.NewReader(data)
+	magic := make([]byte, len(FSM_BINARY_MAGIC))
+	_, err := io.ReadFull(r, magic)
+	if err != nil || string(magic) != FSM_BINARY_MAGIC {
+		return ErrBinaryMagic
+	}
+
+	version, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	if version == 0 || version > FSM_BINARY_VERSION {
+		return ErrBinaryVersion
+	}
+
+	state, err := readBinaryString(r)
+	if err != nil {
+		return err
+	}
+
+	if len(state) != 0 {
+		_, ok := f.GetState(state)
+		if !ok {
+			return ErrStatNotExist
+		}
+	}
+
+	cnt, err := readBinaryCount(r)
+	if err != nil {
+		return err
+	}
+
+	oldStates := make([]string, 0, cnt)
+	for i := uint64(0); i < cnt; i++ {
+		name, err := readBinaryString(r)
+		if err != nil {
+			return err
+		}
+
+		oldStates = append(oldStates, name)
+	}
+
+	stateTime, err := binary.ReadVarint(r)
+	if err != nil {
+		return err
+	}
+
+	cnt, err = readBinaryCount(r)
+	if err != nil {
+		return err
+	}
+
+	mapState2EntryCount := make(map[string]uint32)
+	for i := uint64(0); i < cnt; i++ {
+		name, err := readBinaryString(r)
+		if err != nil {
+			return err
+		}
+
+		entryCount, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+
+		mapState2EntryCount[name] = uint32(entryCount)
+	}
+
+	mapKey2Value, err := unmarshalBlackboard(r)
+	if err != nil {
+		return err
+	}
+
+	totalTime := int64(0)
+	var history []HistoryEntry
+	delayedEvents := make([]*FSMDelayedEvent, 0)
+	mapEvt2LastUse := make(map[string]int64)
+	firedWatchdogs := make([]string, 0)
+	if version >= 2 {
+		totalTime, err = binary.ReadVarint(r)
+		if err != nil {
+			return err
+		}
+
+		history, err = unmarshalHistory(r)
+		if err != nil {
+			return err
+		}
+
+		delayedEvents, err = unmarshalDelayedEvents(r)
+		if err != nil {
+			return err
+		}
+
+		mapEvt2LastUse, err = unmarshalLastUse(r)
+		if err != nil {
+			return err
+		}
+
+		firedWatchdogs, err = unmarshalFiredWatchdogs(r)
+		if err != nil {
+			return err
+		}
+	}
+
+	if r.Len() != 0 {
+		return ErrBinaryData
+	}
+
+	f.lockData()
+	f.state = state
+	f.oldStates = oldStates
+	f.stateTime = stateTime
+	f.mapState2EntryCount = mapState2EntryCount
+	f.totalTime = totalTime
+	f.history = history
+	f.delayedEvents = delayedEvents
+	f.mapEvt2LastUse = mapEvt2LastUse
+	f.setFiredWatchdogs(firedWatchdogs)
+	f.generation++
+	f.unlockData()
+
+	bb := f.GetBlackboard()
+	if bb == nil {
+		bb = NewBlackboard()
+		f.SetBlackboard(bb)
+	}
+
+	bb.Clear()
+	for key, value := range mapKey2Value {
+		bb.Set(key, value)
+	}
+
+	return nil
+}
+
+func unmarshalHistory(r *bytes.Reader) ([]HistoryEntry, error) {
+	cnt, err := readBinaryCount(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var history []HistoryEntry
+	for i := uint64(0); i < cnt; i++ {
+		h := HistoryEntry{}
+		h.From, err = readBinaryString(r)
+		if err != nil {
+			return nil, err
+		}
+
+		h.To, err = readBinaryString(r)
+		if err != nil {
+			return nil, err
+		}
+
+		h.Event, err = readBinaryString(r)
+		if err != nil {
+			return nil, err
+		}
+
+		h.Time, err = binary.ReadVarint(r)
+		if err != nil {
+			return nil, err
+		}
+
+		history = append(history, h)
+	}
+
+	return history, nil
+}
+
+func unmarshalDelayedEvents(r *bytes.Reader) ([]*FSMDelayedEvent, error) {
+	cnt, err := readBinaryCount(r)
+	if err != nil {
+		return nil, err
+	}
+
+	delayedEvents := make([]*FSMDelayedEvent, 0, cnt)
+	for i := uint64(0); i < cnt; i++ {
+		e := &FSMDelayedEvent{}
+		e.Event, err = readBinaryString(r)
+		if err != nil {
+			return nil, err
+		}
+
+		e.DueTime, err = binary.ReadVarint(r)
+		if err != nil {
+			return nil, err
+		}
+
+		paramCnt, err := readBinaryCount(r)
+		if err != nil {
+			return nil, err
+		}
+
+		for j := uint64(0); j < paramCnt; j++ {
+			param, err := readBinaryValue(r)
+			if err != nil {
+				return nil, err
+			}
+
+			e.Params = append(e.Params, param)
+		}
+
+		delayedEvents = append(delayedEvents, e)
+	}
+
+	return delayedEvents, nil
+}
+
+func unmarshalLastUse(r *bytes.Reader) (map[string]int64, error) {
+	cnt, err := readBinaryCount(r)
+	if err != nil {
+		return nil, err
+	}
+
+	mapEvt2LastUse := make(map[string]int64)
+	for i := uint64(0); i < cnt; i++ {
+		evt, err := readBinaryString(r)
+		if err != nil {
+			return nil, err
+		}
+
+		lastUse, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, err
+		}
+
+		mapEvt2LastUse[evt] = lastUse
+	}
+
+	return mapEvt2LastUse, nil
+}
+
+func unmarshalFiredWatchdogs(r *bytes.Reader) ([]string, error) {
+	cnt, err := readBinaryCount(r)
+	if err != nil {
+		return nil, err
+	}
+
+	firedWatchdogs := make([]string, 0, cnt)
+	for i := uint64(0); i < cnt; i++ {
+		name, err := readBinaryString(r)
+		if err != nil {
+			return nil, err
+		}
+
+		firedWatchdogs = append(firedWatchdogs, name)
+	}
+
+	return firedWatchdogs, nil
+}
+
+func (f *FSM) marshalBlackboard(buf *bytes.Buffer) {
+	if f.blackboard == nil {
+		writeBinaryUvarint(buf, 0)
+		return
+	}
+
+	mapKey2Value := f.blackboard.Snapshot()
+	keys := make([]string, 0, len(mapKey2Value))
+	for key, value := range mapKey2Value {
+		if isBinaryPrimitive(value) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+	writeBinaryUvarint(buf, uint64(len(keys)))
+	for _, key := range keys {
+		writeBinaryString(buf, key)
+		writeBinaryValue(buf, mapKey2Value[key])
+	}
+}
+
+func unmarshalBlackboard(r *bytes.Reader) (map[string]interface{}, error) {
+	cnt, err := readBinaryCount(r)
+	if err != nil {
+		return nil, err
+	}
+
+	mapKey2Value := make(map[string]interface{})
+	for i := uint64(0); i < cnt; i++ {
+		key, err := readBinaryString(r)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := readBinaryValue(r)
+		if err != nil {
+			return nil, err
+		}
+
+		mapKey2Value[key] = value
+	}
+
+	return mapKey2Value, nil
+}
+
+func isBinaryPrimitive(value interface{}) bool {
+	switch value.(type) {
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, string:
+		return true
+	}
+
+	return false
+}
+
+func areBinaryPrimitives(values []interface{}) bool {
+	for _, value := range values {
+		if !isBinaryPrimitive(value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func writeBinaryUvarint(buf *bytes.Buffer, v uint64) {
+	tmp := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(tmp, v)
+	buf.Write(tmp[:n])
+}
+
+func writeBinaryVarint(buf *bytes.Buffer, v int64) {
+	tmp := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutVarint(tmp, v)
+	buf.Write(tmp[:n])
+}
+
+func writeBinaryString(buf *bytes.Buffer, str string) {
+	writeBinaryUvarint(buf, uint64(len(str)))
+	buf.WriteString(str)
+}
+
+func writeBinaryValue(buf *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case bool:
+		buf.WriteByte(bbValueBool)
+		if v {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	case int:
+		buf.WriteByte(bbValueInt)
+		writeBinaryVarint(buf, int64(v))
+	case int8:
+		buf.WriteByte(bbValueInt8)
+		writeBinaryVarint(buf, int64(v))
+	case int16:
+		buf.WriteByte(bbValueInt16)
+		writeBinaryVarint(buf, int64(v))
+	case int32:
+		buf.WriteByte(bbValueInt32)
+		writeBinaryVarint(buf, int64(v))
+	case int64:
+		buf.WriteByte(bbValueInt64)
+		writeBinaryVarint(buf, v)
+	case uint:
+		buf.WriteByte(bbValueUint)
+		writeBinaryUvarint(buf, uint64(v))
+	case uint8:
+		buf.WriteByte(bbValueUint8)
+		writeBinaryUvarint(buf, uint64(v))
+	case uint16:
+		buf.WriteByte(bbValueUint16)
+		writeBinaryUvarint(buf, uint64(v))
+	case uint32:
+		buf.WriteByte(bbValueUint32)
+		writeBinaryUvarint(buf, uint64(v))
+	case uint64:
+		buf.WriteByte(bbValueUint64)
+		writeBinaryUvarint(buf, v)
+	case float32:
+		buf.WriteByte(bbValueFloat32)
+		writeBinaryUvarint(buf, uint64(math.Float32bits(v)))
+	case float64:
+		buf.WriteByte(bbValueFloat64)
+		writeBinaryUvarint(buf, math.Float64bits(v))
+	case string:
+		buf.WriteByte(bbValueString)
+		writeBinaryString(buf, v)
+	}
+}
+
+// readBinaryCount reads the count of the elements after, each element takes
+// one byte at least so a count over the unread bytes is invalid.
+func readBinaryCount(r *bytes.Reader) (uint64, error) {
+	cnt, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, err
+	}
+
+	if cnt > uint64(r.Len()) {
+		return 0, ErrBinaryData
+	}
+
+	return cnt, nil
+}
+
+func readBinaryString(r *bytes.Reader) (string, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+
+	if size > uint64(r.Len()) {
+		return "", io.ErrUnexpectedEOF
+	}
+
+	data := make([]byte, size)
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+func readBinaryValue(r *bytes.Reader) (interface{}, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tag {
+	case bbValueBool:
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		return b != 0, nil
+
+	case bbValueInt, bbValueInt8, bbValueInt16, bbValueInt32, bbValueInt64:
+		v, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, err
+		}
+
+		switch tag {
+		case bbValueInt:
+			return int(v), nil
+		case bbValueInt8:
+			return int8(v), nil
+		case bbValueInt16:
+			return int16(v), nil
+		case bbValueInt32:
+			return int32(v), nil
+		}
+
+		return v, nil
+
+	case bbValueUint, bbValueUint8, bbValueUint16, bbValueUint32, bbValueUint64, bbValueFloat32, bbValueFloat64:
+		v, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+
+		switch tag {
+		case bbValueUint:
+			return uint(v), nil
+		case bbValueUint8:
+			return uint8(v), nil
+		case bbValueUint16:
+			return uint16(v), nil
+		case bbValueUint32:
+			return uint32(v), nil
+		case bbValueFloat32:
+			return math.Float32frombits(uint32(v)), nil
+		case bbValueFloat64:
+			return math.Float64frombits(v), nil
+		}
+
+		return v, nil
+
+	case bbValueString:
+		return readBinaryString(r)
+	}
+
+	return nil, ErrBinaryData
+}
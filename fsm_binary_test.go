@@ -0,0 +1,255 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import "testing"
+
+func TestFSMMarshalBinaryRoundTrip(t *testing.T) {
+	f := newTestFSM(t, nil, "a", "b")
+	f.AddTransition("a", "go", "b", "")
+	f.Start("a")
+	f.Trigger("go")
+	f.Update(15)
+	f.GetBlackboard().Set("hp", 10)
+	f.GetBlackboard().Set("name", "orc")
+	f.GetBlackboard().Set("path", []int{1, 2})
+
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := newTestFSM(t, nil, "a", "b")
+	err = g.UnmarshalBinary(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g.GetCurState() != "b" || g.GetStateTime() != 15 || g.GetStateEntryCount("b") != 1 {
+		t.Fatalf("state = %s, time = %d, entries = %d", g.GetCurState(), g.GetStateTime(), g.GetStateEntryCount("b"))
+	}
+
+	bb := g.GetBlackboard()
+	hp, _ := bb.Get("hp")
+	name, _ := bb.Get("name")
+	if hp != 10 || name != "orc" || bb.Has("path") {
+		t.Fatalf("blackboard hp = %v, name = %v, has path = %v", hp, name, bb.Has("path"))
+	}
+
+	err = g.PopState()
+	if err != nil || g.GetCurState() != "a" {
+		t.Fatalf("pop state = %s, %v", g.GetCurState(), err)
+	}
+}
+
+func TestFSMUnmarshalBinaryInvalid(t *testing.T) {
+	cases := []struct {
+		name string
+		data string
+		err  error
+	}{
+		{"empty", "", ErrBinaryMagic},
+		{"bad magic", "XFSM\x01", ErrBinaryMagic},
+		{"bad version", "YFSM\x7f", ErrBinaryVersion},
+		{"huge old state count", "YFSM\x01\x00\xff\xff\xff\xff\xff\xff\xff\xff\x3f", ErrBinaryData},
+		{"old state count over data", "YFSM\x01\x00\x05\x00", ErrBinaryData},
+		{"huge entry count", "YFSM\x01\x00\x00\x00\xff\xff\xff\xff\x0f", ErrBinaryData},
+		{"huge blackboard count", "YFSM\x01\x00\x00\x00\x00\xff\xff\xff\xff\x0f", ErrBinaryData},
+		{"bad value tag", "YFSM\x01\x00\x00\x00\x00\x01\x01k\x7f", ErrBinaryData},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := newTestFSM(t, nil, "a")
+			err := f.UnmarshalBinary([]byte(c.data))
+			if err != c.err {
+				t.Fatalf("err = %v, want %v", err, c.err)
+			}
+		})
+	}
+}
+
+func TestFSMUnmarshalBinaryTruncated(t *testing.T) {
+	f := newTestFSM(t, nil, "a", "b")
+	f.AddTransition("a", "go", "b", "")
+	f.Start("a")
+	f.Trigger("go")
+	f.GetBlackboard().Set("hp", 10)
+	f.GetBlackboard().Set("name", "orc")
+
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < len(data); i++ {
+		g := newTestFSM(t, nil, "a", "b")
+		if g.UnmarshalBinary(data[:i]) == nil {
+			t.Fatalf("data truncated to %d bytes decoded", i)
+		}
+	}
+}
+
+func TestFSMUnmarshalBinaryClearsBlackboard(t *testing.T) {
+	f := newTestFSM(t, nil, "a")
+	f.Start("a")
+	f.GetBlackboard().Set("hp", 10)
+
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := newTestFSM(t, nil, "a")
+	bb := NewBlackboard()
+	bb.Set("stale", true)
+	g.SetBlackboard(bb)
+	err = g.UnmarshalBinary(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bb.Has("stale") {
+		t.Fatal("stale key survived the restore")
+	}
+
+	v, _ := bb.Get("hp")
+	if v != 10 {
+		t.Fatalf("hp = %v, want 10", v)
+	}
+}
+
+func newTestRuntimeFSM(t testing.TB) *FSM {
+	f := newTestFSM(t, nil, "a", "b")
+	f.AddTransition("a", "go", "b", "")
+	f.AddTransition("b", "back", "a", "")
+	f.RecordHistory(true)
+	f.SetEventCooldown("go", 100)
+	f.SetStateWatchdog("b", 10, func(fsmId uint32, state string) {})
+	return f
+}
+
+func TestFSMMarshalBinaryRuntime(t *testing.T) {
+	f := newTestRuntimeFSM(t)
+	f.Start("a")
+	f.Update(30)
+	f.Trigger("go")
+	f.Update(20)
+	f.TriggerDelayed("back", 50, 1, "x")
+
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := newTestRuntimeFSM(t)
+	err = g.UnmarshalBinary(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := f.SaveRuntime()
+	got := g.SaveRuntime()
+	if got.TotalTime != 50 || got.TotalTime != want.TotalTime {
+		t.Fatalf("total time = %d, want %d", got.TotalTime, want.TotalTime)
+	}
+
+	if len(got.History) != len(want.History) || got.History[len(got.History)-1] != want.History[len(want.History)-1] {
+		t.Fatalf("history = %v, want %v", got.History, want.History)
+	}
+
+	if len(got.Delayed) != 1 || got.Delayed[0].DueTime != 100 || len(got.Delayed[0].Params) != 2 || got.Delayed[0].Params[1] != "x" {
+		t.Fatalf("delayed = %v", got.Delayed)
+	}
+
+	if got.EventLastUse["go"] != 30 {
+		t.Fatalf("last use = %v", got.EventLastUse)
+	}
+
+	if len(got.FiredWatchdogs) != 1 || got.FiredWatchdogs[0] != "b" {
+		t.Fatalf("fired watchdogs = %v", got.FiredWatchdogs)
+	}
+
+	g.Update(50)
+	if g.GetCurState() != "a" {
+		t.Fatalf("delayed event lost, state = %s", g.GetCurState())
+	}
+
+	res, _ := g.TriggerEx("go")
+	if res != TRIGGER_RESULT_COOLDOWN {
+		t.Fatalf("result = %v, want cooldown", res)
+	}
+}
+
+func TestFSMUnmarshalBinaryVersion1(t *testing.T) {
+	g := newTestRuntimeFSM(t)
+	g.Start("a")
+	g.Update(30)
+	g.Trigger("go")
+	g.TriggerDelayed("back", 50)
+
+	// state b, no pushed state, 40ms in state, no entry count, empty blackboard
+	err := g.UnmarshalBinary([]byte("YFSM\x01\x01b\x00\x50\x00\x00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := g.SaveRuntime()
+	if g.GetCurState() != "b" || g.GetStateTime() != 40 {
+		t.Fatalf("state = %s, time = %d", g.GetCurState(), g.GetStateTime())
+	}
+
+	if got.TotalTime != 0 || len(got.History) != 0 || len(got.Delayed) != 0 || len(got.EventLastUse) != 0 || len(got.FiredWatchdogs) != 0 {
+		t.Fatalf("version 2 fields not cleared: %+v", got)
+	}
+}
+
+func TestFSMMarshalBinaryUnencodableParam(t *testing.T) {
+	f := newTestRuntimeFSM(t)
+	f.Start("a")
+	f.TriggerDelayed("go", 10, struct{}{})
+	f.TriggerDelayed("back", 20, 3)
+
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := newTestRuntimeFSM(t)
+	err = g.UnmarshalBinary(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g.CancelDelayed("go") || !g.CancelDelayed("back") {
+		t.Fatalf("only the delayed event with encodable params should be restored")
+	}
+}
+
+func BenchmarkFSMMarshalBinary(b *testing.B) {
+	f := newTestRuntimeFSM(b)
+	f.Start("a")
+	f.Trigger("go")
+	f.GetBlackboard().Set("hp", 10)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		f.MarshalBinary()
+	}
+}
+
+func BenchmarkFSMUnmarshalBinary(b *testing.B) {
+	f := newTestRuntimeFSM(b)
+	f.Start("a")
+	f.Trigger("go")
+	f.GetBlackboard().Set("hp", 10)
+	data, _ := f.MarshalBinary()
+
+	g := newTestRuntimeFSM(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		g.UnmarshalBinary(data)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import (
+	"fmt"
+	"strings"
+)
+
+type FSMBuildError struct {
+	Errs []error
+}
+
+func (e *FSMBuildError) Error() string {
+	msgs := make([]string, 0, len(e.Errs))
+	for _, err := range e.Errs {
+		msgs = append(msgs, err.Error())
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+type fsmBuilderState struct {
+	name string
+	stat FSMState
+}
+
+type fsmBuilderAction struct {
+	name string
+	act  FSMAction
+}
+
+// FSMBuilder collects the definition of a FSM, Build validates it and
+// returns all the errors found as a *FSMBuildError.
+type FSMBuilder struct {
+	id          uint32
+	states      []*fsmBuilderState
+	actions     []*fsmBuilderAction
+	transitions []*FSMTransition
+	initState   string
+}
+
+func NewFSMBuilder(id uint32) *FSMBuilder {
+	return &FSMBuilder{
+		id:          id,
+		states:      make([]*fsmBuilderState, 0),
+		actions:     make([]*fsmBuilderAction, 0),
+		transitions: make([]*FSMTransition, 0),
+		initState:   "",
+	}
+}
+
+func (b *FSMBuilder) State(name string, stat FSMState) *FSMBuilder {
+	b.states = append(b.states, &fsmBuilderState{name: name, stat: stat})
+	return b
+}
+
+func (b *FSMBuilder) Action(name string, act FSMAction) *FSMBuilder {
+	b.actions = append(b.actions, &fsmBuilderAction{name: name, act: act})
+	return b
+}
+
+func (b *FSMBuilder) Transition(from string, evt string, to string, action string) *FSMBuilder {
+	b.transitions = append(b.transitions, NewFSMTransition(from, evt, to, action))
+	return b
+}
+
+func (b *FSMBuilder) Initial(name string) *FSMBuilder {
+	b.initState = name
+	return b
+}
+
+func (b *FSMBuilder) Build() (*FSM, error) {
+	errs := make([]error, 0)
+	f := NewFSM(b.id)
+	for _, s := range b.states {
+		err := f.AddState(s.name, s.stat)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("state %s: %w", s.name, err))
+		}
+	}
+
+	for _, a := range b.actions {
+		err := f.AddAction(a.name, a.act)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("action %s: %w", a.name, err))
+		}
+	}
+
+	for _, tran := range b.transitions {
+		err := b.checkTransition(f, tran)
+		if err == nil {
+			err = f.AddTransition(tran.From, tran.Event, tran.To, tran.Action)
+		}
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("transition %s -%s-> %s: %w", tran.From, tran.Event, tran.To, err))
+		}
+	}
+
+	if len(b.initState) != 0 {
+		_, ok := f.GetState(b.initState)
+		if ok {
+			f.SetInitialState(b.initState)
+		} else {
+			errs = append(errs, fmt.Errorf("initial state %s: %w", b.initState, ErrStatNotExist))
+		}
+	}
+
+	if len(errs) != 0 {
+		return nil, &FSMBuildError{Errs: errs}
+	}
+
+	return f, nil
+}
+
+func (b *FSMBuilder) checkTransition(f *FSM, tran *FSMTransition) error {
+	_, ok := f.GetState(tran.From)
+	if !ok {
+		return ErrFromStatNotExist
+	}
+
+	_, ok = f.GetState(tran.To)
+	if !ok {
+		return ErrToStatNotExist
+	}
+
+	if len(tran.Action) != 0 {
+		_, ok = f.GetAction(tran.Action)
+		if !ok {
+			return fmt.Errorf("action %s: %w", tran.Action, ErrActNotExist)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFSMBuilderBuild(t *testing.T) {
+	cases := []struct {
+		name    string
+		from    string
+		to      string
+		action  string
+		initial string
+		err     error
+		msg     string
+	}{
+		{"valid", "a", "b", "act", "a", nil, ""},
+		{"unknown from", "x", "b", "", "a", ErrFromStatNotExist, "transition x -go-> b"},
+		{"unknown to", "a", "x", "", "a", ErrToStatNotExist, "transition a -go-> x"},
+		{"unknown action", "a", "b", "missing", "a", ErrActNotExist, "action missing"},
+		{"unknown initial", "a", "b", "", "x", ErrStatNotExist, "initial state x"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f, err := NewFSMBuilder(1).
+				State("a", &testFsmState{name: "a"}).
+				State("b", &testFsmState{name: "b"}).
+				Action("act", &testFsmAction{name: "act", bResult: true}).
+				Transition(c.from, "go", c.to, c.action).
+				Initial(c.initial).
+				Build()
+
+			if c.err == nil {
+				if err != nil || f == nil {
+					t.Fatalf("err = %v", err)
+				}
+
+				return
+			}
+
+			var buildErr *FSMBuildError
+			if !errors.As(err, &buildErr) || len(buildErr.Errs) != 1 {
+				t.Fatalf("err = %v, want one build error", err)
+			}
+
+			if !errors.Is(buildErr.Errs[0], c.err) {
+				t.Fatalf("err = %v, want %v", buildErr.Errs[0], c.err)
+			}
+
+			if !strings.Contains(err.Error(), c.msg) {
+				t.Fatalf("err %q doesn't name %q", err.Error(), c.msg)
+			}
+		})
+	}
+}
+
+func TestFSMBuilderAccumulatesErrors(t *testing.T) {
+	_, err := NewFSMBuilder(1).
+		State("a", &testFsmState{name: "a"}).
+		State("a", &testFsmState{name: "a"}).
+		Transition("a", "go", "x", "").
+		Transition("y", "back", "a", "").
+		Build()
+
+	var buildErr *FSMBuildError
+	if !errors.As(err, &buildErr) {
+		t.Fatalf("err = %v, want a build error", err)
+	}
+
+	want := []error{ErrStatExist, ErrToStatNotExist, ErrFromStatNotExist}
+	if len(buildErr.Errs) != len(want) {
+		t.Fatalf("errs = %v, want %v", buildErr.Errs, want)
+	}
+
+	for i := range want {
+		if !errors.Is(buildErr.Errs[i], want[i]) {
+			t.Fatalf("errs[%d] = %v, want %v", i, buildErr.Errs[i], want[i])
+		}
+	}
+}
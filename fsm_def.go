@@ -0,0 +1,98 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import "encoding/json"
+
+type FSMTransitionDef struct {
+	From   string `json:"from"`
+	Event  string `json:"event"`
+	To     string `json:"to"`
+	Action string `json:"action"`
+}
+
+type FSMDef struct {
+	ID          uint32              `json:"id"`
+	States      []string            `json:"states"`
+	Transitions []*FSMTransitionDef `json:"transitions"`
+	Initial     string              `json:"initial"`
+}
+
+type FSMStateFactory func(name string) FSMState
+type FSMActionFactory func(name string) FSMAction
+
+// LoadFSMFromJSON builds a FSM from a JSON encoded FSMDef. The factories
+// supply the behavior of each named state and transition action.
+func LoadFSMFromJSON(data []byte, stateFactory FSMStateFactory, actionFactory FSMActionFactory) (*FSM, error) {
+	def := &FSMDef{}
+	err := json.Unmarshal(data, def)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewFSMFromDef(def, stateFactory, actionFactory)
+}
+
+func NewFSMFromDef(def *FSMDef, stateFactory FSMStateFactory, actionFactory FSMActionFactory) (*FSM, error) {
+	f := NewFSM(def.ID)
+	for _, name := range def.States {
+		var stat FSMState = nil
+		if stateFactory != nil {
+			stat = stateFactory(name)
+		}
+
+		err := f.AddState(name, stat)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, tranDef := range def.Transitions {
+		_, ok := f.GetState(tranDef.From)
+		if !ok {
+			return nil, ErrFromStatNotExist
+		}
+
+		_, ok = f.GetState(tranDef.To)
+		if !ok {
+			return nil, ErrToStatNotExist
+		}
+
+		err := f.AddTransition(tranDef.From, tranDef.Event, tranDef.To, tranDef.Action)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(tranDef.Action) == 0 {
+			continue
+		}
+
+		_, ok = f.GetAction(tranDef.Action)
+		if ok {
+			continue
+		}
+
+		var act FSMAction = nil
+		if actionFactory != nil {
+			act = actionFactory(tranDef.Action)
+		}
+
+		err = f.AddAction(tranDef.Action, act)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(def.Initial) != 0 {
+		_, ok := f.GetState(def.Initial)
+		if !ok {
+			return nil, ErrStatNotExist
+		}
+
+		f.SetInitialState(def.Initial)
+	}
+
+	return f, nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import "testing"
+
+func newTestStateFactory() FSMStateFactory {
+	return func(name string) FSMState {
+		return &testFsmState{name: name}
+	}
+}
+
+func newTestActionFactory() FSMActionFactory {
+	return func(name string) FSMAction {
+		return &testFsmAction{name: name, bResult: true}
+	}
+}
+
+func TestLoadFSMFromJSON(t *testing.T) {
+	data := []byte(`{
+		"id": 3,
+		"states": ["idle", "run"],
+		"transitions": [
+			{"from": "idle", "event": "go", "to": "run", "action": "speed"},
+			{"from": "run", "event": "stop", "to": "idle"}
+		],
+		"initial": "idle"
+	}`)
+
+	f, err := LoadFSMFromJSON(data, newTestStateFactory(), newTestActionFactory())
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	if f.GetID() != 3 || f.GetInitialState() != "idle" {
+		t.Fatalf("id %d initial %s, want 3 idle", f.GetID(), f.GetInitialState())
+	}
+
+	trans := []struct {
+		from   string
+		evt    string
+		to     string
+		action string
+	}{
+		{"idle", "go", "run", "speed"},
+		{"run", "stop", "idle", ""},
+	}
+
+	for _, want := range trans {
+		tran, ok := f.GetTransition(want.from, want.evt)
+		if !ok {
+			t.Fatalf("transition %s %s not loaded", want.from, want.evt)
+		}
+
+		if tran.To != want.to || tran.Action != want.action {
+			t.Fatalf("transition %s %s = %s %s, want %s %s", want.from, want.evt, tran.To, tran.Action, want.to, want.action)
+		}
+	}
+
+	_, ok := f.GetAction("speed")
+	if !ok {
+		t.Fatalf("action speed not loaded")
+	}
+
+	f.Start("")
+	f.Trigger("go")
+	if f.GetCurState() != "run" {
+		t.Fatalf("state %s, want run", f.GetCurState())
+	}
+}
+
+func TestLoadFSMFromJSONInvalid(t *testing.T) {
+	cases := []struct {
+		name    string
+		data    string
+		wantErr error
+	}{
+		{"unknown from", `{"states": ["a"], "transitions": [{"from": "x", "event": "go", "to": "a"}]}`, ErrFromStatNotExist},
+		{"unknown to", `{"states": ["a"], "transitions": [{"from": "a", "event": "go", "to": "x"}]}`, ErrToStatNotExist},
+		{"unknown initial", `{"states": ["a"], "initial": "x"}`, ErrStatNotExist},
+		{"duplicate state", `{"states": ["a", "a"]}`, ErrStatExist},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := LoadFSMFromJSON([]byte(c.data), newTestStateFactory(), newTestActionFactory())
+			if err != c.wantErr {
+				t.Fatalf("load = %v, want %v", err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadFSMFromJSONSyntax(t *testing.T) {
+	_, err := LoadFSMFromJSON([]byte(`{"states": [`), newTestStateFactory(), newTestActionFactory())
+	if err == nil {
+		t.Fatalf("load accepted malformed json")
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import "log"
+
+//========================
+//    FSMDelayedEvent
+//========================
+// FSMDelayedEvent is an event scheduled by TriggerDelayed, DueTime is
+// compared with the sum of the dt given to Update.
+type FSMDelayedEvent struct {
+	Event   string
+	Params  []interface{}
+	DueTime int64
+}
+
+// TriggerDelayed schedules evt to be triggered by the Update after delayMs
+// of Update time. Scheduling an event again supersedes the previous one.
+// The events due in the same Update are triggered in due order, the errors
+// are logged. An event scheduled while the due events are triggered waits
+// for the next Update, even with a delayMs 0.
+func (f *FSM) TriggerDelayed(evt string, delayMs int64, param ...interface{}) error {
+	if len(evt) == 0 {
+		return ErrEvtEmpty
+	}
+
+	f.lockData()
+	defer f.unlockData()
+
+	f.removeDelayedEvent(evt)
+	f.delayedEvents = append(f.delayedEvents, &FSMDelayedEvent{
+		Event:   evt,
+		Params:  param,
+		DueTime: f.totalTime + delayMs,
+	})
+
+	return nil
+}
+
+// CancelDelayed cancels the scheduled evt, false if it isn't scheduled.
+func (f *FSM) CancelDelayed(evt string) bool {
+	f.lockData()
+	defer f.unlockData()
+
+	return f.removeDelayedEvent(evt)
+}
+
+func (f *FSM) removeDelayedEvent(evt string) bool {
+	for i, e := range f.delayedEvents {
+		if e.Event == evt {
+			f.delayedEvents = append(f.delayedEvents[:i], f.delayedEvents[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+func (f *FSM) listDueDelayedEvents() map[*FSMDelayedEvent]bool {
+	f.rlockData()
+	defer f.runlockData()
+
+	mapEvt2Due := make(map[*FSMDelayedEvent]bool)
+	for _, e := range f.delayedEvents {
+		if e.DueTime <= f.totalTime {
+			mapEvt2Due[e] = true
+		}
+	}
+
+	return mapEvt2Due
+}
+
+// popDueDelayedEvent removes and returns the earliest event of mapEvt2Due
+// still scheduled.
+func (f *FSM) popDueDelayedEvent(mapEvt2Due map[*FSMDelayedEvent]bool) (*FSMDelayedEvent, bool) {
+	f.lockData()
+	defer f.unlockData()
+
+	idx := -1
+	for i, e := range f.delayedEvents {
+		if mapEvt2Due[e] && (idx < 0 || e.DueTime < f.delayedEvents[idx].DueTime) {
+			idx = i
+		}
+	}
+
+	if idx < 0 {
+		return nil, false
+	}
+
+	e := f.delayedEvents[idx]
+	f.delayedEvents = append(f.delayedEvents[:idx], f.delayedEvents[idx+1:]...)
+	return e, true
+}
+
+func (f *FSM) dispatchDelayedEvents() {
+	mapEvt2Due := f.listDueDelayedEvents()
+	if len(mapEvt2Due) == 0 {
+		return
+	}
+
+	for {
+		e, ok := f.popDueDelayedEvent(mapEvt2Due)
+		if !ok {
+			return
+		}
+
+		_, err := f.triggerEx(e.Event, e.Params...)
+		if err != nil {
+			log.Printf("fsm %d: trigger delayed event %s failed, %v", f.id, e.Event, err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const FSM_DOT_ANY_STATE = "*"
+
+// ExportFSMDOT renders the FSM as a Graphviz digraph, transitions are
+// labeled "evt / action" and the current state is highlighted. Pattern
+// transitions are dotted, global transitions start from a dashed "*" node.
+func ExportFSMDOT(f *FSM) string {
+	f.rlockData()
+	defer f.runlockData()
+
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "digraph fsm_%d {\n", f.GetID())
+	sb.WriteString("\tnode [shape=ellipse];\n")
+
+	for _, name := range f.listStates() {
+		if name == f.state {
+			fmt.Fprintf(sb, "\t%q [style=filled, fillcolor=yellow];\n", name)
+		} else {
+			fmt.Fprintf(sb, "\t%q;\n", name)
+		}
+	}
+
+	for _, tran := range f.transitions {
+		if tran.Pattern {
+			fmt.Fprintf(sb, "\t%q -> %q [label=%q, style=dotted];\n", tran.From, tran.To, getFSMTransitionLabel(tran))
+		} else {
+			fmt.Fprintf(sb, "\t%q -> %q [label=%q];\n", tran.From, tran.To, getFSMTransitionLabel(tran))
+		}
+	}
+
+	if len(f.globalTransitions) > 0 {
+		fmt.Fprintf(sb, "\t%q [shape=box, style=dashed];\n", FSM_DOT_ANY_STATE)
+	}
+
+	for _, g := range f.globalTransitions {
+		label := getFSMTransitionLabel(g.tran)
+		except := make([]string, 0, len(g.mapExcept))
+		for name := range g.mapExcept {
+			except = append(except, name)
+		}
+
+		if len(except) > 0 {
+			sort.Strings(except)
+			label += "\nexcept " + strings.Join(except, ", ")
+		}
+
+		fmt.Fprintf(sb, "\t%q -> %q [label=%q, style=dashed];\n", FSM_DOT_ANY_STATE, g.tran.To, label)
+	}
+
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+func getFSMTransitionLabel(tran *FSMTransition) string {
+	if len(tran.Action) == 0 {
+		return tran.Event
+	}
+
+	return tran.Event + " / " + tran.Action
+}
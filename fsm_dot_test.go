@@ -0,0 +1,54 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExportFSMDOT(t *testing.T) {
+	f := newTestFSM(t, nil, "idle", "run", "dead")
+	f.AddAction("speed", &testFsmAction{name: "speed", bResult: true})
+	f.AddTransition("idle", "go", "run", "speed")
+	f.AddTransition("run", "stop", "idle", "")
+	f.AddGlobalTransition("die", "dead", "", "dead")
+	f.Start("run")
+
+	dot := ExportFSMDOT(f)
+	lines := []string{
+		"digraph fsm_1 {",
+		"\t\"idle\";",
+		"\t\"run\" [style=filled, fillcolor=yellow];",
+		"\t\"idle\" -> \"run\" [label=\"go / speed\"];",
+		"\t\"run\" -> \"idle\" [label=\"stop\"];",
+		"\t\"*\" [shape=box, style=dashed];",
+		"\t\"*\" -> \"dead\" [label=\"die\\nexcept dead\", style=dashed];",
+	}
+
+	for _, line := range lines {
+		if !strings.Contains(dot, line+"\n") {
+			t.Errorf("dot misses line %q:\n%s", line, dot)
+		}
+	}
+}
+
+func TestExportFSMDOTPattern(t *testing.T) {
+	f := newTestFSM(t, nil, "idle", "ui")
+	f.AddTransition("ui", "close", "idle", "")
+	f.AddPatternTransition("idle", "ui.*", "ui", "")
+
+	dot := ExportFSMDOT(f)
+	lines := []string{
+		"\t\"idle\" -> \"ui\" [label=\"ui.*\", style=dotted];",
+		"\t\"ui\" -> \"idle\" [label=\"close\"];",
+	}
+
+	for _, line := range lines {
+		if !strings.Contains(dot, line+"\n") {
+			t.Errorf("dot misses line %q:\n%s", line, dot)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+// RegisterStateID maps id to the state name for the id based API, the state
+// itself may be added before or after.
+func (f *FSM) RegisterStateID(id uint32, name string) error {
+	if len(name) == 0 {
+		return ErrNameLenZero
+	}
+
+	f.lockData()
+	defer f.unlockData()
+
+	_, ok := f.mapId2StateName[id]
+	if ok {
+		return ErrStatIDExist
+	}
+
+	f.mapId2StateName[id] = name
+	return nil
+}
+
+// RegisterEventID maps id to the event for the id based API.
+func (f *FSM) RegisterEventID(id uint32, evt string) error {
+	if len(evt) == 0 {
+		return ErrEvtEmpty
+	}
+
+	f.lockData()
+	defer f.unlockData()
+
+	_, ok := f.mapId2Event[id]
+	if ok {
+		return ErrEvtIDExist
+	}
+
+	f.mapId2Event[id] = evt
+	return nil
+}
+
+func (f *FSM) GetStateName(id uint32) (string, bool) {
+	f.rlockData()
+	defer f.runlockData()
+
+	name, ok := f.mapId2StateName[id]
+	return name, ok
+}
+
+func (f *FSM) GetEventName(id uint32) (string, bool) {
+	f.rlockData()
+	defer f.runlockData()
+
+	evt, ok := f.mapId2Event[id]
+	return evt, ok
+}
+
+// AddTransitionID adds the transition of the registered ids, it is the same
+// as AddTransition with their names.
+func (f *FSM) AddTransitionID(fromID uint32, evtID uint32, toID uint32, action string) error {
+	from, ok := f.GetStateName(fromID)
+	if !ok {
+		return ErrStatIDNotExist
+	}
+
+	evt, ok := f.GetEventName(evtID)
+	if !ok {
+		return ErrEvtIDNotExist
+	}
+
+	to, ok := f.GetStateName(toID)
+	if !ok {
+		return ErrStatIDNotExist
+	}
+
+	return f.AddTransition(from, evt, to, action)
+}
+
+// TriggerID triggers the event of the registered id.
+func (f *FSM) TriggerID(evtID uint32, param ...interface{}) error {
+	evt, ok := f.GetEventName(evtID)
+	if !ok {
+		return ErrEvtIDNotExist
+	}
+
+	return f.Trigger(evt, param...)
+}
@@ -0,0 +1,100 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import "strings"
+
+const FSM_PATTERN_WILDCARD = "*"
+
+// AddPatternTransition adds a transition from the state by the events
+// matching pattern, a pattern ending with "*" matches the events with the
+// prefix before it, e.g. "ui.*" matches "ui.open". The transitions of the
+// event itself, the global ones included, win over the pattern ones, and a
+// longer pattern wins over a shorter one. An event ending with "*" matches
+// no pattern.
+func (f *FSM) AddPatternTransition(from string, pattern string, to string, action string) error {
+	f.lockData()
+	defer f.unlockData()
+
+	if len(from) == 0 {
+		return ErrFromStatNotExist
+	}
+
+	if len(pattern) == 0 {
+		return ErrEvtEmpty
+	}
+
+	if len(to) == 0 {
+		return ErrToStatNotExist
+	}
+
+	tran := NewFSMTransition(from, pattern, to, action)
+	tran.Pattern = true
+	f.transitions = append(f.transitions, tran)
+	f.mapState2Patterns[from] = insertFSMTransition(f.mapState2Patterns[from], tran)
+	return nil
+}
+
+// RemovePatternTransition removes the first transition of pattern from the
+// state.
+func (f *FSM) RemovePatternTransition(from string, pattern string) {
+	f.lockData()
+	defer f.unlockData()
+
+	trans := f.mapState2Patterns[from]
+	for i, tran := range trans {
+		if tran.Event != pattern {
+			continue
+		}
+
+		if len(trans) == 1 {
+			delete(f.mapState2Patterns, from)
+		} else {
+			f.mapState2Patterns[from] = append(trans[:i:i], trans[i+1:]...)
+		}
+
+		f.removeFromTransitions(tran)
+		return
+	}
+}
+
+func (f *FSM) removeFromTransitions(tran *FSMTransition) {
+	for i, exist := range f.transitions {
+		if exist == tran {
+			f.transitions = append(f.transitions[:i], f.transitions[i+1:]...)
+			return
+		}
+	}
+}
+
+// findPatternTransition must be called with the data lock held.
+func (f *FSM) findPatternTransition(from string, evt string) (*FSMTransition, bool) {
+	var found *FSMTransition
+	foundLen := -1
+	for _, tran := range f.mapState2Patterns[from] {
+		if len(tran.Event) <= foundLen || !isFSMEventMatched(tran.Event, evt) {
+			continue
+		}
+
+		if f.isTransitionPassable(tran) {
+			found = tran
+			foundLen = len(tran.Event)
+		}
+	}
+
+	return found, found != nil
+}
+
+func isFSMEventMatched(pattern string, evt string) bool {
+	if strings.HasSuffix(evt, FSM_PATTERN_WILDCARD) {
+		return false
+	}
+
+	if !strings.HasSuffix(pattern, FSM_PATTERN_WILDCARD) {
+		return pattern == evt
+	}
+
+	return strings.HasPrefix(evt, strings.TrimSuffix(pattern, FSM_PATTERN_WILDCARD))
+}
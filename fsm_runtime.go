@@ -0,0 +1,147 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import "sort"
+
+// FSM_LOAD_FROM_STATE is the fromState of OnEnter called by RestoreRuntime.
+const FSM_LOAD_FROM_STATE = "@load"
+
+//========================
+//       FSMRuntime
+//========================
+// FSMRuntime is the live state of an FSM, without its structure. EventLastUse
+// is the total time of the last transition of the cooling events and
+// FiredWatchdogs the states whose watchdog fired in the current stay.
+type FSMRuntime struct {
+	State          string
+	OldStates      []string
+	StateTime      int64
+	TotalTime      int64
+	EntryCounts    map[string]uint32
+	History        []HistoryEntry
+	Delayed        []FSMDelayedEvent
+	EventLastUse   map[string]int64
+	FiredWatchdogs []string
+}
+
+// SaveRuntime returns a copy of the runtime of the FSM.
+func (f *FSM) SaveRuntime() FSMRuntime {
+	f.rlockData()
+	defer f.runlockData()
+
+	r := FSMRuntime{
+		State:          f.state,
+		OldStates:      append([]string{}, f.oldStates...),
+		StateTime:      f.stateTime,
+		TotalTime:      f.totalTime,
+		EntryCounts:    make(map[string]uint32, len(f.mapState2EntryCount)),
+		History:        nil,
+		Delayed:        make([]FSMDelayedEvent, 0, len(f.delayedEvents)),
+		EventLastUse:   make(map[string]int64, len(f.mapEvt2LastUse)),
+		FiredWatchdogs: f.listFiredWatchdogs(),
+	}
+
+	for name, cnt := range f.mapState2EntryCount {
+		r.EntryCounts[name] = cnt
+	}
+
+	if f.history != nil {
+		r.History = append([]HistoryEntry{}, f.history...)
+	}
+
+	for _, e := range f.delayedEvents {
+		c := *e
+		c.Params = append([]interface{}(nil), e.Params...)
+		r.Delayed = append(r.Delayed, c)
+	}
+
+	for evt, lastUse := range f.mapEvt2LastUse {
+		r.EventLastUse[evt] = lastUse
+	}
+
+	return r
+}
+
+// RestoreRuntime replaces the runtime of the FSM with r, the states must be
+// registered already. The current state isn't exited, OnEnter of the restored
+// state is called with FSM_LOAD_FROM_STATE, no action is done.
+func (f *FSM) RestoreRuntime(r FSMRuntime) error {
+	f.lockEvt()
+	defer f.unlockEvt()
+
+	var stat FSMState
+	if len(r.State) != 0 {
+		var ok bool
+		stat, ok = f.GetState(r.State)
+		if !ok {
+			return ErrStatNotExist
+		}
+	}
+
+	f.lockData()
+	f.state = r.State
+	f.oldStates = append([]string{}, r.OldStates...)
+	f.stateTime = r.StateTime
+	f.totalTime = r.TotalTime
+	f.mapState2EntryCount = make(map[string]uint32, len(r.EntryCounts))
+	for name, cnt := range r.EntryCounts {
+		f.mapState2EntryCount[name] = cnt
+	}
+
+	f.history = nil
+	if r.History != nil {
+		f.history = append([]HistoryEntry{}, r.History...)
+	}
+
+	f.delayedEvents = make([]*FSMDelayedEvent, 0, len(r.Delayed))
+	for i := range r.Delayed {
+		e := r.Delayed[i]
+		e.Params = append([]interface{}(nil), e.Params...)
+		f.delayedEvents = append(f.delayedEvents, &e)
+	}
+
+	f.mapEvt2LastUse = make(map[string]int64, len(r.EventLastUse))
+	for evt, lastUse := range r.EventLastUse {
+		f.mapEvt2LastUse[evt] = lastUse
+	}
+
+	f.setFiredWatchdogs(r.FiredWatchdogs)
+	f.generation++
+	f.unlockData()
+
+	if stat != nil {
+		stat.OnEnter(FSM_LOAD_FROM_STATE)
+	}
+
+	return nil
+}
+
+// listFiredWatchdogs returns the states whose watchdog fired in ascending
+// order, it must be called with the data lock held.
+func (f *FSM) listFiredWatchdogs() []string {
+	names := make([]string, 0)
+	for name, watchdog := range f.mapState2Watchdog {
+		if watchdog.bFired {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// setFiredWatchdogs marks the watchdogs of names fired and the others not,
+// it must be called with the data lock held.
+func (f *FSM) setFiredWatchdogs(names []string) {
+	mapName2Fired := make(map[string]bool, len(names))
+	for _, name := range names {
+		mapName2Fired[name] = true
+	}
+
+	for name, watchdog := range f.mapState2Watchdog {
+		watchdog.bFired = mapName2Fired[name]
+	}
+}
@@ -0,0 +1,2236 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testFsmState struct {
+	name string
+	log  *[]string
+	lck  *sync.Mutex
+}
+
+func (s *testFsmState) GetName() string {
+	return s.name
+}
+
+func (s *testFsmState) OnEnter(fromState string) {
+	s.record("enter " + s.name)
+}
+
+func (s *testFsmState) OnUpdate(dt int64) {
+}
+
+func (s *testFsmState) OnExit(toState string) {
+	s.record("exit " + s.name)
+}
+
+func (s *testFsmState) record(entry string) {
+	if s.log == nil {
+		return
+	}
+
+	if s.lck != nil {
+		s.lck.Lock()
+		defer s.lck.Unlock()
+	}
+
+	*s.log = append(*s.log, entry)
+}
+
+type testFsmAction struct {
+	name    string
+	bResult bool
+	calls   int
+}
+
+func (a *testFsmAction) GetName() string {
+	return a.name
+}
+
+func (a *testFsmAction) DoAction(evt string, param ...interface{}) bool {
+	a.calls++
+	return a.bResult
+}
+
+// testLogAction records its name in log and returns bResult.
+type testLogAction struct {
+	name    string
+	bResult bool
+	log     *[]string
+}
+
+func (a *testLogAction) GetName() string {
+	return a.name
+}
+
+func (a *testLogAction) DoAction(evt string, param ...interface{}) bool {
+	*a.log = append(*a.log, "action "+a.name)
+	return a.bResult
+}
+
+// newTestFSM returns an FSM with a blackboard and the states, the entering
+// and exiting are recorded in log.
+func newTestFSM(t testing.TB, log *[]string, states ...string) *FSM {
+	f := NewFSM(1)
+	f.SetBlackboard(NewBlackboard())
+	for _, name := range states {
+		err := f.AddState(name, &testFsmState{name: name, log: log})
+		if err != nil {
+			t.Fatalf("add state %s: %v", name, err)
+		}
+	}
+
+	return f
+}
+
+func TestFSMPatternTransition(t *testing.T) {
+	cases := []struct {
+		name string
+		evt  string
+		want string
+		err  error
+	}{
+		{"prefix", "ui.open", "ui", nil},
+		{"longer pattern", "ui.open.inventory", "inventory", nil},
+		{"specific event", "ui.close", "idle_close", nil},
+		{"no match", "combat.start", "idle", ErrTranNotExist},
+		{"literal pattern", "ui.*", "idle", ErrTranNotExist},
+		{"literal longer pattern", "ui.open.*", "idle", ErrTranNotExist},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := newTestFSM(t, nil, "idle", "ui", "inventory", "idle_close")
+			f.AddPatternTransition("idle", "ui.*", "ui", "")
+			f.AddPatternTransition("idle", "ui.open.*", "inventory", "")
+			f.AddTransition("idle", "ui.close", "idle_close", "")
+			f.Start("idle")
+
+			err := f.Trigger(c.evt)
+			if err != c.err {
+				t.Fatalf("err = %v, want %v", err, c.err)
+			}
+
+			if f.GetCurState() != c.want {
+				t.Fatalf("state = %s, want %s", f.GetCurState(), c.want)
+			}
+		})
+	}
+}
+
+func TestFSMPatternTransitionNotInEventMap(t *testing.T) {
+	f := newTestFSM(t, nil, "idle", "ui")
+	f.AddPatternTransition("idle", "ui.*", "ui", "")
+
+	_, ok := f.GetTransition("idle", "ui.*")
+	if ok {
+		t.Fatal("pattern found as an event transition")
+	}
+
+	f.RemoveTransition("idle", "ui.*")
+	f.Start("idle")
+	if err := f.Trigger("ui.open"); err != nil {
+		t.Fatalf("RemoveTransition removed the pattern: %v", err)
+	}
+
+	f.Start("idle")
+	f.RemovePatternTransition("idle", "ui.*")
+	if err := f.Trigger("ui.open"); err != ErrTranNotExist {
+		t.Fatalf("err = %v, want %v", err, ErrTranNotExist)
+	}
+
+	if n := len(f.ListTransitions()); n != 0 {
+		t.Fatalf("%d transitions left", n)
+	}
+}
+
+func TestFSMPatternTransitionConcurrent(t *testing.T) {
+	f := newTestFSM(t, nil, "idle", "ui")
+	f.SetThreadSafe(true)
+	f.AddPatternTransition("idle", "ui.*", "ui", "")
+	f.AddPatternTransition("ui", "idle.*", "idle", "")
+	f.Start("idle")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		var wg sync.WaitGroup
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 2000; i++ {
+					f.Trigger("ui.open")
+					f.Trigger("idle.back")
+				}
+			}()
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				f.SetInitialState("idle")
+				f.GetCurState()
+			}
+		}()
+
+		wg.Wait()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("deadlock between Trigger and SetInitialState")
+	}
+}
+
+// TestFSMPatternTransitionQueuedWriter queues a writer while Trigger holds the
+// read lock in findTransition, the pattern lookup must not lock again.
+func TestFSMPatternTransitionQueuedWriter(t *testing.T) {
+	f := newTestFSM(t, nil, "idle", "ui", "menu")
+	f.SetThreadSafe(true)
+	f.AddPatternTransition("idle", "ui.*", "ui", "")
+	f.AddGlobalTransition("ui.open", "menu", "")
+
+	writerDone := make(chan struct{})
+	guard := func(bb *Blackboard) bool {
+		go func() {
+			f.SetInitialState("idle")
+			close(writerDone)
+		}()
+
+		time.Sleep(20 * time.Millisecond)
+		return false
+	}
+
+	for _, tran := range f.globalTransitions {
+		tran.tran.Guard = guard
+	}
+
+	f.Start("idle")
+
+	done := make(chan error)
+	go func() {
+		done <- f.Trigger("ui.open")
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil || f.GetCurState() != "ui" {
+			t.Fatalf("err = %v, state = %s", err, f.GetCurState())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("deadlock between Trigger and a queued writer")
+	}
+
+	<-writerDone
+}
+
+func TestFSMRuntimeCooldownAndWatchdog(t *testing.T) {
+	newFSM := func() (*FSM, *int) {
+		stuck := 0
+		f := newTestFSM(t, nil, "a", "b")
+		f.AddTransition("a", "go", "b", "")
+		f.AddTransition("b", "back", "a", "")
+		f.SetEventCooldown("go", 100)
+		f.SetStateWatchdog("b", 10, func(fsmId uint32, state string) {
+			stuck++
+		})
+
+		return f, &stuck
+	}
+
+	f, _ := newFSM()
+	f.Start("a")
+	f.Trigger("go")
+	f.Update(20)
+	r := f.SaveRuntime()
+
+	g, stuck := newFSM()
+	err := g.RestoreRuntime(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g.Update(10)
+	if *stuck != 0 {
+		t.Fatal("restored watchdog fired again")
+	}
+
+	g.Trigger("back")
+	res, _ := g.TriggerEx("go")
+	if res != TRIGGER_RESULT_COOLDOWN {
+		t.Fatalf("result = %v, want cooldown", res)
+	}
+}
+
+func TestFSMTriggerEx(t *testing.T) {
+	cases := []struct {
+		name    string
+		evt     string
+		bResult bool
+		want    TriggerResult
+		wantErr error
+		state   string
+	}{
+		{"transitioned", "go", true, TRIGGER_RESULT_TRANSITIONED, nil, "b"},
+		{"action vetoed", "go", false, TRIGGER_RESULT_ACTION_VETOED, nil, "a"},
+		{"no transition", "stay", true, TRIGGER_RESULT_NO_TRANSITION, ErrTranNotExist, "a"},
+		{"empty event", "", true, TRIGGER_RESULT_NO_TRANSITION, ErrEvtEmpty, "a"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := newTestFSM(t, nil, "a", "b")
+			f.AddAction("act", &testFsmAction{name: "act", bResult: c.bResult})
+			f.AddTransition("a", "go", "b", "act")
+			f.Start("a")
+
+			result, err := f.TriggerEx(c.evt)
+			if result != c.want || err != c.wantErr {
+				t.Fatalf("TriggerEx(%q) = %d, %v, want %d, %v", c.evt, result, err, c.want, c.wantErr)
+			}
+
+			if f.GetCurState() != c.state {
+				t.Fatalf("state %s, want %s", f.GetCurState(), c.state)
+			}
+		})
+	}
+}
+
+func TestFSMTriggerNotStarted(t *testing.T) {
+	f := newTestFSM(t, nil, "a", "b")
+	f.AddTransition("a", "go", "b", "")
+
+	result, err := f.TriggerEx("go")
+	if result != TRIGGER_RESULT_NO_TRANSITION || err != ErrNoFirstStat {
+		t.Fatalf("TriggerEx = %d, %v, want no transition, ErrNoFirstStat", result, err)
+	}
+}
+
+func TestFSMEntryExitActionOrder(t *testing.T) {
+	cases := []struct {
+		name    string
+		bResult bool
+		op      func(f *FSM) error
+		want    []string
+	}{
+		{
+			"trigger",
+			true,
+			func(f *FSM) error { return f.Trigger("go") },
+			[]string{"exit a", "action a_exit", "action b_entry", "enter b"},
+		},
+		{
+			"entry result ignored",
+			false,
+			func(f *FSM) error { return f.Trigger("go") },
+			[]string{"exit a", "action a_exit", "action b_entry", "enter b"},
+		},
+		{
+			"pop state",
+			true,
+			func(f *FSM) error {
+				err := f.PushState("b", "")
+				if err != nil {
+					return err
+				}
+
+				return f.PopState()
+			},
+			[]string{"exit a", "action a_exit", "action b_entry", "enter b", "exit b", "action b_exit", "action a_entry", "enter a"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			log := make([]string, 0)
+			f := newTestFSM(t, &log, "a", "b")
+			f.AddTransition("a", "go", "b", "")
+			for _, state := range []string{"a", "b"} {
+				for _, kind := range []string{"entry", "exit"} {
+					name := state + "_" + kind
+					f.AddAction(name, &testLogAction{name: name, bResult: c.bResult, log: &log})
+				}
+
+				f.SetEntryAction(state, state+"_entry")
+				f.SetExitAction(state, state+"_exit")
+			}
+
+			f.Start("a")
+			log = log[:0]
+			err := c.op(f)
+			if err != nil {
+				t.Fatalf("op: %v", err)
+			}
+
+			if !equalTestStrings(log, c.want) {
+				t.Fatalf("log %v, want %v", log, c.want)
+			}
+		})
+	}
+}
+
+func TestFSMEntryActionCleared(t *testing.T) {
+	log := make([]string, 0)
+	f := newTestFSM(t, &log, "a", "b")
+	f.AddTransition("a", "go", "b", "")
+	f.AddAction("act", &testLogAction{name: "act", bResult: true, log: &log})
+	f.SetEntryAction("b", "act")
+	f.SetEntryAction("b", "")
+	f.Start("a")
+	f.Trigger("go")
+
+	want := []string{"enter a", "exit a", "enter b"}
+	if !equalTestStrings(log, want) {
+		t.Fatalf("log %v, want %v", log, want)
+	}
+
+	err := f.SetEntryAction("", "act")
+	if err != ErrNameLenZero {
+		t.Fatalf("SetEntryAction(\"\") = %v, want ErrNameLenZero", err)
+	}
+}
+
+func equalTestStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestFSMStateMaxDwell(t *testing.T) {
+	cases := []struct {
+		name  string
+		maxMs int64
+		dts   []int64
+		state string
+		log   []string
+	}{
+		{"under cap", 100, []int64{60, 30}, "a", []string{}},
+		{"cap reached", 100, []int64{60, 40}, "b", []string{"exit a", "enter b"}},
+		{"cap removed", 0, []int64{60, 60}, "a", []string{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			log := make([]string, 0)
+			f := newTestFSM(t, &log, "a", "b")
+			f.SetStateMaxDwell("a", 100, "b")
+			f.SetStateMaxDwell("a", c.maxMs, "b")
+			f.Start("a")
+			log = log[:0]
+
+			for _, dt := range c.dts {
+				f.Update(dt)
+			}
+
+			if f.GetCurState() != c.state {
+				t.Fatalf("state %s, want %s", f.GetCurState(), c.state)
+			}
+
+			if !equalTestStrings(log, c.log) {
+				t.Fatalf("log %v, want %v", log, c.log)
+			}
+		})
+	}
+}
+
+func TestFSMStateMaxDwellInvalid(t *testing.T) {
+	f := newTestFSM(t, nil, "a")
+	err := f.SetStateMaxDwell("", 100, "a")
+	if err != ErrNameLenZero {
+		t.Fatalf("empty state: %v, want ErrNameLenZero", err)
+	}
+
+	err = f.SetStateMaxDwell("a", 100, "")
+	if err != ErrToStatNotExist {
+		t.Fatalf("empty fallback: %v, want ErrToStatNotExist", err)
+	}
+}
+
+func TestFSMAddStateDuplicate(t *testing.T) {
+	first := &testFsmState{name: "a"}
+	second := &testFsmState{name: "a"}
+	cases := []struct {
+		name    string
+		add     func(f *FSM) error
+		wantErr error
+		want    FSMState
+	}{
+		{"add duplicate", func(f *FSM) error { return f.AddState("a", second) }, ErrStatExist, first},
+		{"replace", func(f *FSM) error { return f.ReplaceState("a", second) }, nil, second},
+		{"replace nil", func(f *FSM) error { return f.ReplaceState("a", nil) }, ErrStatNil, first},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := NewFSM(1)
+			f.AddState("a", first)
+			err := c.add(f)
+			if err != c.wantErr {
+				t.Fatalf("err = %v, want %v", err, c.wantErr)
+			}
+
+			stat, _ := f.GetState("a")
+			if stat != c.want {
+				t.Fatalf("state not kept as wanted")
+			}
+
+			if len(f.ListStates()) != 1 {
+				t.Fatalf("states %v, want one", f.ListStates())
+			}
+		})
+	}
+}
+
+func TestFSMAddActionDuplicate(t *testing.T) {
+	first := &testFsmAction{name: "act"}
+	second := &testFsmAction{name: "act"}
+	cases := []struct {
+		name    string
+		add     func(f *FSM) error
+		wantErr error
+		want    FSMAction
+	}{
+		{"add duplicate", func(f *FSM) error { return f.AddAction("act", second) }, ErrActExist, first},
+		{"replace", func(f *FSM) error { return f.ReplaceAction("act", second) }, nil, second},
+		{"replace nil", func(f *FSM) error { return f.ReplaceAction("act", nil) }, ErrActNil, first},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := NewFSM(1)
+			f.AddAction("act", first)
+			err := c.add(f)
+			if err != c.wantErr {
+				t.Fatalf("err = %v, want %v", err, c.wantErr)
+			}
+
+			act, _ := f.GetAction("act")
+			if act != c.want {
+				t.Fatalf("action not kept as wanted")
+			}
+		})
+	}
+}
+
+func TestFSMThreadSafeHammer(t *testing.T) {
+	f := newTestFSM(t, nil, "a", "b")
+	f.SetThreadSafe(true)
+	f.AddTransition("a", "go", "b", "")
+	f.AddTransition("b", "back", "a", "")
+	f.Start("a")
+
+	ops := []func(i int){
+		func(i int) { f.Trigger("go") },
+		func(i int) { f.Trigger("back") },
+		func(i int) { f.Update(1) },
+		func(i int) { f.GetCurState(); f.GetStateTime(); f.GetHistory() },
+		func(i int) { f.PushState("b", ""); f.PopState() },
+		func(i int) { f.AddTransition("a", "evt"+string(rune('a'+i%26)), "b", "") },
+	}
+
+	wg := sync.WaitGroup{}
+	for _, op := range ops {
+		wg.Add(1)
+		go func(op func(i int)) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				op(i)
+			}
+		}(op)
+	}
+
+	wg.Wait()
+
+	state := f.GetCurState()
+	if state != "a" && state != "b" {
+		t.Fatalf("state %s, want a or b", state)
+	}
+}
+
+func TestFSMStateData(t *testing.T) {
+	cases := []struct {
+		name   string
+		apply  func(f *FSM)
+		want   interface{}
+		bFound bool
+	}{
+		{"set", func(f *FSM) {}, 3, true},
+		{"overwritten", func(f *FSM) { f.SetStateData("a", "x") }, "x", true},
+		{"state removed", func(f *FSM) { f.RemoveState("a") }, nil, false},
+		{"state added again", func(f *FSM) { f.RemoveState("a"); f.AddState("a", &testFsmState{name: "a"}) }, nil, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := newTestFSM(t, nil, "a", "b")
+			f.SetStateData("a", 3)
+			f.SetStateData("b", 4)
+			c.apply(f)
+
+			data, ok := f.GetStateData("a")
+			if ok != c.bFound || data != c.want {
+				t.Fatalf("data %v %v, want %v %v", data, ok, c.want, c.bFound)
+			}
+
+			data, _ = f.GetStateData("b")
+			if data != 4 {
+				t.Fatalf("data of b %v, want 4", data)
+			}
+		})
+	}
+}
+
+func TestFSMListActionsAndTransitions(t *testing.T) {
+	f := newTestFSM(t, nil, "a", "b", "c")
+	for _, name := range []string{"zap", "act", "move"} {
+		f.AddAction(name, &testFsmAction{name: name, bResult: true})
+	}
+
+	f.AddTransition("b", "go", "c", "move")
+	f.AddTransition("a", "go", "b", "act")
+	f.AddTransition("c", "back", "a", "")
+
+	actions := f.ListActions()
+	wantActions := []string{"act", "move", "zap"}
+	if !equalTestStrings(actions, wantActions) {
+		t.Fatalf("actions %v, want %v", actions, wantActions)
+	}
+
+	trans := f.ListTransitions()
+	wantTrans := []string{"b-go->c", "a-go->b", "c-back->a"}
+	if len(trans) != len(wantTrans) {
+		t.Fatalf("%d transitions, want %d", len(trans), len(wantTrans))
+	}
+
+	for i, tran := range trans {
+		got := tran.From + "-" + tran.Event + "->" + tran.To
+		if got != wantTrans[i] {
+			t.Fatalf("transition %d is %s, want %s", i, got, wantTrans[i])
+		}
+	}
+
+	trans[0].To = "a"
+	tran, _ := f.GetTransition("b", "go")
+	if tran.To != "c" {
+		t.Fatalf("listed transition shares the registered one")
+	}
+
+	actions[0] = "x"
+	if f.ListActions()[0] != "act" {
+		t.Fatalf("listed actions share the registered ones")
+	}
+}
+
+func TestFSMListStatesOrder(t *testing.T) {
+	cases := []struct {
+		name  string
+		apply func(f *FSM)
+		want  []string
+	}{
+		{"insertion order", func(f *FSM) {}, []string{"zeta", "alpha", "mid", "beta"}},
+		{"removed", func(f *FSM) { f.RemoveState("alpha") }, []string{"zeta", "mid", "beta"}},
+		{"added again", func(f *FSM) { f.RemoveState("zeta"); f.AddState("zeta", &testFsmState{name: "zeta"}) }, []string{"alpha", "mid", "beta", "zeta"}},
+		{"replaced", func(f *FSM) { f.ReplaceState("mid", &testFsmState{name: "mid"}) }, []string{"zeta", "alpha", "mid", "beta"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := newTestFSM(t, nil, "zeta", "alpha", "mid", "beta")
+			c.apply(f)
+			for i := 0; i < 3; i++ {
+				states := f.ListStates()
+				if !equalTestStrings(states, c.want) {
+					t.Fatalf("states %v, want %v", states, c.want)
+				}
+			}
+		})
+	}
+}
+
+type testFsmActionEx struct {
+	testFsmAction
+	err error
+}
+
+func (a *testFsmActionEx) DoActionEx(evt string, param ...interface{}) (bool, error) {
+	a.calls++
+	return a.bResult, a.err
+}
+
+type testFsmListener struct {
+	blocked []string
+	errs    []error
+}
+
+func (l *testFsmListener) OnTransitionBlocked(from string, evt string, to string, err error) {
+	l.blocked = append(l.blocked, from+"-"+evt+"->"+to)
+	l.errs = append(l.errs, err)
+}
+
+func TestFSMActionExBlocked(t *testing.T) {
+	errNoMana := errors.New("no mana")
+	cases := []struct {
+		name   string
+		act    FSMAction
+		want   TriggerResult
+		blocks int
+		err    error
+	}{
+		{"vetoed with reason", &testFsmActionEx{testFsmAction{name: "act"}, errNoMana}, TRIGGER_RESULT_ACTION_VETOED, 1, errNoMana},
+		{"extended succeeds", &testFsmActionEx{testFsmAction{name: "act", bResult: true}, nil}, TRIGGER_RESULT_TRANSITIONED, 0, nil},
+		{"plain vetoed", &testFsmAction{name: "act"}, TRIGGER_RESULT_ACTION_VETOED, 1, nil},
+		{"plain succeeds", &testFsmAction{name: "act", bResult: true}, TRIGGER_RESULT_TRANSITIONED, 0, nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			listener := &testFsmListener{}
+			f := newTestFSM(t, nil, "a", "b")
+			f.SetListener(listener)
+			f.AddAction("act", c.act)
+			f.AddTransition("a", "cast", "b", "act")
+			f.Start("a")
+
+			result, err := f.TriggerEx("cast")
+			if result != c.want || err != nil {
+				t.Fatalf("TriggerEx = %d, %v, want %d", result, err, c.want)
+			}
+
+			if len(listener.blocked) != c.blocks {
+				t.Fatalf("blocked %v, want %d", listener.blocked, c.blocks)
+			}
+
+			if c.blocks > 0 && (listener.blocked[0] != "a-cast->b" || listener.errs[0] != c.err) {
+				t.Fatalf("blocked %v %v, want a-cast->b %v", listener.blocked, listener.errs, c.err)
+			}
+		})
+	}
+}
+
+func TestFSMGlobalTransition(t *testing.T) {
+	cases := []struct {
+		name    string
+		from    string
+		apply   func(f *FSM)
+		state   string
+		wantErr error
+	}{
+		{"from a normal state", "run", func(f *FSM) {}, "pause", nil},
+		{"from an excluded state", "dead", func(f *FSM) {}, "dead", ErrTranNotExist},
+		{"specific first", "idle", func(f *FSM) {}, "run", nil},
+		{"removed", "run", func(f *FSM) { f.RemoveGlobalTransition("pause") }, "run", ErrTranNotExist},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := newTestFSM(t, nil, "idle", "run", "pause", "dead")
+			f.AddGlobalTransition("pause", "pause", "", "dead")
+			f.AddTransition("idle", "pause", "run", "")
+			c.apply(f)
+			f.Start(c.from)
+
+			err := f.Trigger("pause")
+			if err != c.wantErr || f.GetCurState() != c.state {
+				t.Fatalf("Trigger = %v, state %s, want %v %s", err, f.GetCurState(), c.wantErr, c.state)
+			}
+		})
+	}
+}
+
+func TestFSMEventValidator(t *testing.T) {
+	errNoTarget := errors.New("move needs a target")
+	cases := []struct {
+		name    string
+		evt     string
+		params  []interface{}
+		wantErr error
+		state   string
+		acts    int
+	}{
+		{"missing param", "move", nil, errNoTarget, "idle", 0},
+		{"valid param", "move", []interface{}{3}, nil, "walk", 1},
+		{"other event", "stay", nil, ErrTranNotExist, "idle", 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			act := &testFsmAction{name: "act", bResult: true}
+			f := newTestFSM(t, nil, "idle", "walk")
+			f.AddAction("act", act)
+			f.AddTransition("idle", "move", "walk", "act")
+			f.SetEventValidator("move", func(param ...interface{}) error {
+				if len(param) == 0 {
+					return errNoTarget
+				}
+
+				return nil
+			})
+
+			f.Start("idle")
+			err := f.Trigger(c.evt, c.params...)
+			if err != c.wantErr || f.GetCurState() != c.state || act.calls != c.acts {
+				t.Fatalf("Trigger = %v, state %s, action calls %d, want %v %s %d", err, f.GetCurState(), act.calls, c.wantErr, c.state, c.acts)
+			}
+		})
+	}
+}
+
+func TestFSMEventValidatorRemoved(t *testing.T) {
+	f := newTestFSM(t, nil, "idle", "walk")
+	f.AddTransition("idle", "move", "walk", "")
+	f.SetEventValidator("move", func(param ...interface{}) error { return ErrEvtEmpty })
+	f.SetEventValidator("move", nil)
+	f.Start("idle")
+
+	err := f.Trigger("move")
+	if err != nil || f.GetCurState() != "walk" {
+		t.Fatalf("Trigger = %v, state %s, want walk", err, f.GetCurState())
+	}
+}
+
+// checkTestTransitionIndex checks the transitions of the event map are the
+// listed ones, in the same order. The FSM must have no pattern transition.
+func checkTestTransitionIndex(t *testing.T, f *FSM) {
+	t.Helper()
+
+	count := 0
+	mapKey2Index := make(map[string]int)
+	for _, tran := range f.transitions {
+		key := getFSMTransitionKey(tran.From, tran.Event)
+		indexed := f.mapKey2Transitions[key]
+		idx := mapKey2Index[key]
+		if idx >= len(indexed) || indexed[idx] != tran {
+			t.Fatalf("transition %s -%s-> %s not indexed in order", tran.From, tran.Event, tran.To)
+		}
+
+		mapKey2Index[key]++
+		count++
+	}
+
+	indexed := 0
+	for _, trans := range f.mapKey2Transitions {
+		indexed += len(trans)
+	}
+
+	if indexed != count {
+		t.Fatalf("%d transitions indexed, %d listed", indexed, count)
+	}
+}
+
+func TestFSMTransitionIndex(t *testing.T) {
+	cases := []struct {
+		name  string
+		apply func(f *FSM)
+		to    string
+		bOk   bool
+	}{
+		{"added", func(f *FSM) {}, "b", true},
+		{"first removed", func(f *FSM) { f.RemoveTransition("a", "go") }, "c", true},
+		{"all removed", func(f *FSM) { f.RemoveTransition("a", "go"); f.RemoveTransition("a", "go") }, "", false},
+		{"removed and added", func(f *FSM) { f.RemoveTransition("a", "go"); f.AddTransition("a", "go", "b", "") }, "c", true},
+		{"unknown removed", func(f *FSM) { f.RemoveTransition("x", "go"); f.RemoveTransition("a", "") }, "b", true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := newTestFSM(t, nil, "a", "b", "c")
+			f.AddTransition("a", "go", "b", "")
+			f.AddTransition("b", "go", "c", "")
+			f.AddTransition("a", "go", "c", "")
+			f.AddTransition("c", "back", "a", "")
+			c.apply(f)
+			checkTestTransitionIndex(t, f)
+
+			tran, ok := f.GetTransition("a", "go")
+			if ok != c.bOk || (ok && tran.To != c.to) {
+				t.Fatalf("GetTransition = %v, want %s %v", ok, c.to, c.bOk)
+			}
+
+			f.Start("a")
+			err := f.Trigger("go")
+			if (err == nil) != c.bOk || (c.bOk && f.GetCurState() != c.to) {
+				t.Fatalf("Trigger = %v, state %s, want %s", err, f.GetCurState(), c.to)
+			}
+		})
+	}
+}
+
+func benchmarkFSMTrigger(b *testing.B, stateCount int) {
+	f := NewFSM(1)
+	for i := 0; i < stateCount; i++ {
+		name := "s" + strconv.Itoa(i)
+		f.AddState(name, &testFsmState{name: name})
+	}
+
+	for i := 0; i < stateCount; i++ {
+		from := "s" + strconv.Itoa(i)
+		for j := 0; j < 4; j++ {
+			f.AddTransition(from, "evt"+strconv.Itoa(j), "s"+strconv.Itoa((i+j+1)%stateCount), "")
+		}
+	}
+
+	f.Start("s0")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f.Trigger("evt0")
+	}
+}
+
+// BenchmarkFSMTrigger shows the Trigger throughput doesn't depend on the
+// transition count.
+func BenchmarkFSMTrigger(b *testing.B) {
+	for _, stateCount := range []int{10, 100, 1000} {
+		b.Run(strconv.Itoa(stateCount*4)+"_transitions", func(b *testing.B) {
+			benchmarkFSMTrigger(b, stateCount)
+		})
+	}
+}
+
+// testParamAction records the params of its last action.
+type testParamAction struct {
+	params []interface{}
+}
+
+func (a *testParamAction) GetName() string {
+	return "param"
+}
+
+func (a *testParamAction) DoAction(evt string, param ...interface{}) bool {
+	a.params = param
+	return true
+}
+
+func TestFSMTransitionActionParams(t *testing.T) {
+	cases := []struct {
+		name       string
+		configured []interface{}
+		evtParams  []interface{}
+		want       []interface{}
+	}{
+		{"both", []interface{}{"fire", 2}, []interface{}{7}, []interface{}{7, "fire", 2}},
+		{"configured only", []interface{}{"ice"}, nil, []interface{}{"ice"}},
+		{"event only", nil, []interface{}{1, 2}, []interface{}{1, 2}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			act := &testParamAction{}
+			f := newTestFSM(t, nil, "a", "b")
+			f.AddAction("param", act)
+			f.AddTransitionWithParams("a", "cast", "b", "param", c.configured...)
+			f.Start("a")
+
+			evtParams := append([]interface{}{}, c.evtParams...)
+			err := f.Trigger("cast", evtParams...)
+			if err != nil {
+				t.Fatalf("Trigger: %v", err)
+			}
+
+			if fmt.Sprint(act.params) != fmt.Sprint(c.want) {
+				t.Fatalf("action params %v, want %v", act.params, c.want)
+			}
+
+			if fmt.Sprint(evtParams) != fmt.Sprint(c.evtParams) {
+				t.Fatalf("event params changed to %v", evtParams)
+			}
+		})
+	}
+}
+
+func TestFSMStateUpdateAction(t *testing.T) {
+	log := make([]string, 0)
+	f := newTestFSM(t, nil, "a", "b")
+	f.AddTransition("a", "go", "b", "")
+	f.AddAction("regen", &testLogAction{name: "regen", bResult: true, log: &log})
+	f.SetStateUpdateAction("a", "regen")
+	f.Start("a")
+
+	cases := []struct {
+		name  string
+		apply func()
+		calls int
+	}{
+		{"each tick", func() { f.Update(10); f.Update(10) }, 2},
+		{"after exit", func() { f.Trigger("go"); f.Update(10) }, 2},
+		{"entered again", func() { f.PopState(); f.Update(10) }, 3},
+		{"cleared", func() { f.SetStateUpdateAction("a", ""); f.Update(10) }, 3},
+	}
+
+	for _, c := range cases {
+		c.apply()
+		if len(log) != c.calls {
+			t.Fatalf("%s: update action called %d times, want %d", c.name, len(log), c.calls)
+		}
+	}
+}
+
+func TestFSMStateUpdateActionParam(t *testing.T) {
+	act := &testParamAction{}
+	f := newTestFSM(t, nil, "a")
+	f.AddAction("param", act)
+	f.SetStateUpdateAction("a", "param")
+	f.Start("a")
+	f.Update(25)
+
+	if len(act.params) != 1 || act.params[0] != int64(25) {
+		t.Fatalf("update action params %v, want [25]", act.params)
+	}
+}
+
+func TestFSMHistory(t *testing.T) {
+	f := newTestFSM(t, nil, "idle", "run", "jump")
+	f.AddTransition("idle", "go", "run", "")
+	f.AddTransition("run", "hop", "jump", "")
+	f.RecordHistory(true)
+	f.Start("idle")
+	f.Update(30)
+	f.Trigger("go")
+	f.Update(20)
+	f.Update(5)
+	f.Trigger("hop")
+	f.PopState()
+
+	want := []HistoryEntry{
+		{From: "", To: "idle", Event: "", Time: 0},
+		{From: "idle", To: "run", Event: "go", Time: 30},
+		{From: "run", To: "jump", Event: "hop", Time: 55},
+		{From: "jump", To: "run", Event: "", Time: 55},
+	}
+
+	history := f.GetHistory()
+	if len(history) != len(want) {
+		t.Fatalf("history %v, want %v", history, want)
+	}
+
+	for i := range want {
+		if history[i] != want[i] {
+			t.Fatalf("entry %d is %+v, want %+v", i, history[i], want[i])
+		}
+	}
+
+	history[0].To = "x"
+	if f.GetHistory()[0].To != "idle" {
+		t.Fatalf("returned history shares the recorded one")
+	}
+
+	f.RecordHistory(false)
+	f.Trigger("hop")
+	if len(f.GetHistory()) != 0 {
+		t.Fatalf("history recorded while off")
+	}
+}
+
+func TestFSMSetTransitionEnabled(t *testing.T) {
+	cases := []struct {
+		name     string
+		from     string
+		bEnabled bool
+		wantErr  error
+		evt      string
+		want     string
+	}{
+		{"disabled", "idle", false, nil, "flee", "idle"},
+		{"global still fires", "", true, nil, "die", "dead"},
+		{"enabled", "idle", true, nil, "flee", "flee"},
+		{"global disabled", "", false, nil, "die", "idle"},
+		{"unknown transition", "idle", false, ErrTranNotExist, "run", "idle"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := newTestFSM(t, nil, "idle", "flee", "dead")
+			f.AddTransition("idle", "flee", "flee", "")
+			f.AddGlobalTransition("die", "dead", "")
+			f.Start("idle")
+
+			err := f.SetTransitionEnabled(c.from, c.evt, c.bEnabled)
+			if err != c.wantErr {
+				t.Fatalf("SetTransitionEnabled err %v, want %v", err, c.wantErr)
+			}
+
+			f.Trigger(c.evt)
+			if f.GetCurState() != c.want {
+				t.Fatalf("state %s, want %s", f.GetCurState(), c.want)
+			}
+		})
+	}
+}
+
+func TestFSMSetTransitionEnabledToggle(t *testing.T) {
+	f := newTestFSM(t, nil, "idle", "flee")
+	f.AddTransition("idle", "flee", "flee", "")
+	f.Start("idle")
+
+	f.SetTransitionEnabled("idle", "flee", false)
+	err := f.Trigger("flee")
+	if err == nil || f.GetCurState() != "idle" {
+		t.Fatalf("disabled transition fired, state %s err %v", f.GetCurState(), err)
+	}
+
+	tran, _ := f.GetTransition("idle", "flee")
+	if tran.Enabled {
+		t.Fatalf("transition still enabled")
+	}
+
+	f.SetTransitionEnabled("idle", "flee", true)
+	err = f.Trigger("flee")
+	if err != nil || f.GetCurState() != "flee" {
+		t.Fatalf("re-enabled transition didn't fire, state %s err %v", f.GetCurState(), err)
+	}
+}
+
+func TestFSMTriggerChain(t *testing.T) {
+	cases := []struct {
+		name      string
+		evts      []string
+		want      string
+		succeeded int
+		failEvt   string
+		err       error
+	}{
+		{"whole chain", []string{"die", "respawn", "ready"}, "idle", 0, "", nil},
+		{"empty chain", []string{}, "idle", 0, "", nil},
+		{"stops midway", []string{"die", "ready", "respawn"}, "dead", 1, "ready", ErrTranNotExist},
+		{"stops first", []string{"respawn", "die"}, "idle", 0, "respawn", ErrTranNotExist},
+		{"vetoed", []string{"die", "respawn", "hold"}, "spawning", 2, "hold", ErrActVetoed},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := newTestFSM(t, nil, "idle", "dead", "spawning")
+			f.AddAction("veto", &testFsmAction{name: "veto", bResult: false})
+			f.AddTransition("idle", "die", "dead", "")
+			f.AddTransition("dead", "respawn", "spawning", "")
+			f.AddTransition("spawning", "ready", "idle", "")
+			f.AddTransition("spawning", "hold", "idle", "veto")
+			f.Start("idle")
+
+			err := f.TriggerChain(c.evts...)
+			if f.GetCurState() != c.want {
+				t.Fatalf("state %s, want %s", f.GetCurState(), c.want)
+			}
+
+			if c.err == nil {
+				if err != nil {
+					t.Fatalf("unexpected err %v", err)
+				}
+
+				return
+			}
+
+			var chainErr *FSMChainError
+			if !errors.As(err, &chainErr) || !errors.Is(err, c.err) {
+				t.Fatalf("err %v, want chain error of %v", err, c.err)
+			}
+
+			if chainErr.Succeeded != c.succeeded || chainErr.Event != c.failEvt {
+				t.Fatalf("stopped at %s after %d, want %s after %d", chainErr.Event, chainErr.Succeeded, c.failEvt, c.succeeded)
+			}
+		})
+	}
+}
+
+func TestFSMBlackboardTransition(t *testing.T) {
+	cases := []struct {
+		name  string
+		key   string
+		op    string
+		value interface{}
+		bb    interface{}
+		bFire bool
+	}{
+		{"int less", "hp", BB_OP_LT, 30, 10, true},
+		{"int not less", "hp", BB_OP_LT, 30, 30, false},
+		{"int less equal", "hp", BB_OP_LE, 30, 30, true},
+		{"int greater", "hp", BB_OP_GT, 30, 31, true},
+		{"int greater equal", "hp", BB_OP_GE, 30, 29, false},
+		{"float against int", "hp", BB_OP_LT, 30, 29.5, true},
+		{"int8 equal", "hp", BB_OP_EQ, 30, int8(30), true},
+		{"string equal", "target", BB_OP_EQ, "player", "player", true},
+		{"string not equal", "target", BB_OP_NE, "player", "npc", true},
+		{"string order", "target", BB_OP_LT, "b", "a", true},
+		{"string against int", "target", BB_OP_EQ, "30", 30, false},
+		{"missing key", "mana", BB_OP_LT, 30, nil, false},
+		{"missing key not equal", "mana", BB_OP_NE, 30, nil, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := newTestFSM(t, nil, "idle", "flee")
+			err := f.AddBlackboardTransition("idle", "check", "flee", "", c.key, c.op, c.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if c.bb != nil {
+				f.GetBlackboard().Set(c.key, c.bb)
+			}
+
+			f.Start("idle")
+			err = f.Trigger("check")
+			bFired := f.GetCurState() == "flee"
+			if bFired != c.bFire || (err == nil) != c.bFire {
+				t.Fatalf("fired %v err %v, want fired %v", bFired, err, c.bFire)
+			}
+		})
+	}
+}
+
+func TestFSMBlackboardTransitionBranch(t *testing.T) {
+	f := newTestFSM(t, nil, "idle", "flee", "attack")
+	f.AddBlackboardTransition("idle", "see", "flee", "", "hp", BB_OP_LT, 30)
+	f.AddBlackboardTransition("idle", "see", "attack", "", "hp", BB_OP_GE, 30)
+	err := f.AddBlackboardTransition("idle", "see", "attack", "", "hp", "=<", 30)
+	if err != ErrBBOpInvalid {
+		t.Fatalf("invalid op err %v, want ErrBBOpInvalid", err)
+	}
+
+	for _, c := range []struct {
+		hp   int
+		want string
+	}{
+		{10, "flee"},
+		{80, "attack"},
+	} {
+		f.GetBlackboard().Set("hp", c.hp)
+		f.Start("idle")
+		f.Trigger("see")
+		if f.GetCurState() != c.want {
+			t.Fatalf("hp %d: state %s, want %s", c.hp, f.GetCurState(), c.want)
+		}
+	}
+}
+
+func TestFSMPushState(t *testing.T) {
+	log := make([]string, 0)
+	f := newTestFSM(t, &log, "game", "menu", "inventory")
+	f.AddAction("open", &testLogAction{name: "open", bResult: true, log: &log})
+	f.Start("game")
+
+	cases := []struct {
+		name string
+		op   func() error
+		want string
+		log  []string
+	}{
+		{"push menu", func() error { return f.PushState("menu", "open") }, "menu", []string{"action open", "exit game", "enter menu"}},
+		{"push inventory", func() error { return f.PushState("inventory", "") }, "inventory", []string{"exit menu", "enter inventory"}},
+		{"pop to menu", f.PopState, "menu", []string{"exit inventory", "enter menu"}},
+		{"pop to game", f.PopState, "game", []string{"exit menu", "enter game"}},
+	}
+
+	for _, c := range cases {
+		log = log[:0]
+		err := c.op()
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+
+		if f.GetCurState() != c.want || !equalTestStrings(log, c.log) {
+			t.Fatalf("%s: state %s log %v, want %s %v", c.name, f.GetCurState(), log, c.want, c.log)
+		}
+	}
+
+	if f.PopState() != ErrNoOldStat {
+		t.Fatalf("pop past the bottom of the stack")
+	}
+}
+
+func TestFSMPushStateErrors(t *testing.T) {
+	cases := []struct {
+		name    string
+		bStart  bool
+		state   string
+		action  string
+		wantErr error
+	}{
+		{"not started", false, "menu", "", ErrNoFirstStat},
+		{"unknown state", true, "shop", "", ErrToStatNotExist},
+		{"vetoed", true, "menu", "veto", ErrActVetoed},
+		{"unknown action", true, "menu", "none", nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := newTestFSM(t, nil, "game", "menu")
+			f.AddAction("veto", &testFsmAction{name: "veto", bResult: false})
+			if c.bStart {
+				f.Start("game")
+			}
+
+			before := f.GetCurState()
+			err := f.PushState(c.state, c.action)
+			if err != c.wantErr {
+				t.Fatalf("err %v, want %v", err, c.wantErr)
+			}
+
+			if c.wantErr != nil && f.GetCurState() != before {
+				t.Fatalf("state changed to %s on error", f.GetCurState())
+			}
+		})
+	}
+}
+
+// testFromFsmState records the fromState of its entries.
+type testFromFsmState struct {
+	testFsmState
+	froms []string
+}
+
+func (s *testFromFsmState) OnEnter(fromState string) {
+	s.froms = append(s.froms, fromState)
+}
+
+func newTestLoadFSM() (*FSM, map[string]*testFromFsmState) {
+	f := NewFSM(1)
+	states := make(map[string]*testFromFsmState)
+	for _, name := range []string{"idle", "walk", "run", "menu"} {
+		states[name] = &testFromFsmState{testFsmState: testFsmState{name: name}}
+		f.AddState(name, states[name])
+	}
+
+	f.AddTransition("idle", "go", "walk", "")
+	f.AddTransition("walk", "go", "run", "")
+	f.AddTransition("run", "stop", "idle", "")
+	f.SetEventCooldown("go", 50)
+	f.RecordHistory(true)
+	return f, states
+}
+
+func TestFSMRuntimeRoundTrip(t *testing.T) {
+	src, _ := newTestLoadFSM()
+	src.Start("idle")
+	src.Trigger("go")
+	src.Update(60)
+	src.Trigger("go")
+	src.Update(20)
+	src.PushState("menu", "")
+	src.TriggerDelayed("stop", 100, "p")
+	src.Update(30)
+	r := src.SaveRuntime()
+
+	dst, states := newTestLoadFSM()
+	err := dst.RestoreRuntime(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"state", dst.GetCurState(), "menu"},
+		{"state time", dst.GetStateTime(), src.GetStateTime()},
+		{"total time", dst.GetTotalTime(), int64(110)},
+		{"entry count", dst.GetStateEntryCount("walk"), uint32(1)},
+		{"history", len(dst.GetHistory()), len(src.GetHistory())},
+		{"load entry", fmt.Sprint(states["menu"].froms), "[" + FSM_LOAD_FROM_STATE + "]"},
+	}
+
+	for _, c := range cases {
+		if c.got != c.want {
+			t.Fatalf("%s: %v, want %v", c.name, c.got, c.want)
+		}
+	}
+
+	// both machines behave the same after the load
+	for _, f := range []*FSM{src, dst} {
+		if f.PopState() != nil || f.GetCurState() != "run" {
+			t.Fatalf("pop state %s, want run", f.GetCurState())
+		}
+
+		f.Update(70)
+		if f.GetCurState() != "idle" {
+			t.Fatalf("delayed event: state %s, want idle", f.GetCurState())
+		}
+
+		res, _ := f.TriggerEx("go")
+		if res == TRIGGER_RESULT_COOLDOWN {
+			t.Fatalf("go still cooling at %d", f.GetTotalTime())
+		}
+	}
+
+	r.OldStates[0] = "changed"
+	r.EntryCounts["walk"] = 9
+	if dst.GetStateEntryCount("walk") == 9 {
+		t.Fatalf("restored runtime shares the saved maps")
+	}
+}
+
+func TestFSMRuntimeCooldownSaved(t *testing.T) {
+	src, _ := newTestLoadFSM()
+	src.Start("idle")
+	src.Trigger("go")
+	src.Update(20)
+
+	dst, _ := newTestLoadFSM()
+	dst.RestoreRuntime(src.SaveRuntime())
+	res, _ := dst.TriggerEx("go")
+	if res != TRIGGER_RESULT_COOLDOWN || dst.GetCurState() != "walk" {
+		t.Fatalf("result %d state %s, want cooling in walk", res, dst.GetCurState())
+	}
+}
+
+func TestFSMRestoreRuntimeUnknownState(t *testing.T) {
+	f, states := newTestLoadFSM()
+	f.Start("idle")
+	err := f.RestoreRuntime(FSMRuntime{State: "swim"})
+	if err != ErrStatNotExist || f.GetCurState() != "idle" {
+		t.Fatalf("err %v state %s, want ErrStatNotExist in idle", err, f.GetCurState())
+	}
+
+	if len(states["idle"].froms) != 1 {
+		t.Fatalf("idle entered %d times", len(states["idle"].froms))
+	}
+}
+
+func TestFSMValidate(t *testing.T) {
+	cases := []struct {
+		name        string
+		build       func(f *FSM)
+		unreachable []string
+		bad         []string
+	}{
+		{"fully reachable", func(f *FSM) {
+			f.SetInitialState("idle")
+			f.AddTransition("idle", "go", "walk", "")
+			f.AddTransition("walk", "die", "dead", "")
+		}, []string{}, []string{}},
+		{"orphan state", func(f *FSM) {
+			f.SetInitialState("idle")
+			f.AddTransition("idle", "go", "walk", "")
+		}, []string{"dead"}, []string{}},
+		{"only from orphan", func(f *FSM) {
+			f.SetInitialState("idle")
+			f.AddTransition("idle", "go", "walk", "")
+			f.AddTransition("dead", "go", "walk", "")
+		}, []string{"dead"}, []string{}},
+		{"global reaches", func(f *FSM) {
+			f.SetInitialState("idle")
+			f.AddTransition("idle", "go", "walk", "")
+			f.AddGlobalTransition("die", "dead", "")
+		}, []string{}, []string{}},
+		{"global excepted", func(f *FSM) {
+			f.SetInitialState("idle")
+			f.AddGlobalTransition("die", "dead", "", "idle")
+			f.AddGlobalTransition("go", "walk", "", "idle")
+		}, []string{"walk", "dead"}, []string{}},
+		{"max dwell fallback", func(f *FSM) {
+			f.SetInitialState("idle")
+			f.AddTransition("idle", "go", "walk", "")
+			f.SetStateMaxDwell("walk", 100, "dead")
+		}, []string{}, []string{}},
+		{"current state root", func(f *FSM) {
+			f.Start("walk")
+			f.AddTransition("walk", "die", "dead", "")
+		}, []string{"idle"}, []string{}},
+		{"no root", func(f *FSM) {
+			f.AddTransition("idle", "go", "walk", "")
+		}, []string{"idle", "dead"}, []string{}},
+		{"unknown endpoints", func(f *FSM) {
+			f.SetInitialState("idle")
+			f.AddTransition("idle", "go", "walk", "")
+			f.AddTransition("walk", "die", "dead", "")
+			f.AddTransition("swim", "go", "fly", "")
+			f.AddTransition("walk", "hop", "fly", "")
+			f.AddGlobalTransition("hop", "jump", "")
+		}, []string{}, []string{"swim go fly", "walk hop fly", " hop jump"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := newTestFSM(t, nil, "idle", "walk", "dead")
+			c.build(f)
+			v := f.Validate()
+			if !equalTestStrings(v.UnreachableStates, c.unreachable) {
+				t.Fatalf("unreachable states %q, want %q", v.UnreachableStates, c.unreachable)
+			}
+
+			bad := make([]string, 0, len(v.BadTransitions))
+			for _, tran := range v.BadTransitions {
+				bad = append(bad, tran.From+" "+tran.Event+" "+tran.To)
+			}
+
+			if !equalTestStrings(bad, c.bad) {
+				t.Fatalf("bad transitions %q, want %q", bad, c.bad)
+			}
+
+			if v.IsValid() != (len(c.unreachable) == 0 && len(c.bad) == 0) {
+				t.Fatalf("valid = %v", v.IsValid())
+			}
+		})
+	}
+}
+
+func TestFSMTransitionPriority(t *testing.T) {
+	cases := []struct {
+		name       string
+		priorities []int
+		hp         int
+		want       string
+	}{
+		{"adding order", []int{0, 0, 0}, 10, "flee"},
+		{"highest wins", []int{1, 3, 2}, 10, "attack"},
+		{"highest guard fails", []int{1, 3, 2}, 90, "patrol"},
+		{"only lowest passes", []int{3, 2, 1}, 90, "patrol"},
+		{"tie keeps adding order", []int{0, 5, 5}, 10, "attack"},
+		{"negative priority last", []int{-1, 0, 0}, 10, "attack"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := newTestFSM(t, nil, "idle", "flee", "attack", "patrol")
+			f.AddBlackboardTransition("idle", "see", "flee", "", "hp", BB_OP_LT, 30)
+			f.AddBlackboardTransition("idle", "see", "attack", "", "hp", BB_OP_LT, 80)
+			f.AddBlackboardTransition("idle", "see", "patrol", "", "hp", BB_OP_GE, 0)
+			for i, to := range []string{"flee", "attack", "patrol"} {
+				err := f.SetTransitionPriority("idle", "see", to, c.priorities[i])
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			f.GetBlackboard().Set("hp", c.hp)
+			f.Start("idle")
+			f.Trigger("see")
+			if f.GetCurState() != c.want {
+				t.Fatalf("state %s, want %s", f.GetCurState(), c.want)
+			}
+		})
+	}
+}
+
+func TestFSMGlobalTransitionPriority(t *testing.T) {
+	f := newTestFSM(t, nil, "idle", "flee", "attack")
+	f.AddGlobalTransition("see", "flee", "")
+	f.AddGlobalTransition("see", "attack", "")
+	f.Start("idle")
+	err := f.SetTransitionPriority("", "see", "attack", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.Trigger("see")
+	if f.GetCurState() != "attack" {
+		t.Fatalf("state %s, want attack", f.GetCurState())
+	}
+
+	allocs := testing.AllocsPerRun(10, func() {
+		f.findTransition("idle", "see")
+	})
+
+	if allocs != 0 {
+		t.Fatalf("findTransition allocated %v times", allocs)
+	}
+}
+
+func TestFSMSetTransitionPriorityUnknown(t *testing.T) {
+	f := newTestFSM(t, nil, "idle", "flee")
+	f.AddTransition("idle", "see", "flee", "")
+	f.AddGlobalTransition("die", "flee", "")
+	for _, tran := range []struct{ from, evt, to string }{
+		{"idle", "see", "idle"},
+		{"idle", "hear", "flee"},
+		{"", "die", "idle"},
+	} {
+		err := f.SetTransitionPriority(tran.from, tran.evt, tran.to, 1)
+		if err != ErrTranNotExist {
+			t.Fatalf("%v: err %v, want ErrTranNotExist", tran, err)
+		}
+	}
+
+	if f.SetTransitionPriority("", "die", "flee", 1) != nil {
+		t.Fatalf("global transition priority not set")
+	}
+}
+
+const (
+	TEST_STAT_IDLE uint32 = iota + 1
+	TEST_STAT_WALK
+	TEST_STAT_RUN
+)
+
+const (
+	TEST_EVT_GO uint32 = iota + 1
+	TEST_EVT_STOP
+)
+
+func newTestIDFSM(t *testing.T) *FSM {
+	f := newTestFSM(t, nil, "idle", "walk", "run")
+	for id, name := range map[uint32]string{TEST_STAT_IDLE: "idle", TEST_STAT_WALK: "walk", TEST_STAT_RUN: "run"} {
+		if err := f.RegisterStateID(id, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f.RegisterEventID(TEST_EVT_GO, "go")
+	f.RegisterEventID(TEST_EVT_STOP, "stop")
+	f.AddTransitionID(TEST_STAT_IDLE, TEST_EVT_GO, TEST_STAT_WALK, "")
+	f.AddTransition("walk", "go", "run", "")
+	f.AddTransitionID(TEST_STAT_RUN, TEST_EVT_STOP, TEST_STAT_IDLE, "")
+	return f
+}
+
+func TestFSMTriggerID(t *testing.T) {
+	cases := []struct {
+		name    string
+		trigger func(f *FSM) error
+		want    string
+	}{
+		{"id on id transition", func(f *FSM) error { return f.TriggerID(TEST_EVT_GO) }, "walk"},
+		{"id on string transition", func(f *FSM) error { return f.TriggerID(TEST_EVT_GO) }, "run"},
+		{"string on id transition", func(f *FSM) error { return f.Trigger("stop") }, "idle"},
+		{"string after id", func(f *FSM) error { return f.Trigger("go") }, "walk"},
+	}
+
+	f := newTestIDFSM(t)
+	f.Start("idle")
+	for _, c := range cases {
+		err := c.trigger(f)
+		if err != nil || f.GetCurState() != c.want {
+			t.Fatalf("%s: state %s err %v, want %s", c.name, f.GetCurState(), err, c.want)
+		}
+	}
+
+	tran, ok := f.GetTransition("idle", "go")
+	if !ok || tran.To != "walk" {
+		t.Fatalf("id transition not in the string store")
+	}
+}
+
+func TestFSMIDErrors(t *testing.T) {
+	f := newTestIDFSM(t)
+	f.Start("idle")
+	cases := []struct {
+		name    string
+		err     error
+		wantErr error
+	}{
+		{"state id taken", f.RegisterStateID(TEST_STAT_IDLE, "other"), ErrStatIDExist},
+		{"event id taken", f.RegisterEventID(TEST_EVT_GO, "other"), ErrEvtIDExist},
+		{"empty state name", f.RegisterStateID(99, ""), ErrNameLenZero},
+		{"empty event", f.RegisterEventID(99, ""), ErrEvtEmpty},
+		{"unknown from", f.AddTransitionID(99, TEST_EVT_GO, TEST_STAT_WALK, ""), ErrStatIDNotExist},
+		{"unknown event", f.AddTransitionID(TEST_STAT_IDLE, 99, TEST_STAT_WALK, ""), ErrEvtIDNotExist},
+		{"unknown to", f.AddTransitionID(TEST_STAT_IDLE, TEST_EVT_STOP, 99, ""), ErrStatIDNotExist},
+		{"trigger unknown", f.TriggerID(99), ErrEvtIDNotExist},
+		{"no transition", f.TriggerID(TEST_EVT_STOP), ErrTranNotExist},
+	}
+
+	for _, c := range cases {
+		if c.err != c.wantErr {
+			t.Fatalf("%s: err %v, want %v", c.name, c.err, c.wantErr)
+		}
+	}
+
+	if name, _ := f.GetStateName(TEST_STAT_IDLE); name != "idle" || f.GetCurState() != "idle" {
+		t.Fatalf("failed calls changed the FSM")
+	}
+}
+
+// testResetFsmState counts its OnReset calls.
+type testResetFsmState struct {
+	testFsmState
+	resets int
+}
+
+func (s *testResetFsmState) OnReset() {
+	s.resets++
+}
+
+func TestFSMResetCallsOnReset(t *testing.T) {
+	log := make([]string, 0)
+	f := NewFSM(1)
+	resettable := make([]*testResetFsmState, 0)
+	for _, name := range []string{"idle", "walk", "run"} {
+		s := &testResetFsmState{testFsmState: testFsmState{name: name, log: &log}}
+		resettable = append(resettable, s)
+		f.AddState(name, s)
+	}
+
+	f.AddState("plain", &testFsmState{name: "plain", log: &log})
+	f.AddTransition("idle", "go", "walk", "")
+	f.Start("idle")
+	f.Trigger("go")
+
+	cases := []struct {
+		name   string
+		resets int
+	}{
+		{"first reset", 1},
+		{"second reset", 2},
+	}
+
+	for _, c := range cases {
+		log = log[:0]
+		f.Reset()
+		for _, s := range resettable {
+			if s.resets != c.resets {
+				t.Fatalf("%s: %s reset %d times, want %d", c.name, s.name, s.resets, c.resets)
+			}
+		}
+
+		if len(log) != 0 || f.GetCurState() != "" {
+			t.Fatalf("%s: reset entered or exited states %v, state %q", c.name, log, f.GetCurState())
+		}
+	}
+}
+
+func TestFSMForceState(t *testing.T) {
+	cases := []struct {
+		name    string
+		from    string
+		to      string
+		wantErr error
+		log     []string
+	}{
+		{"no transition", "idle", "cutscene", nil, []string{"exit idle", "enter cutscene"}},
+		{"same state", "idle", "idle", nil, []string{"exit idle", "enter idle"}},
+		{"guarded away", "walk", "cutscene", nil, []string{"exit walk", "enter cutscene"}},
+		{"unknown state", "idle", "swim", ErrToStatNotExist, []string{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			log := make([]string, 0)
+			f := newTestFSM(t, &log, "idle", "walk", "cutscene")
+			act := &testFsmAction{name: "veto", bResult: false}
+			f.AddAction("veto", act)
+			f.AddBlackboardTransition("walk", "film", "cutscene", "veto", "ready", BB_OP_EQ, true)
+			f.RecordHistory(true)
+			f.Start(c.from)
+			log = log[:0]
+
+			err := f.ForceState(c.to)
+			if err != c.wantErr || !equalTestStrings(log, c.log) {
+				t.Fatalf("err %v log %v, want %v %v", err, log, c.wantErr, c.log)
+			}
+
+			if c.wantErr != nil {
+				return
+			}
+
+			if f.GetCurState() != c.to || act.calls != 0 {
+				t.Fatalf("state %s action calls %d, want %s 0", f.GetCurState(), act.calls, c.to)
+			}
+
+			h := f.GetHistory()
+			if len(h) == 0 || h[len(h)-1].From != c.from || h[len(h)-1].To != c.to {
+				t.Fatalf("history %v", h)
+			}
+
+			err = f.PopState()
+			if err != nil || f.GetCurState() != c.from {
+				t.Fatalf("pop state %s err %v, want %s", f.GetCurState(), err, c.from)
+			}
+		})
+	}
+}
+
+func TestFSMEventCooldown(t *testing.T) {
+	T, C := TRIGGER_RESULT_TRANSITIONED, TRIGGER_RESULT_COOLDOWN
+	cases := []struct {
+		name string
+		dts  []int64
+		want []TriggerResult
+	}{
+		{"twice quickly", []int64{0, 0}, []TriggerResult{T, C}},
+		{"inside window", []int64{0, 99}, []TriggerResult{T, C}},
+		{"window elapsed", []int64{0, 100}, []TriggerResult{T, T}},
+		{"cooling restarts at use", []int64{0, 60, 60, 40}, []TriggerResult{T, C, T, C}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := newTestFSM(t, nil, "ground", "air")
+			f.AddTransition("ground", "jump", "air", "")
+			f.AddTransition("air", "jump", "ground", "")
+			f.SetEventCooldown("jump", 100)
+			f.Start("ground")
+
+			for i, dt := range c.dts {
+				f.Update(dt)
+				before := f.GetCurState()
+				res, err := f.TriggerEx("jump")
+				if err != nil || res != c.want[i] {
+					t.Fatalf("trigger %d: result %v err %v, want %v", i, res, err, c.want[i])
+				}
+
+				if res == C && f.GetCurState() != before {
+					t.Fatalf("trigger %d: cooling event changed state", i)
+				}
+			}
+		})
+	}
+}
+
+func TestFSMEventCooldownUse(t *testing.T) {
+	cases := []struct {
+		name  string
+		setup func(f *FSM)
+		want  TriggerResult
+	}{
+		{"failed trigger doesn't cool", func(f *FSM) { f.Trigger("jump") }, TRIGGER_RESULT_TRANSITIONED},
+		{"vetoed trigger doesn't cool", func(f *FSM) {
+			f.AddTransition("ground", "jump", "air", "veto")
+			f.Trigger("jump")
+			f.RemoveTransition("ground", "jump")
+		}, TRIGGER_RESULT_TRANSITIONED},
+		{"other event doesn't cool", func(f *FSM) {
+			f.AddTransition("ground", "duck", "ground", "")
+			f.Trigger("duck")
+		}, TRIGGER_RESULT_TRANSITIONED},
+		{"cooldown removed", func(f *FSM) {
+			f.AddTransition("ground", "jump", "ground", "")
+			f.Trigger("jump")
+			f.RemoveTransition("ground", "jump")
+			f.SetEventCooldown("jump", 0)
+		}, TRIGGER_RESULT_TRANSITIONED},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := newTestFSM(t, nil, "ground", "air")
+			f.AddAction("veto", &testFsmAction{name: "veto", bResult: false})
+			f.SetEventCooldown("jump", 100)
+			f.Start("ground")
+			c.setup(f)
+
+			f.AddTransition("ground", "jump", "air", "")
+			res, _ := f.TriggerEx("jump")
+			if res != c.want {
+				t.Fatalf("result %v, want %v", res, c.want)
+			}
+		})
+	}
+}
+
+// testFsmStep triggers evt, or updates the FSM by dt if evt is empty.
+type testFsmStep struct {
+	evt string
+	dt  int64
+}
+
+func TestFSMStateWatchdog(t *testing.T) {
+	cases := []struct {
+		name  string
+		steps []testFsmStep
+		fires int
+	}{
+		{"below threshold", []testFsmStep{{"", 60}, {"", 39}}, 0},
+		{"past threshold", []testFsmStep{{"", 60}, {"", 60}}, 1},
+		{"fires once", []testFsmStep{{"", 100}, {"", 100}, {"", 100}}, 1},
+		{"rearmed on entry", []testFsmStep{{"", 100}, {"leave", 0}, {"back", 0}, {"", 100}}, 2},
+		{"time resets on entry", []testFsmStep{{"", 60}, {"leave", 0}, {"back", 0}, {"", 60}}, 0},
+		{"other state ignored", []testFsmStep{{"leave", 0}, {"", 200}}, 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := newTestFSM(t, nil, "path", "idle")
+			f.AddTransition("path", "leave", "idle", "")
+			f.AddTransition("idle", "back", "path", "")
+			stucks := make([]string, 0)
+			err := f.SetStateWatchdog("path", 100, func(fsmId uint32, state string) {
+				stucks = append(stucks, fmt.Sprintf("%d %s", fsmId, state))
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f.Start("path")
+			for _, step := range c.steps {
+				if len(step.evt) > 0 {
+					f.Trigger(step.evt)
+				} else {
+					f.Update(step.dt)
+				}
+			}
+
+			if len(stucks) != c.fires {
+				t.Fatalf("fired %d times, want %d", len(stucks), c.fires)
+			}
+
+			if c.fires > 0 && stucks[0] != "1 path" {
+				t.Fatalf("fired with %s, want 1 path", stucks[0])
+			}
+		})
+	}
+}
+
+func TestFSMStateWatchdogRemove(t *testing.T) {
+	fires := 0
+	onStuck := func(fsmId uint32, state string) { fires++ }
+	cases := []struct {
+		name    string
+		maxMs   int64
+		onStuck FSMWatchdogFunc
+	}{
+		{"zero max", 0, onStuck},
+		{"nil callback", 100, nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := newTestFSM(t, nil, "path")
+			f.SetStateWatchdog("path", 100, onStuck)
+			f.SetStateWatchdog("path", c.maxMs, c.onStuck)
+			f.Start("path")
+			f.Update(500)
+			if fires != 0 {
+				t.Fatalf("removed watchdog fired")
+			}
+		})
+	}
+
+	if newTestFSM(t, nil).SetStateWatchdog("", 100, onStuck) != ErrNameLenZero {
+		t.Fatalf("watchdog of an empty state name set")
+	}
+}
+
+func TestFSMMultiEventTransition(t *testing.T) {
+	cases := []struct {
+		name    string
+		evt     string
+		want    string
+		wantErr error
+	}{
+		{"melee", "hit_melee", "flinch", nil},
+		{"ranged", "hit_ranged", "flinch", nil},
+		{"other event", "hit_magic", "idle", ErrTranNotExist},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := newTestFSM(t, nil, "idle", "flinch")
+			act := &testFsmAction{name: "stagger", bResult: true}
+			f.AddAction("stagger", act)
+			err := f.AddMultiEventTransition("idle", []string{"hit_melee", "hit_ranged"}, "flinch", "stagger")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f.Start("idle")
+			err = f.Trigger(c.evt)
+			if err != c.wantErr || f.GetCurState() != c.want {
+				t.Fatalf("state %s err %v, want %s %v", f.GetCurState(), err, c.want, c.wantErr)
+			}
+
+			tran, ok := f.GetTransition("idle", c.evt)
+			if ok != (c.wantErr == nil) || (ok && (tran.To != "flinch" || tran.Action != "stagger")) {
+				t.Fatalf("GetTransition = %v %v", tran, ok)
+			}
+
+			if (act.calls == 1) != (c.wantErr == nil) {
+				t.Fatalf("action called %d times", act.calls)
+			}
+		})
+	}
+}
+
+func TestFSMMultiEventTransitionInvalid(t *testing.T) {
+	cases := []struct {
+		name string
+		evts []string
+	}{
+		{"no event", nil},
+		{"empty event", []string{"hit_melee", ""}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := newTestFSM(t, nil, "idle", "flinch")
+			err := f.AddMultiEventTransition("idle", c.evts, "flinch", "")
+			if err != ErrEvtEmpty {
+				t.Fatalf("err %v, want ErrEvtEmpty", err)
+			}
+
+			if len(f.ListTransitions()) != 0 {
+				t.Fatalf("transitions added: %v", f.ListTransitions())
+			}
+		})
+	}
+}
+
+func TestFSMTriggerDelayed(t *testing.T) {
+	cases := []struct {
+		name  string
+		steps []func(f *FSM)
+		want  []string
+	}{
+		{"fires after delay", []func(f *FSM){
+			func(f *FSM) { f.TriggerDelayed("start_cast", 100) },
+			func(f *FSM) { f.Update(60) },
+			func(f *FSM) { f.Update(40) },
+		}, []string{"idle", "idle", "casting"}},
+		{"one long update", []func(f *FSM){
+			func(f *FSM) { f.TriggerDelayed("start_cast", 100) },
+			func(f *FSM) { f.Update(500) },
+		}, []string{"idle", "casting"}},
+		{"cancelled", []func(f *FSM){
+			func(f *FSM) { f.TriggerDelayed("start_cast", 100) },
+			func(f *FSM) { f.Update(50) },
+			func(f *FSM) { f.CancelDelayed("start_cast") },
+			func(f *FSM) { f.Update(100) },
+		}, []string{"idle", "idle", "idle", "idle"}},
+		{"superseded", []func(f *FSM){
+			func(f *FSM) { f.TriggerDelayed("start_cast", 100) },
+			func(f *FSM) { f.Update(50) },
+			func(f *FSM) { f.TriggerDelayed("start_cast", 100) },
+			func(f *FSM) { f.Update(50) },
+			func(f *FSM) { f.Update(50) },
+		}, []string{"idle", "idle", "idle", "idle", "casting"}},
+		{"due order", []func(f *FSM){
+			func(f *FSM) { f.TriggerDelayed("interrupt", 80) },
+			func(f *FSM) { f.TriggerDelayed("start_cast", 50) },
+			func(f *FSM) { f.Update(100) },
+		}, []string{"idle", "idle", "idle"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := newTestFSM(t, nil, "idle", "casting")
+			f.AddTransition("idle", "start_cast", "casting", "")
+			f.AddTransition("casting", "interrupt", "idle", "")
+			f.Start("idle")
+			for i, step := range c.steps {
+				step(f)
+				if f.GetCurState() != c.want[i] {
+					t.Fatalf("step %d: state %s, want %s", i, f.GetCurState(), c.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFSMTriggerDelayedParams(t *testing.T) {
+	f := newTestFSM(t, nil, "idle", "casting")
+	act := &testParamAction{}
+	f.AddAction("cast", act)
+	f.AddTransition("idle", "start_cast", "casting", "cast")
+	f.Start("idle")
+
+	if f.TriggerDelayed("", 10) != ErrEvtEmpty {
+		t.Fatalf("empty event scheduled")
+	}
+
+	if f.CancelDelayed("start_cast") {
+		t.Fatalf("cancelled an event not scheduled")
+	}
+
+	f.TriggerDelayed("start_cast", 10, "fireball", 3)
+	f.Update(10)
+	if f.GetCurState() != "casting" || fmt.Sprint(act.params) != "[fireball 3]" {
+		t.Fatalf("state %s params %v, want casting [fireball 3]", f.GetCurState(), act.params)
+	}
+}
+
+func TestFSMTriggerDelayedRescheduleInDispatch(t *testing.T) {
+	f := newTestFSM(t, nil, "idle")
+	calls := 0
+	f.SetUnhandledEventHandler(func(fsmId uint32, state string, evt string, param ...interface{}) {
+		calls++
+		f.TriggerDelayed(evt, 0)
+	})
+
+	f.Start("idle")
+	f.TriggerDelayed("poll", 0)
+	for i := 1; i <= 3; i++ {
+		f.Update(10)
+		if calls != i {
+			t.Fatalf("update %d: handler called %d times, want %d", i, calls, i)
+		}
+	}
+}
+
+func TestIsFSMEventMatched(t *testing.T) {
+	cases := []struct {
+		pattern string
+		evt     string
+		want    bool
+	}{
+		{"ui.*", "ui.open", true},
+		{"ui.*", "ui.open.inventory", true},
+		{"ui.*", "ui.", true},
+		{"ui.*", "ui", false},
+		{"ui.*", "combat.start", false},
+		{"ui.*", "ui.*", false},
+		{"*", "anything", true},
+		{"ui.open", "ui.open", true},
+		{"ui.open", "ui.opened", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.pattern+" "+c.evt, func(t *testing.T) {
+			if got := isFSMEventMatched(c.pattern, c.evt); got != c.want {
+				t.Fatalf("matched %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestFSMPatternTransitionPrecedence(t *testing.T) {
+	cases := []struct {
+		name  string
+		build func(f *FSM)
+		want  string
+	}{
+		{"global event wins", func(f *FSM) {
+			f.AddPatternTransition("idle", "ui.*", "ui", "")
+			f.AddGlobalTransition("ui.open", "menu", "")
+		}, "menu"},
+		{"disabled falls to shorter", func(f *FSM) {
+			f.AddPatternTransition("idle", "ui.*", "ui", "")
+			f.AddPatternTransition("idle", "ui.open*", "menu", "")
+			f.transitions[1].Enabled = false
+		}, "ui"},
+		{"other state's pattern", func(f *FSM) {
+			f.AddPatternTransition("menu", "ui.*", "ui", "")
+		}, "idle"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := newTestFSM(t, nil, "idle", "ui", "menu")
+			c.build(f)
+			f.Start("idle")
+			f.Trigger("ui.open")
+			if f.GetCurState() != c.want {
+				t.Fatalf("state %s, want %s", f.GetCurState(), c.want)
+			}
+		})
+	}
+}
+
+func TestFSMPatternTransitionListed(t *testing.T) {
+	f := newTestFSM(t, nil, "idle", "ui")
+	f.AddTransition("idle", "go", "ui", "")
+	f.AddPatternTransition("idle", "ui.*", "ui", "")
+
+	trans := f.ListTransitions()
+	if len(trans) != 2 || trans[0].Pattern || !trans[1].Pattern {
+		t.Fatalf("transitions %+v, want go exact and ui.* pattern", trans)
+	}
+
+	f.AddPatternTransition("idle", "ui.*", "missing", "")
+	v := f.Validate()
+	if len(v.BadTransitions) != 1 || !v.BadTransitions[0].Pattern {
+		t.Fatalf("bad transitions %+v, want the ui.* pattern", v.BadTransitions)
+	}
+}
+
+func TestFSMPatternTransitionPriorityEnabled(t *testing.T) {
+	f := newTestFSM(t, nil, "idle", "ui", "menu")
+	f.AddPatternTransition("idle", "ui.*", "ui", "")
+	f.AddPatternTransition("idle", "ui.*", "menu", "")
+
+	if err := f.SetTransitionPriority("idle", "ui.*", "menu", 1); err != nil {
+		t.Fatalf("SetTransitionPriority: %v", err)
+	}
+
+	f.Start("idle")
+	f.Trigger("ui.open")
+	if f.GetCurState() != "menu" {
+		t.Fatalf("state = %s, want menu", f.GetCurState())
+	}
+
+	if err := f.SetTransitionEnabled("idle", "ui.*", false); err != nil {
+		t.Fatalf("SetTransitionEnabled: %v", err)
+	}
+
+	f.Start("idle")
+	if err := f.Trigger("ui.open"); err != ErrTranNotExist {
+		t.Fatalf("err = %v, want %v", err, ErrTranNotExist)
+	}
+}
+
+func TestFSMAddPatternTransitionInvalid(t *testing.T) {
+	cases := []struct {
+		name              string
+		from, pattern, to string
+		wantErr           error
+	}{
+		{"no from", "", "ui.*", "ui", ErrFromStatNotExist},
+		{"no pattern", "idle", "", "ui", ErrEvtEmpty},
+		{"no to", "idle", "ui.*", "", ErrToStatNotExist},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := newTestFSM(t, nil, "idle", "ui")
+			err := f.AddPatternTransition(c.from, c.pattern, c.to, "")
+			if err != c.wantErr || len(f.ListTransitions()) != 0 {
+				t.Fatalf("err %v transitions %d, want %v 0", err, len(f.ListTransitions()), c.wantErr)
+			}
+		})
+	}
+}
+
+func TestFSMUnhandledEventHandler(t *testing.T) {
+	cases := []struct {
+		name    string
+		evt     string
+		want    string
+		wantErr error
+	}{
+		{"unknown event", "jump", "[1 idle jump [5]]", ErrTranNotExist},
+		{"handled event", "walk", "[]", nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := newTestFSM(t, nil, "idle", "walking")
+			f.AddTransition("idle", "walk", "walking", "")
+			f.Start("idle")
+			calls := make([]string, 0)
+			f.SetUnhandledEventHandler(func(fsmId uint32, state string, evt string, param ...interface{}) {
+				calls = append(calls, fmt.Sprint(fsmId, " ", state, " ", evt, " ", param))
+			})
+
+			err := f.Trigger(c.evt, 5)
+			if err != c.wantErr {
+				t.Fatalf("err = %v, want %v", err, c.wantErr)
+			}
+
+			got := fmt.Sprint(calls)
+			if got != c.want {
+				t.Fatalf("handler calls %s, want %s", got, c.want)
+			}
+		})
+	}
+}
+
+func TestFSMUnhandledEventHandlerRemoved(t *testing.T) {
+	f := newTestFSM(t, nil, "idle")
+	f.Start("idle")
+	f.SetUnhandledEventHandler(func(fsmId uint32, state string, evt string, param ...interface{}) {
+		t.Fatalf("removed handler called for %s", evt)
+	})
+
+	f.SetUnhandledEventHandler(nil)
+	f.Trigger("jump")
+}
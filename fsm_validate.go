@@ -0,0 +1,100 @@
+// Copyright 2022 Guan Jianchang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ai
+
+// FSMValidation is the result of FSM.Validate.
+type FSMValidation struct {
+	// UnreachableStates are the names of the states unreachable from the
+	// initial state, in adding order.
+	UnreachableStates []string
+	// BadTransitions are copies of the transitions with an unknown from or
+	// to state, a global transition has an empty From.
+	BadTransitions []*FSMTransition
+}
+
+// IsValid returns true if no problem is found.
+func (v *FSMValidation) IsValid() bool {
+	return len(v.UnreachableStates) == 0 && len(v.BadTransitions) == 0
+}
+
+// Validate returns the transitions with unknown states and the states
+// unreachable from the initial state, the current state if no initial one.
+// A global transition reaches its state from any state not excepted.
+// Without initial and current state, a state is reachable if it is the
+// target of a transition.
+func (f *FSM) Validate() *FSMValidation {
+	f.rlockData()
+	defer f.runlockData()
+
+	v := &FSMValidation{
+		UnreachableStates: make([]string, 0),
+		BadTransitions:    make([]*FSMTransition, 0),
+	}
+
+	for _, tran := range f.transitions {
+		_, fromOk := f.mapName2State[tran.From]
+		_, toOk := f.mapName2State[tran.To]
+		if !fromOk || !toOk {
+			v.BadTransitions = append(v.BadTransitions, copyFSMTransition(tran))
+		}
+	}
+
+	for _, g := range f.globalTransitions {
+		_, ok := f.mapName2State[g.tran.To]
+		if !ok {
+			v.BadTransitions = append(v.BadTransitions, copyFSMTransition(g.tran))
+		}
+	}
+
+	root := f.initState
+	if len(root) == 0 {
+		root = f.state
+	}
+
+	mapState2Reached := make(map[string]bool)
+	if len(root) != 0 {
+		f.reachStates(root, mapState2Reached)
+	} else {
+		for _, tran := range f.transitions {
+			mapState2Reached[tran.To] = true
+		}
+
+		for _, g := range f.globalTransitions {
+			mapState2Reached[g.tran.To] = true
+		}
+	}
+
+	for _, name := range f.stateNames {
+		if !mapState2Reached[name] {
+			v.UnreachableStates = append(v.UnreachableStates, name)
+		}
+	}
+
+	return v
+}
+
+func (f *FSM) reachStates(name string, mapState2Reached map[string]bool) {
+	if mapState2Reached[name] {
+		return
+	}
+
+	mapState2Reached[name] = true
+	for _, tran := range f.transitions {
+		if tran.From == name {
+			f.reachStates(tran.To, mapState2Reached)
+		}
+	}
+
+	for _, g := range f.globalTransitions {
+		if !g.mapExcept[name] {
+			f.reachStates(g.tran.To, mapState2Reached)
+		}
+	}
+
+	dwell, ok := f.mapState2Dwell[name]
+	if ok {
+		f.reachStates(dwell.fallback, mapState2Reached)
+	}
+}